@@ -39,6 +39,13 @@ func main() {
 }
 
 func run() error {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		return app.Migrate(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		return app.Reencrypt()
+	}
+
 	application, err := app.New()
 	if err != nil {
 		return err
@@ -2,20 +2,31 @@ package app
 
 import (
 	"context"
+	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/muflihunaf/boilerplate-go/internal/config"
+	"github.com/muflihunaf/boilerplate-go/internal/event"
 	"github.com/muflihunaf/boilerplate-go/internal/handler"
 	"github.com/muflihunaf/boilerplate-go/internal/repository"
 	"github.com/muflihunaf/boilerplate-go/internal/server"
 	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/fieldcrypt"
+	"github.com/muflihunaf/boilerplate-go/pkg/jsonschema"
 	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
+
+	_ "modernc.org/sqlite" // database/sql driver for STORE_DRIVER=sqlite
 )
 
 // App holds all application dependencies.
@@ -23,6 +34,12 @@ type App struct {
 	cfg    *config.Config
 	log    *slog.Logger
 	server *server.Server
+	store  repository.Store
+	purger *service.Purger
+	events *event.Dispatcher
+
+	// background tracks goroutines that must stop before the store closes.
+	background sync.WaitGroup
 }
 
 // New creates a new application instance.
@@ -33,27 +50,216 @@ func New() (*App, error) {
 	}
 
 	log := setupLogger(cfg)
-	jwtSvc := jwt.NewService(jwt.Config{
+	jwtCfg := jwt.Config{
 		Secret:     cfg.JWTSecret,
 		Expiration: cfg.JWTExpiration,
 		Issuer:     cfg.JWTIssuer,
-	})
+	}
+	if cfg.JWTSigningKey != "" {
+		if err := loadJWTKeys(cfg, &jwtCfg); err != nil {
+			return nil, err
+		}
+	}
+	jwtSvc := jwt.NewService(jwtCfg)
 
 	// Wire dependencies
-	repo := repository.New()
+	repo, err := openStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var cacheStats func() repository.CacheStats
+	if cfg.CacheSize > 0 {
+		cache := repository.NewCachedStore(repo, repository.CacheOptions{Size: cfg.CacheSize, TTL: cfg.CacheTTL})
+		repo, cacheStats = cache, cache.Stats
+	}
 	svc := service.New(repo)
-	authSvc := service.NewAuthService(repo, jwtSvc, cfg.JWTExpiration)
-	h := handler.New(svc, authSvc)
+	if cfg.MetadataSchemaFile != "" {
+		schema, err := loadMetadataSchema(cfg.MetadataSchemaFile)
+		if err != nil {
+			return nil, err
+		}
+		svc.SetMetadataSchema(schema)
+	}
+	authSvc := service.NewAuthService(repo, jwtSvc, cfg.JWTExpiration, cfg.RefreshTokenExpiration)
+	passwords := passwordHasher(cfg)
+	svc.SetPasswordHasher(passwords)
+	authSvc.SetPasswordHasher(passwords)
+	authSvc.SetAdminEmails(cfg.AdminEmails)
+	orgSvc := service.NewOrgService(repo, authSvc, jwtSvc, cfg.InviteExpiration)
+	h := handler.New(svc, authSvc, orgSvc, handler.Options{
+		RequireIfMatch:     cfg.RequireIfMatch,
+		ErasureGracePeriod: cfg.ErasureGracePeriod,
+		CacheStats:         cacheStats,
+	})
+
+	// Subscribers that react to user changes are registered here. Each name
+	// keeps its own position in the outbox, so renaming one replays history.
+	events := event.NewDispatcher(repo, event.Options{PollInterval: cfg.EventPollInterval}, log)
+	events.Subscribe("log", event.LogHandler(log))
 
 	return &App{
 		cfg:    cfg,
 		log:    log,
-		server: server.New(cfg, h, jwtSvc, log),
+		server: server.New(cfg, h, jwtSvc, authSvc, log),
+		store:  repo,
+		purger: service.NewPurger(svc, cfg.UserRetention, cfg.PurgeInterval, log),
+		events: events,
 	}, nil
 }
 
+// openStore returns the user store selected by STORE_DRIVER.
+func openStore(cfg *config.Config) (repository.Store, error) {
+	ids, err := repository.NewIDGenerator(cfg.IDScheme)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := fieldCipher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	passwords := passwordHasher(cfg)
+
+	switch cfg.StoreDriver {
+	case "file":
+		s, err := repository.OpenFileStore(cfg.StorePath, repository.FileOptions{
+			SnapshotEvery: cfg.StoreSnapshotEvery,
+			Fields:        fields,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.SetIDGenerator(ids)
+		s.SetPasswordHasher(passwords)
+		return s, nil
+	case "sqlite":
+		db, err := openDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.DBAutoMigrate {
+			if _, err := repository.MigrateUp(context.Background(), db); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+		s := repository.NewSQLStore(db)
+		s.SetIDGenerator(ids)
+		s.SetFieldCipher(fields)
+		s.SetPasswordHasher(passwords)
+		return s, nil
+	default:
+		s := repository.New()
+		s.SetIDGenerator(ids)
+		s.SetPasswordHasher(passwords)
+		return s, nil
+	}
+}
+
+// fieldCipher builds the user field encryption from config, or returns nil
+// if no keys are configured. The memory driver stores nothing at rest and
+// ignores it.
+func fieldCipher(cfg *config.Config) (*repository.FieldCipher, error) {
+	if len(cfg.FieldEncryptionKeys) == 0 {
+		return nil, nil
+	}
+	keys, err := fieldcrypt.ParseKeys(cfg.FieldEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(cfg.FieldEncryptionIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("FIELD_ENCRYPTION_INDEX_KEY is not valid base64")
+	}
+	keyring, err := fieldcrypt.New(keys, indexKey)
+	if err != nil {
+		return nil, err
+	}
+	return repository.NewFieldCipher(keyring, cfg.EncryptedFields)
+}
+
+// passwordHasher hashes with the configured algorithm and verifies hashes
+// made by either, so that switching PASSWORD_HASHER locks nobody out.
+func passwordHasher(cfg *config.Config) *password.Hasher {
+	argon := password.Argon2id{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		SaltLength:  password.DefaultArgon2id.SaltLength,
+		KeyLength:   password.DefaultArgon2id.KeyLength,
+	}
+	bcrypt := password.Bcrypt{Cost: cfg.BcryptCost}
+	if cfg.PasswordHasher == "bcrypt" {
+		return password.New(bcrypt, argon)
+	}
+	return password.New(argon, bcrypt)
+}
+
+// loadJWTKeys loads the key pair that signs tokens and the keys that only
+// verify them.
+func loadJWTKeys(cfg *config.Config, jwtCfg *jwt.Config) error {
+	key, err := jwt.LoadKeyFile(cfg.JWTSigningKey)
+	if err != nil {
+		return fmt.Errorf("JWT_SIGNING_KEY: %w", err)
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("JWT_SIGNING_KEY %s: not a private key", cfg.JWTSigningKey)
+	}
+	jwtCfg.SigningKey = key
+
+	for _, path := range cfg.JWTVerificationKeys {
+		key, err := jwt.LoadKeyFile(path)
+		if err != nil {
+			return fmt.Errorf("JWT_VERIFICATION_KEYS: %w", err)
+		}
+		jwtCfg.VerificationKeys = append(jwtCfg.VerificationKeys, key)
+	}
+	return nil
+}
+
+// loadMetadataSchema compiles the JSON Schema user metadata is checked against.
+func loadMetadataSchema(path string) (*jsonschema.Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("USER_METADATA_SCHEMA: %w", err)
+	}
+	schema, err := jsonschema.Compile(data)
+	if err != nil {
+		return nil, fmt.Errorf("USER_METADATA_SCHEMA %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+func openDB(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer. One connection queues concurrent
+	// transactions in the pool instead of failing them with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 // Run starts the server and blocks until shutdown.
 func (a *App) Run() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		a.purger.Run(ctx)
+	}()
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		a.events.Run(ctx)
+	}()
+
 	errCh := make(chan error, 1)
 	go func() {
 		a.log.Info("starting server", "port", a.cfg.Port, "env", a.cfg.Env)
@@ -62,10 +268,10 @@ func (a *App) Run() error {
 		}
 	}()
 
-	return a.awaitShutdown(errCh)
+	return a.awaitShutdown(errCh, cancel)
 }
 
-func (a *App) awaitShutdown(errCh chan error) error {
+func (a *App) awaitShutdown(errCh chan error, stopBackground context.CancelFunc) error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -85,6 +291,16 @@ func (a *App) awaitShutdown(errCh chan error) error {
 		return err
 	}
 
+	stopBackground()
+	a.background.Wait()
+
+	if c, ok := a.store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			a.log.Error("store close error", "error", err)
+			return err
+		}
+	}
+
 	a.log.Info("server stopped")
 	return nil
 }
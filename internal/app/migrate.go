@@ -0,0 +1,65 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/muflihunaf/boilerplate-go/internal/config"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+// Migrate runs schema migrations against DATABASE_URL from the command line:
+//
+//	api migrate up
+//	api migrate down [steps]
+//	api migrate version
+func Migrate(args []string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	log := setupLogger(cfg)
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		n, err := repository.MigrateUp(ctx, db)
+		if err != nil {
+			return err
+		}
+		log.Info("migrations applied", "count", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+		}
+		n, err := repository.MigrateDown(ctx, db, steps)
+		if err != nil {
+			return err
+		}
+		log.Info("migrations reverted", "count", n)
+	case "version":
+		v, err := repository.SchemaVersion(ctx, db)
+		if err != nil {
+			return err
+		}
+		log.Info("schema version", "version", v)
+	default:
+		return fmt.Errorf("unknown migrate command %q (want up, down or version)", cmd)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/muflihunaf/boilerplate-go/internal/config"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+// Reencrypt rewrites every stored user and audit entry with the configured
+// field encryption from the command line:
+//
+//	api reencrypt
+//
+// Run it after adding a key to FIELD_ENCRYPTION_KEYS (older keys can be
+// removed once it has finished), and before serving with encryption newly
+// enabled: until then users stored in plaintext cannot be found by email.
+func Reencrypt() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	log := setupLogger(cfg)
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	if c, ok := store.(io.Closer); ok {
+		defer c.Close()
+	}
+	r, ok := store.(repository.Reencrypter)
+	if !ok {
+		return fmt.Errorf("STORE_DRIVER %q keeps nothing at rest to re-encrypt", cfg.StoreDriver)
+	}
+
+	n, err := r.ReencryptUsers(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Info("users re-encrypted", "count", n)
+	return nil
+}
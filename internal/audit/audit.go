@@ -0,0 +1,160 @@
+// Package audit describes who is acting on a request and what a write
+// changed, for the audit log kept by the repository.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionUserRegister   = "user.register"
+	ActionUserCreate     = "user.create"
+	ActionUserUpdate     = "user.update"
+	ActionUserDelete     = "user.delete"
+	ActionUserRestore    = "user.restore"
+	ActionUsersPurge     = "users.purge"
+	ActionEraseRequest   = "user.erasure_request"
+	ActionEraseCancel    = "user.erasure_cancel"
+	ActionUserErase      = "user.erase"
+	ActionPasswordRehash = "user.password_rehash"
+	ActionTokensRevoke   = "user.tokens_revoke"
+	ActionAdminGrant     = "user.admin_grant"
+	ActionOrgCreate      = "org.create"
+	ActionOrgInvite      = "org.invite"
+)
+
+// Redacted replaces the value of secret fields in a FieldChange.
+const Redacted = "[REDACTED]"
+
+// Actor identifies who performed a request.
+type Actor struct {
+	UserID    string // empty for anonymous requests and background jobs
+	RequestID string
+	IP        string
+}
+
+type actorKey struct{}
+
+// WithActor returns a context carrying a.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+// ActorFrom returns the actor stored in ctx, or the zero Actor.
+func ActorFrom(ctx context.Context) Actor {
+	a, _ := ctx.Value(actorKey{}).(Actor)
+	return a
+}
+
+// Entry starts an audit entry for action on target, attributed to the
+// actor in ctx.
+func Entry(ctx context.Context, action, targetID string, changes []repository.FieldChange) repository.AuditEntry {
+	a := ActorFrom(ctx)
+	return repository.AuditEntry{
+		ActorID:   a.UserID,
+		RequestID: a.RequestID,
+		IP:        a.IP,
+		Action:    action,
+		TargetID:  targetID,
+		Changes:   changes,
+	}
+}
+
+// Diff lists the fields that differ between two states of a user. Either
+// side may be nil, for a create or a permanent removal. The password is
+// reported as changed, but its value is never recorded. Metadata is
+// compared key by key, as "metadata.<key>" with JSON-encoded values.
+func Diff(before, after *repository.User) []repository.FieldChange {
+	var b, a repository.User
+	if before != nil {
+		b = *before
+	}
+	if after != nil {
+		a = *after
+	}
+
+	var changes []repository.FieldChange
+	add := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, repository.FieldChange{Field: field, From: from, To: to})
+		}
+	}
+	add("name", b.Name, a.Name)
+	add("email", b.Email, a.Email)
+	if b.Password != a.Password {
+		changes = append(changes, repository.FieldChange{Field: "password", From: redact(b.Password), To: redact(a.Password)})
+	}
+	add("deleted", flag(before != nil && b.IsDeleted()), flag(after != nil && a.IsDeleted()))
+	add("erase_at", timestamp(b.EraseAt), timestamp(a.EraseAt))
+	add("tokens_valid_after", timestamp(b.TokensValidAfter), timestamp(a.TokensValidAfter))
+	add("roles", strings.Join(b.Roles, ","), strings.Join(a.Roles, ","))
+	for _, key := range metadataKeys(b.Metadata, a.Metadata) {
+		add("metadata."+key, jsonValue(b.Metadata, key), jsonValue(a.Metadata, key))
+	}
+	add("version", version(b.Version), version(a.Version))
+	return changes
+}
+
+// metadataKeys returns the keys of both maps, sorted.
+func metadataKeys(before, after map[string]any) []string {
+	keys := make([]string, 0, len(before)+len(after))
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonValue encodes m[key], or returns "" if it is absent.
+func jsonValue(m map[string]any, key string) string {
+	v, ok := m[key]
+	if !ok {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return Redacted
+}
+
+func flag(b bool) string {
+	if !b {
+		return ""
+	}
+	return "true"
+}
+
+func timestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func version(v int64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatInt(v, 10)
+}
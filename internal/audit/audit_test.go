@@ -0,0 +1,55 @@
+package audit_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+func TestDiffRedactsPassword(t *testing.T) {
+	before := &repository.User{ID: "u1", Name: "Alice", Email: "a@example.com", Password: "hash-1", Version: 1}
+	after := *before
+	after.Password = "hash-2"
+	now := time.Now()
+	after.DeletedAt = &now
+	after.Version = 2
+
+	want := []repository.FieldChange{
+		{Field: "password", From: audit.Redacted, To: audit.Redacted},
+		{Field: "deleted", From: "", To: "true"},
+		{Field: "version", From: "1", To: "2"},
+	}
+	if got := audit.Diff(before, &after); !slices.Equal(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	created := audit.Diff(nil, before)
+	for _, c := range created {
+		if c.Field == "password" && c.To != audit.Redacted {
+			t.Errorf("expected the new password to be redacted, got %q", c.To)
+		}
+	}
+	if len(created) != 4 {
+		t.Errorf("expected name, email, password and version on create, got %+v", created)
+	}
+}
+
+func TestDiffMetadata(t *testing.T) {
+	before := &repository.User{ID: "u1", Metadata: map[string]any{"plan": "free", "seats": 2.0, "beta": true}, Version: 1}
+	after := *before
+	after.Metadata = map[string]any{"plan": "pro", "seats": 2.0, "region": "eu"}
+	after.Version = 2
+
+	want := []repository.FieldChange{
+		{Field: "metadata.beta", From: "true", To: ""},
+		{Field: "metadata.plan", From: `"free"`, To: `"pro"`},
+		{Field: "metadata.region", From: "", To: `"eu"`},
+		{Field: "version", From: "1", To: "2"},
+	}
+	if got := audit.Diff(before, &after); !slices.Equal(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -21,10 +22,72 @@ type Config struct {
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
 
-	// JWT
-	JWTSecret     string
-	JWTExpiration time.Duration
-	JWTIssuer     string
+	// JWT. JWTExpiration is the lifetime of access tokens; clients renew
+	// them with a refresh token, which lasts RefreshTokenExpiration.
+	// Tokens are signed with JWTSecret (HS256) unless JWTSigningKey, a PEM
+	// file, is set; JWTVerificationKeys are PEM files of keys that still
+	// verify tokens after a rotation.
+	JWTSecret              string
+	JWTExpiration          time.Duration
+	JWTIssuer              string
+	JWTSigningKey          string
+	JWTVerificationKeys    []string
+	RefreshTokenExpiration time.Duration
+
+	// Password hashing. PasswordHasher (argon2id or bcrypt) hashes new
+	// passwords; hashes made by the other one, or with other parameters,
+	// are replaced when their users log in. Argon2Memory is in KiB.
+	PasswordHasher    string
+	Argon2Memory      int
+	Argon2Iterations  int
+	Argon2Parallelism int
+	BcryptCost        int
+
+	// InviteExpiration is how long an organization invitation stays valid.
+	InviteExpiration time.Duration
+
+	// Storage
+	StoreDriver        string // memory, file or sqlite
+	StorePath          string
+	StoreSnapshotEvery int
+	IDScheme           string // uuidv7 or ulid, for new users and organizations
+
+	// Field-level encryption of user PII at rest, for the file and sqlite
+	// drivers. Keys are "version:base64key" items; the highest version
+	// encrypts new values and the others only decrypt. The index key (base64)
+	// keys the email blind index and cannot be rotated in place.
+	FieldEncryptionKeys     []string
+	FieldEncryptionIndexKey string
+	EncryptedFields         []string // name and/or email
+
+	// User cache in front of the store; off unless CacheSize is set.
+	CacheSize int
+	CacheTTL  time.Duration
+
+	// MetadataSchemaFile is a JSON Schema (draft 2020-12) that user metadata
+	// must match; empty accepts any JSON object.
+	MetadataSchemaFile string
+
+	// RequireIfMatch rejects user updates and deletes without If-Match (428).
+	RequireIfMatch bool
+
+	// Soft-deleted users are purged once UserRetention has passed.
+	UserRetention time.Duration
+	PurgeInterval time.Duration
+	// ErasureGracePeriod is how long a user can cancel a requested erasure
+	// before the purge job anonymizes them.
+	ErasureGracePeriod time.Duration
+
+	// EventPollInterval is how often the outbox dispatcher looks for new events.
+	EventPollInterval time.Duration
+
+	// AdminEmails lists users who are made admins when they log in, for
+	// users who registered before roles existed.
+	AdminEmails []string
+
+	// Database (sqlite driver)
+	DatabaseURL   string
+	DBAutoMigrate bool
 }
 
 // Load reads configuration from environment variables.
@@ -39,8 +102,48 @@ func Load() (*Config, error) {
 		WriteTimeout:  duration("WRITE_TIMEOUT", 15*time.Second),
 		IdleTimeout:   duration("IDLE_TIMEOUT", 60*time.Second),
 		JWTSecret:     env("JWT_SECRET", ""),
-		JWTExpiration: duration("JWT_EXPIRATION", 24*time.Hour),
+		JWTExpiration: duration("JWT_EXPIRATION", 15*time.Minute),
 		JWTIssuer:     env("JWT_ISSUER", "boilerplate-go"),
+
+		JWTSigningKey:       env("JWT_SIGNING_KEY", ""),
+		JWTVerificationKeys: list("JWT_VERIFICATION_KEYS"),
+
+		RefreshTokenExpiration: duration("REFRESH_TOKEN_EXPIRATION", 30*24*time.Hour),
+
+		PasswordHasher:    env("PASSWORD_HASHER", "argon2id"),
+		Argon2Memory:      integer("ARGON2_MEMORY", 19*1024),
+		Argon2Iterations:  integer("ARGON2_ITERATIONS", 2),
+		Argon2Parallelism: integer("ARGON2_PARALLELISM", 1),
+		BcryptCost:        integer("BCRYPT_COST", 10),
+
+		InviteExpiration: duration("INVITE_EXPIRATION", 7*24*time.Hour),
+
+		StoreDriver:        env("STORE_DRIVER", "memory"),
+		StorePath:          env("STORE_PATH", "data"),
+		StoreSnapshotEvery: integer("STORE_SNAPSHOT_EVERY", 1000),
+		IDScheme:           env("ID_SCHEME", "uuidv7"),
+
+		FieldEncryptionKeys:     list("FIELD_ENCRYPTION_KEYS"),
+		FieldEncryptionIndexKey: env("FIELD_ENCRYPTION_INDEX_KEY", ""),
+		EncryptedFields:         list("ENCRYPTED_FIELDS"),
+
+		CacheSize: integer("CACHE_SIZE", 0),
+		CacheTTL:  duration("CACHE_TTL", time.Minute),
+
+		MetadataSchemaFile: env("USER_METADATA_SCHEMA", ""),
+
+		RequireIfMatch: boolean("REQUIRE_IF_MATCH", false),
+
+		UserRetention:      duration("USER_RETENTION", 30*24*time.Hour),
+		PurgeInterval:      duration("PURGE_INTERVAL", time.Hour),
+		ErasureGracePeriod: duration("ERASURE_GRACE_PERIOD", 14*24*time.Hour),
+
+		EventPollInterval: duration("EVENT_POLL_INTERVAL", time.Second),
+
+		AdminEmails: list("ADMIN_EMAILS"),
+
+		DatabaseURL:   env("DATABASE_URL", "data/app.db"),
+		DBAutoMigrate: boolean("DB_AUTO_MIGRATE", true),
 	}
 
 	if err := cfg.validate(); err != nil {
@@ -56,8 +159,9 @@ func (c *Config) IsProd() bool {
 }
 
 func (c *Config) validate() error {
-	// JWT secret is required in production
-	if c.IsProd() {
+	// JWT secret is required in production, unless tokens are signed with a
+	// key pair
+	if c.IsProd() && c.JWTSigningKey == "" {
 		if c.JWTSecret == "" {
 			return fmt.Errorf("JWT_SECRET is required in production")
 		}
@@ -66,6 +170,64 @@ func (c *Config) validate() error {
 		}
 	}
 
+	if len(c.JWTVerificationKeys) > 0 && c.JWTSigningKey == "" {
+		return fmt.Errorf("JWT_SIGNING_KEY is required with JWT_VERIFICATION_KEYS")
+	}
+	if c.JWTExpiration <= 0 {
+		return fmt.Errorf("JWT_EXPIRATION must be positive")
+	}
+	if c.RefreshTokenExpiration <= 0 {
+		return fmt.Errorf("REFRESH_TOKEN_EXPIRATION must be positive")
+	}
+
+	if c.PurgeInterval <= 0 {
+		return fmt.Errorf("PURGE_INTERVAL must be positive")
+	}
+
+	switch c.PasswordHasher {
+	case "argon2id", "bcrypt":
+	default:
+		return fmt.Errorf("unsupported PASSWORD_HASHER %q", c.PasswordHasher)
+	}
+	if c.Argon2Iterations < 1 {
+		return fmt.Errorf("ARGON2_ITERATIONS must be positive")
+	}
+	if c.Argon2Parallelism < 1 || c.Argon2Parallelism > 255 {
+		return fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255")
+	}
+	if c.Argon2Memory < 8*c.Argon2Parallelism {
+		return fmt.Errorf("ARGON2_MEMORY must be at least 8 KiB per degree of ARGON2_PARALLELISM")
+	}
+	if c.BcryptCost < 4 || c.BcryptCost > 31 {
+		return fmt.Errorf("BCRYPT_COST must be between 4 and 31")
+	}
+
+	switch c.StoreDriver {
+	case "memory", "file", "sqlite":
+	default:
+		return fmt.Errorf("unsupported STORE_DRIVER %q", c.StoreDriver)
+	}
+
+	switch c.IDScheme {
+	case "uuidv7", "ulid":
+	default:
+		return fmt.Errorf("unsupported ID_SCHEME %q", c.IDScheme)
+	}
+
+	if len(c.EncryptedFields) == 0 {
+		c.EncryptedFields = []string{"name", "email"}
+	}
+	for _, f := range c.EncryptedFields {
+		switch f {
+		case "name", "email":
+		default:
+			return fmt.Errorf("unsupported ENCRYPTED_FIELDS entry %q", f)
+		}
+	}
+	if len(c.FieldEncryptionKeys) > 0 && c.FieldEncryptionIndexKey == "" {
+		return fmt.Errorf("FIELD_ENCRYPTION_INDEX_KEY is required with FIELD_ENCRYPTION_KEYS")
+	}
+
 	// Default secret for development only
 	if c.JWTSecret == "" {
 		c.JWTSecret = "dev-secret-do-not-use-in-production"
@@ -91,3 +253,32 @@ func duration(key string, fallback time.Duration) time.Duration {
 	}
 	return fallback
 }
+
+func integer(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+// list splits a comma-separated variable, dropping empty items.
+func list(key string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func boolean(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
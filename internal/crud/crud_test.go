@@ -0,0 +1,199 @@
+package crud_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/crud"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+type project struct {
+	crud.Base
+	Name string   `json:"name"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+func (p *project) Clone() *project {
+	c := *p
+	c.Tags = append([]string(nil), p.Tags...)
+	return &c
+}
+
+func newProjects(t *testing.T) http.Handler {
+	t.Helper()
+	svc := crud.NewService[project](crud.NewMemoryStore[project](), crud.Hooks[project]{
+		Validate: func(ctx context.Context, p *project) error {
+			if strings.TrimSpace(p.Name) == "" {
+				return &service.ValidationError{Fields: map[string]string{"/name": "is required"}}
+			}
+			return nil
+		},
+		Authorize: func(ctx context.Context, action crud.Action, p *project) error {
+			if action == crud.ActionDelete && p.Name == "Keep" {
+				return service.ErrForbidden
+			}
+			return nil
+		},
+	})
+	h := crud.NewHandler[project](svc, crud.HandlerOptions{Name: "project"})
+	r := chi.NewRouter()
+	r.Route("/projects", h.Routes)
+	return r
+}
+
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *struct {
+		Message string            `json:"message"`
+		Details map[string]string `json:"details"`
+	} `json:"error"`
+	Meta *struct {
+		Total      int64 `json:"total"`
+		TotalPages int   `json:"total_pages"`
+	} `json:"meta"`
+}
+
+func do(t *testing.T, h http.Handler, ctx context.Context, method, target, ifMatch, body string) (*httptest.ResponseRecorder, envelope) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var env envelope
+	if rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &env); err != nil {
+			t.Fatalf("%s %s: decode response: %v", method, target, err)
+		}
+	}
+	return rec, env
+}
+
+func TestResourceLifecycle(t *testing.T) {
+	h := newProjects(t)
+	ctx := context.Background()
+
+	rec, env := do(t, h, ctx, http.MethodPost, "/projects", "", `{"name":" "}`)
+	if rec.Code != http.StatusUnprocessableEntity || env.Error.Details["/name"] != "is required" {
+		t.Fatalf("create without a name: expected 422 with a field error, got %d %s", rec.Code, rec.Body)
+	}
+
+	rec, env = do(t, h, ctx, http.MethodPost, "/projects", "", `{"id":"chosen","version":9,"name":"Apollo","tags":["space"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: expected %d, got %d %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+	var apollo project
+	json.Unmarshal(env.Data, &apollo)
+	if apollo.ID == "chosen" || apollo.Version != 1 || apollo.Name != "Apollo" {
+		t.Errorf("expected a store-assigned ID and version 1, got %+v", apollo)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("expected ETag %q, got %q", `"1"`, etag)
+	}
+
+	rec, env = do(t, h, ctx, http.MethodPut, "/projects/"+apollo.ID, `"1"`, `{"name":"Apollo 11"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update: expected %d, got %d %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	var updated project
+	json.Unmarshal(env.Data, &updated)
+	if updated.Version != 2 || updated.Name != "Apollo 11" || updated.Tags != nil || !updated.CreatedAt.Equal(apollo.CreatedAt) {
+		t.Errorf("expected the project to be replaced at version 2, got %+v", updated)
+	}
+	if rec, _ := do(t, h, ctx, http.MethodPut, "/projects/"+apollo.ID, `"1"`, `{"name":"Stale"}`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("update with a stale ETag: expected %d, got %d", http.StatusPreconditionFailed, rec.Code)
+	}
+
+	if rec, _ := do(t, h, ctx, http.MethodGet, "/projects/not-an-id", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed ID: expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec, _ := do(t, h, tenant.WithID(ctx, "other"), http.MethodGet, "/projects/"+apollo.ID, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("another tenant's project: expected %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	if rec, _ := do(t, h, ctx, http.MethodDelete, "/projects/"+apollo.ID, "", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected %d, got %d %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+	if rec, env := do(t, h, ctx, http.MethodGet, "/projects/"+apollo.ID, "", ""); rec.Code != http.StatusNotFound || env.Error.Message != "project not found" {
+		t.Errorf("get after delete: expected 404, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestResourceListAndAuthorize(t *testing.T) {
+	h := newProjects(t)
+	ctx := context.Background()
+
+	var keep project
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("Project %d", i)
+		if i == 0 {
+			name = "Keep"
+		}
+		_, env := do(t, h, ctx, http.MethodPost, "/projects", "", `{"name":"`+name+`"}`)
+		if i == 0 {
+			json.Unmarshal(env.Data, &keep)
+		}
+	}
+	do(t, h, tenant.WithID(ctx, "other"), http.MethodPost, "/projects", "", `{"name":"Elsewhere"}`)
+
+	rec, env := do(t, h, ctx, http.MethodGet, "/projects?per_page=2&page=1&sort=-created_at", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected %d, got %d %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	var items []project
+	json.Unmarshal(env.Data, &items)
+	if len(items) != 2 || items[0].Name != "Project 4" || env.Meta.Total != 5 || env.Meta.TotalPages != 3 {
+		t.Errorf("unexpected page %+v, meta %+v", items, env.Meta)
+	}
+	if link := rec.Header().Get("Link"); !strings.Contains(link, `page=2`) || !strings.Contains(link, `rel="next"`) {
+		t.Errorf("expected a next link, got %q", link)
+	}
+	// A page far past the end is empty rather than overflowing its offset.
+	rec, env = do(t, h, ctx, http.MethodGet, "/projects?per_page=100&page=100000000000000000", "", "")
+	items = nil
+	json.Unmarshal(env.Data, &items)
+	if rec.Code != http.StatusOK || len(items) != 0 || env.Meta.Total != 5 {
+		t.Errorf("huge page: expected an empty page, got %d %s", rec.Code, rec.Body)
+	}
+	if rec, _ := do(t, h, ctx, http.MethodGet, "/projects?sort=name", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort: expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	if rec, _ := do(t, h, ctx, http.MethodDelete, "/projects/"+keep.ID, "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("refused delete: expected %d, got %d", http.StatusForbidden, rec.Code)
+	}
+	if rec, _ := do(t, h, ctx, http.MethodGet, "/projects/"+keep.ID, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected a refused delete to keep the project, got %d", rec.Code)
+	}
+}
+
+func TestMemoryStoreReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	s := crud.NewMemoryStore[project]()
+
+	in := &project{Name: "Apollo", Tags: []string{"space"}}
+	created, err := s.Create(ctx, in)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	in.Tags[0] = "changed"
+	created.Tags[0] = "changed"
+
+	got, err := s.Get(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Tags[0] != "space" || in.ID != "" {
+		t.Errorf("expected the store to keep its own copy, got %+v and input %+v", got, in)
+	}
+}
@@ -0,0 +1,192 @@
+package crud
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/httputil"
+	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/response"
+)
+
+// HandlerOptions tunes a Handler.
+type HandlerOptions struct {
+	// Name is the resource in singular, as used in messages such as
+	// "project not found".
+	Name string
+	// RequireIfMatch makes PUT and DELETE fail with 428 unless the client
+	// sends an If-Match header, as handler.Options.RequireIfMatch does for
+	// users.
+	RequireIfMatch bool
+}
+
+// Handler serves a resource over HTTP. PUT replaces a resource as a whole;
+// the fields in Base are read-only.
+type Handler[T any] struct {
+	svc  *Service[T]
+	opts HandlerOptions
+	base func(*T) *Base
+}
+
+// NewHandler returns a Handler for svc. T is usually the only type argument
+// given; P is inferred.
+func NewHandler[T any, P ptr[T]](svc *Service[T], opts HandlerOptions) *Handler[T] {
+	return &Handler[T]{
+		svc:  svc,
+		opts: opts,
+		base: func(v *T) *Base { return P(v).EntityBase() },
+	}
+}
+
+// Routes registers the resource's endpoints on r, which is usually the
+// router for its collection path:
+//
+//	GET    /       list, with page, per_page and sort=created_at|-created_at
+//	POST   /       create
+//	GET    /{id}   get
+//	PUT    /{id}   replace, honouring If-Match
+//	DELETE /{id}   delete, honouring If-Match
+func (h *Handler[T]) Routes(r chi.Router) {
+	r.Get("/", h.List)
+	r.Post("/", h.Create)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.ValidParam("id", repository.ValidID))
+		r.Get("/{id}", h.Get)
+		r.Put("/{id}", h.Update)
+		r.Delete("/{id}", h.Delete)
+	})
+}
+
+func (h *Handler[T]) List(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r.URL.Query())
+	if err != nil {
+		response.BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := h.svc.List(r.Context(), q)
+	if err != nil {
+		h.fail(w, err, "list", "")
+		return
+	}
+
+	meta := response.NewMeta(page.Page, page.PerPage, page.Total)
+	response.SetLinks(w, httputil.PageLinks(r.URL, false, meta)...)
+	response.WithMeta(w, page.Items, meta)
+}
+
+func (h *Handler[T]) Get(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	v, err := h.svc.Get(r.Context(), id)
+	if err != nil {
+		h.fail(w, err, "get", id)
+		return
+	}
+	h.setETag(w, v)
+	response.OK(w, v)
+}
+
+func (h *Handler[T]) Create(w http.ResponseWriter, r *http.Request) {
+	v := new(T)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		response.BadRequest(w, "invalid request body")
+		return
+	}
+
+	created, err := h.svc.Create(r.Context(), v)
+	if err != nil {
+		h.fail(w, err, "create", "")
+		return
+	}
+	h.setETag(w, created)
+	response.Created(w, created)
+}
+
+func (h *Handler[T]) Update(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	ifVersion, ok := httputil.IfMatch(w, r, h.opts.RequireIfMatch)
+	if !ok {
+		return
+	}
+
+	v := new(T)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		response.BadRequest(w, "invalid request body")
+		return
+	}
+
+	updated, err := h.svc.Update(r.Context(), id, ifVersion, v)
+	if err != nil {
+		h.fail(w, err, "update", id)
+		return
+	}
+	h.setETag(w, updated)
+	response.OK(w, updated)
+}
+
+func (h *Handler[T]) Delete(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	ifVersion, ok := httputil.IfMatch(w, r, h.opts.RequireIfMatch)
+	if !ok {
+		return
+	}
+
+	if err := h.svc.Delete(r.Context(), id, ifVersion); err != nil {
+		h.fail(w, err, "delete", id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fail writes the response for an error from the service.
+func (h *Handler[T]) fail(w http.ResponseWriter, err error, action, id string) {
+	var verr *service.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		response.ValidationError(w, "invalid "+h.opts.Name, verr.Fields)
+	case errors.Is(err, service.ErrInvalidInput):
+		response.BadRequest(w, "invalid "+h.opts.Name)
+	case errors.Is(err, service.ErrForbidden):
+		response.Forbidden(w, "not allowed to "+action+" this "+h.opts.Name)
+	case errors.Is(err, service.ErrNotFound):
+		response.NotFound(w, h.opts.Name+" not found")
+	case errors.Is(err, service.ErrConflict):
+		response.Conflict(w, h.opts.Name+" already exists")
+	case errors.Is(err, service.ErrPreconditionFailed):
+		response.PreconditionFailed(w, h.opts.Name+" was modified since it was read")
+	default:
+		slog.Error(action+" "+h.opts.Name+" failed", "error", err, "id", id)
+		response.InternalError(w)
+	}
+}
+
+// setETag exposes v's version as a strong entity tag.
+func (h *Handler[T]) setETag(w http.ResponseWriter, v *T) {
+	httputil.SetETag(w, h.base(v).Version)
+}
+
+func parseQuery(q url.Values) (Query, error) {
+	var query Query
+	var err error
+	if query.Page, err = httputil.PositiveInt(q, "page"); err != nil {
+		return query, err
+	}
+	if query.PerPage, err = httputil.PositiveInt(q, "per_page"); err != nil {
+		return query, err
+	}
+	switch q.Get("sort") {
+	case "", "created_at":
+	case "-created_at":
+		query.Desc = true
+	default:
+		return query, errors.New("sort must be created_at or -created_at")
+	}
+	return query, nil
+}
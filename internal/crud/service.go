@@ -0,0 +1,136 @@
+package crud
+
+import (
+	"context"
+	"errors"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+)
+
+// Action is what a caller is trying to do, as passed to Hooks.Authorize.
+type Action string
+
+const (
+	ActionList   Action = "list"
+	ActionGet    Action = "get"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Hooks customize a Service. Both are optional.
+type Hooks[T any] struct {
+	// Validate checks a value about to be created, or to replace an
+	// existing one. Return a *service.ValidationError for field-level errors
+	// or service.ErrInvalidInput.
+	Validate func(ctx context.Context, v *T) error
+	// Authorize decides whether the caller may act on v: the new value for
+	// ActionCreate, nil for ActionList and the stored value otherwise.
+	// Return service.ErrForbidden to refuse. For ActionUpdate and
+	// ActionDelete it runs atomically with the change.
+	Authorize func(ctx context.Context, action Action, v *T) error
+}
+
+// Service is the business logic for a resource: it runs the hooks around
+// the store and reports the service package's errors, like service.Service
+// does for users.
+type Service[T any] struct {
+	store Store[T]
+	hooks Hooks[T]
+}
+
+// NewService returns a Service backed by store.
+func NewService[T any](store Store[T], hooks Hooks[T]) *Service[T] {
+	return &Service[T]{store: store, hooks: hooks}
+}
+
+func (s *Service[T]) List(ctx context.Context, q Query) (*Page[T], error) {
+	if err := s.authorize(ctx, ActionList, nil); err != nil {
+		return nil, err
+	}
+	page, err := s.store.List(ctx, q)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return page, nil
+}
+
+func (s *Service[T]) Get(ctx context.Context, id string) (*T, error) {
+	v, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if err := s.authorize(ctx, ActionGet, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *Service[T]) Create(ctx context.Context, v *T) (*T, error) {
+	if err := s.validate(ctx, v); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, ActionCreate, v); err != nil {
+		return nil, err
+	}
+	created, err := s.store.Create(ctx, v)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return created, nil
+}
+
+// Update replaces the item with v. When ifVersion is non-zero the update
+// fails with ErrPreconditionFailed unless the item is at that version.
+func (s *Service[T]) Update(ctx context.Context, id string, ifVersion int64, v *T) (*T, error) {
+	if err := s.validate(ctx, v); err != nil {
+		return nil, err
+	}
+	updated, err := s.store.Update(ctx, id, ifVersion, func(cur *T) (*T, error) {
+		if err := s.authorize(ctx, ActionUpdate, cur); err != nil {
+			return nil, err
+		}
+		return v, nil
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return updated, nil
+}
+
+// Delete removes the item. ifVersion is as for Update.
+func (s *Service[T]) Delete(ctx context.Context, id string, ifVersion int64) error {
+	err := s.store.Delete(ctx, id, ifVersion, func(cur *T) error {
+		return s.authorize(ctx, ActionDelete, cur)
+	})
+	return mapError(err)
+}
+
+func (s *Service[T]) validate(ctx context.Context, v *T) error {
+	if s.hooks.Validate == nil {
+		return nil
+	}
+	return s.hooks.Validate(ctx, v)
+}
+
+func (s *Service[T]) authorize(ctx context.Context, action Action, v *T) error {
+	if s.hooks.Authorize == nil {
+		return nil
+	}
+	return s.hooks.Authorize(ctx, action, v)
+}
+
+// mapError translates store errors into service errors; anything else,
+// including errors from hooks, passes through.
+func mapError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return service.ErrNotFound
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return service.ErrPreconditionFailed
+	case errors.Is(err, repository.ErrConflict):
+		return service.ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+// Package crud is a toolkit for resources that need nothing more than
+// list, get, create, update and delete. A resource is a struct that embeds
+// Base; a Store keeps it, a Service adds validation and authorization
+// hooks, and a Handler serves it over HTTP with the same envelope,
+// pagination, ETags and error responses as the user endpoints:
+//
+//	type Project struct {
+//	    crud.Base
+//	    Name string `json:"name"`
+//	}
+//
+//	projects := crud.NewHandler[Project](
+//	    crud.NewService[Project](crud.NewMemoryStore[Project](), crud.Hooks[Project]{}),
+//	    crud.HandlerOptions{Name: "project"},
+//	)
+//	r.Route("/projects", projects.Routes)
+package crud
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// Base holds the fields every resource has. They are managed by the store:
+// whatever a client sends for them is ignored.
+type Base struct {
+	ID        string    `json:"id"`
+	TenantID  string    `json:"organization_id,omitempty"` // empty for the default tenant
+	Version   int64     `json:"version"`                   // Starts at 1, bumped on every change
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// EntityBase returns b itself, which makes any struct embedding Base an
+// Entity.
+func (b *Base) EntityBase() *Base {
+	return b
+}
+
+// Entity is implemented by pointers to structs that embed Base.
+type Entity interface {
+	EntityBase() *Base
+}
+
+// ptr constrains P to *T implementing Entity, so that generic code can reach
+// a T's Base. Constructors take it as a second, inferred type parameter.
+type ptr[T any] interface {
+	*T
+	Entity
+}
+
+// Query selects one page of a listing, oldest first unless Desc is set.
+type Query struct {
+	// Page is 1-based.
+	Page    int
+	PerPage int
+	Desc    bool
+}
+
+// normalize applies the same page defaults and bounds as user listings.
+func (q Query) normalize() Query {
+	q.Page, q.PerPage = repository.NormalizePage(q.Page, q.PerPage)
+	return q
+}
+
+// Page is one page of List results.
+type Page[T any] struct {
+	Items []T
+	// Total is the number of items across all pages.
+	Total int64
+	// Page and PerPage echo the effective query.
+	Page    int
+	PerPage int
+}
+
+// Store is the persistence contract for a resource. Like repository.UserStore
+// it is scoped to the tenant in ctx and returns values that belong to the
+// caller. It reports repository.ErrNotFound and repository.ErrVersionMismatch.
+type Store[T any] interface {
+	List(ctx context.Context, q Query) (*Page[T], error)
+	Get(ctx context.Context, id string) (*T, error)
+	// Create stores v under a new ID with version 1 and returns it.
+	Create(ctx context.Context, v *T) (*T, error)
+	// Update replaces the item with what fn returns for its current value,
+	// keeping its Base and bumping its version. When ifVersion is non-zero
+	// the update fails with ErrVersionMismatch unless the item is at that
+	// version. An error from fn aborts the update and is returned as is.
+	Update(ctx context.Context, id string, ifVersion int64, fn func(cur *T) (*T, error)) (*T, error)
+	// Delete removes the item once check, if not nil, accepts its current
+	// value. ifVersion is as for Update.
+	Delete(ctx context.Context, id string, ifVersion int64, check func(cur *T) error) error
+}
+
+// MemoryStore is the in-memory Store. Values are copied on the way in and
+// out; a T holding slices, maps or pointers should implement
+// interface{ Clone() *T } so that copies do not share them.
+type MemoryStore[T any] struct {
+	base func(*T) *Base
+
+	mu    sync.RWMutex
+	items map[string]*T
+	ids   repository.IDGenerator
+}
+
+// NewMemoryStore returns an empty store. T is usually the only type argument
+// given; P is inferred.
+func NewMemoryStore[T any, P ptr[T]]() *MemoryStore[T] {
+	return &MemoryStore[T]{
+		base:  func(v *T) *Base { return P(v).EntityBase() },
+		items: make(map[string]*T),
+		ids:   repository.NewUUIDv7Generator(),
+	}
+}
+
+// SetIDGenerator makes s use g for new IDs instead of UUIDv7. Call it
+// before s is used.
+func (s *MemoryStore[T]) SetIDGenerator(g repository.IDGenerator) {
+	s.ids = g
+}
+
+func (s *MemoryStore[T]) List(ctx context.Context, q Query) (*Page[T], error) {
+	q = q.normalize()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tenantID := tenant.ID(ctx)
+	var items []T
+	for _, v := range s.items {
+		if s.base(v).TenantID == tenantID {
+			items = append(items, *s.clone(v))
+		}
+	}
+	slices.SortFunc(items, func(a, b T) int {
+		ba, bb := s.base(&a), s.base(&b)
+		c := ba.CreatedAt.Compare(bb.CreatedAt)
+		if c == 0 {
+			c = strings.Compare(ba.ID, bb.ID)
+		}
+		if q.Desc {
+			return -c
+		}
+		return c
+	})
+
+	start := min((q.Page-1)*q.PerPage, len(items))
+	end := min(start+q.PerPage, len(items))
+	return &Page[T]{
+		Items:   append(make([]T, 0, end-start), items[start:end]...),
+		Total:   int64(len(items)),
+		Page:    q.Page,
+		PerPage: q.PerPage,
+	}, nil
+}
+
+func (s *MemoryStore[T]) Get(ctx context.Context, id string) (*T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, err := s.lookupLocked(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.clone(v), nil
+}
+
+func (s *MemoryStore[T]) Create(ctx context.Context, v *T) (*T, error) {
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	stored := s.clone(v)
+	*s.base(stored) = Base{ID: id, TenantID: tenant.ID(ctx), Version: 1, CreatedAt: now, UpdatedAt: now}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[id] = stored
+	return s.clone(stored), nil
+}
+
+func (s *MemoryStore[T]) Update(ctx context.Context, id string, ifVersion int64, fn func(cur *T) (*T, error)) (*T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := s.lookupLocked(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	b := s.base(cur)
+	if ifVersion != 0 && b.Version != ifVersion {
+		return nil, repository.ErrVersionMismatch
+	}
+	next, err := fn(s.clone(cur))
+	if err != nil {
+		return nil, err
+	}
+
+	stored := s.clone(next)
+	nb := *b
+	nb.Version++
+	nb.UpdatedAt = time.Now()
+	*s.base(stored) = nb
+	s.items[id] = stored
+	return s.clone(stored), nil
+}
+
+func (s *MemoryStore[T]) Delete(ctx context.Context, id string, ifVersion int64, check func(cur *T) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := s.lookupLocked(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ifVersion != 0 && s.base(cur).Version != ifVersion {
+		return repository.ErrVersionMismatch
+	}
+	if check != nil {
+		if err := check(s.clone(cur)); err != nil {
+			return err
+		}
+	}
+	delete(s.items, id)
+	return nil
+}
+
+// lookupLocked returns the stored item with the given ID in ctx's tenant.
+// Callers must hold mu.
+func (s *MemoryStore[T]) lookupLocked(ctx context.Context, id string) (*T, error) {
+	v, ok := s.items[id]
+	if !ok || s.base(v).TenantID != tenant.ID(ctx) {
+		return nil, repository.ErrNotFound
+	}
+	return v, nil
+}
+
+// clone copies v, deeply if T knows how to.
+func (s *MemoryStore[T]) clone(v *T) *T {
+	if c, ok := any(v).(interface{ Clone() *T }); ok {
+		return c.Clone()
+	}
+	c := *v
+	return &c
+}
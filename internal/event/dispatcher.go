@@ -0,0 +1,237 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// Handler processes one message. Returning an error makes the dispatcher
+// retry the same message after a backoff, so handlers must be idempotent:
+// delivery is at least once, and a crash between handling a message and
+// recording progress redelivers it. ctx acts for the message's tenant, so
+// store reads made with it are scoped accordingly.
+type Handler func(ctx context.Context, msg Message) error
+
+// Options configures a Dispatcher. Zero values select the defaults.
+type Options struct {
+	PollInterval time.Duration // default 1s
+	BatchSize    int           // default repository.DefaultEventBatch
+	MinBackoff   time.Duration // default 100ms
+	MaxBackoff   time.Duration // default 30s
+}
+
+// Dispatcher delivers outbox events to named subscribers. Each subscriber
+// consumes the log independently, in offset order, and its progress is
+// stored in the outbox so it resumes where it left off after a restart.
+// A subscriber whose handler keeps failing is retried indefinitely and
+// holds back only its own deliveries.
+type Dispatcher struct {
+	outbox repository.Outbox
+	opts   Options
+	log    *slog.Logger
+
+	mu   sync.Mutex
+	subs map[string]*subscription
+}
+
+type subscription struct {
+	name    string
+	handler Handler
+
+	mu     sync.Mutex
+	rewind *int64 // offset to restart from, set by Replay
+}
+
+// NewDispatcher creates a dispatcher reading from outbox.
+func NewDispatcher(outbox repository.Outbox, opts Options, log *slog.Logger) *Dispatcher {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = repository.DefaultEventBatch
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(30*time.Second, opts.MinBackoff)
+	}
+	return &Dispatcher{outbox: outbox, opts: opts, log: log, subs: make(map[string]*subscription)}
+}
+
+// Subscribe registers h under name, which identifies its stored progress.
+// It must be called before Run and panics if name is already taken.
+func (d *Dispatcher) Subscribe(name string, h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.subs[name]; ok {
+		panic(fmt.Sprintf("event: duplicate subscriber %q", name))
+	}
+	d.subs[name] = &subscription{name: name, handler: h}
+}
+
+// Replay makes the named subscriber receive every event from offset from
+// (inclusive) onwards again, once it has finished its current delivery.
+func (d *Dispatcher) Replay(ctx context.Context, name string, from int64) error {
+	after := max(from-1, 0)
+
+	d.mu.Lock()
+	sub, ok := d.subs[name]
+	d.mu.Unlock()
+	if !ok {
+		return d.outbox.SetConsumerOffset(ctx, name, after)
+	}
+
+	sub.mu.Lock()
+	sub.rewind = &after
+	sub.mu.Unlock()
+	return nil
+}
+
+// Run delivers events to every subscriber until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	d.mu.Lock()
+	subs := make([]*subscription, 0, len(d.subs))
+	for _, sub := range d.subs {
+		subs = append(subs, sub)
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func(sub *subscription) {
+			defer wg.Done()
+			d.consume(ctx, sub)
+		}(sub)
+	}
+	wg.Wait()
+}
+
+// consume drains the log for sub, then polls for new events.
+func (d *Dispatcher) consume(ctx context.Context, sub *subscription) {
+	ticker := time.NewTicker(d.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.drain(ctx, sub); err != nil && ctx.Err() == nil {
+			d.log.Error("event dispatch failed", "subscriber", sub.name, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain delivers events to sub until it has caught up with the log.
+func (d *Dispatcher) drain(ctx context.Context, sub *subscription) error {
+	for {
+		if after, ok := sub.takeRewind(); ok {
+			if err := d.outbox.SetConsumerOffset(ctx, sub.name, after); err != nil {
+				return err
+			}
+		}
+
+		offset, err := d.outbox.ConsumerOffset(ctx, sub.name)
+		if err != nil {
+			return err
+		}
+		events, err := d.outbox.ReadEvents(ctx, offset, d.opts.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, e := range events {
+			if sub.rewinding() {
+				break
+			}
+			if err := d.deliver(ctx, sub, e); err != nil {
+				return err
+			}
+			if err := d.outbox.SetConsumerOffset(ctx, sub.name, e.Offset); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// deliver hands e to sub, retrying with exponential backoff until the
+// handler succeeds or ctx is cancelled. Events that cannot be decoded, e.g.
+// types written by a newer release, are logged and skipped.
+func (d *Dispatcher) deliver(ctx context.Context, sub *subscription, e repository.Event) error {
+	msg, err := Decode(e)
+	if err != nil {
+		d.log.Warn("skipping undecodable event", "subscriber", sub.name, "offset", e.Offset, "error", err)
+		return nil
+	}
+
+	hctx := tenant.WithID(ctx, msg.TenantID)
+	backoff := d.opts.MinBackoff
+	for attempt := 1; ; attempt++ {
+		err := sub.handler(hctx, msg)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		d.log.Warn("event handler failed, retrying",
+			"subscriber", sub.name,
+			"offset", e.Offset,
+			"type", e.Type,
+			"attempt", attempt,
+			"backoff", backoff,
+			"error", err,
+		)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, d.opts.MaxBackoff)
+	}
+}
+
+func (s *subscription) takeRewind() (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rewind == nil {
+		return 0, false
+	}
+	after := *s.rewind
+	s.rewind = nil
+	return after, true
+}
+
+func (s *subscription) rewinding() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rewind != nil
+}
+
+// LogHandler returns a Handler that logs every event it receives.
+func LogHandler(log *slog.Logger) Handler {
+	return func(ctx context.Context, msg Message) error {
+		log.Info("domain event",
+			"offset", msg.Offset,
+			"tenant", msg.TenantID,
+			"type", msg.Event.EventType(),
+			"aggregate_id", msg.Event.AggregateID(),
+		)
+		return nil
+	}
+}
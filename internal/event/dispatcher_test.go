@@ -0,0 +1,207 @@
+package event_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/event"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+func TestDispatcherDeliversInOrderWithRetry(t *testing.T) {
+	ctx := context.Background()
+	store := repository.New()
+	record(t, store, event.UserRegistered{UserID: "u1"})
+	record(t, store, event.UserUpdated{UserID: "u1", Version: 2})
+	record(t, store, event.UserDeleted{UserID: "u1"})
+
+	d := newDispatcher(store)
+	got := &collector{failOffset: 2, failures: 2}
+	d.Subscribe("test", got.handle)
+
+	stop := runDispatcher(d)
+	got.waitFor(t, 3)
+	stop()
+
+	if want := []int64{1, 2, 3}; !slices.Equal(got.offsets(), want) {
+		t.Errorf("expected deliveries %v, got %v", want, got.offsets())
+	}
+	if got.attempts != 5 {
+		t.Errorf("expected 2 retries on top of 3 deliveries, got %d attempts", got.attempts)
+	}
+	if u, ok := got.msgs[1].Event.(event.UserUpdated); !ok || u.UserID != "u1" || u.Version != 2 {
+		t.Errorf("expected typed UserUpdated payload, got %#v", got.msgs[1].Event)
+	}
+	if off, _ := store.ConsumerOffset(ctx, "test"); off != 3 {
+		t.Errorf("expected progress to be stored, got offset %d", off)
+	}
+}
+
+func TestDispatcherResumesAndReplays(t *testing.T) {
+	ctx := context.Background()
+	store := repository.New()
+	for _, id := range []string{"u1", "u2", "u3"} {
+		record(t, store, event.UserRegistered{UserID: id})
+	}
+	if err := store.SetConsumerOffset(ctx, "test", 2); err != nil {
+		t.Fatalf("SetConsumerOffset: %v", err)
+	}
+
+	d := newDispatcher(store)
+	got := &collector{}
+	d.Subscribe("test", got.handle)
+
+	stop := runDispatcher(d)
+	got.waitFor(t, 1)
+	if err := d.Replay(ctx, "test", 2); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	got.waitFor(t, 3)
+	stop()
+
+	// Resumed after the stored offset, then redelivered from offset 2.
+	if want := []int64{3, 2, 3}; !slices.Equal(got.offsets(), want) {
+		t.Errorf("expected deliveries %v, got %v", want, got.offsets())
+	}
+}
+
+func TestDispatcherPicksUpNewEvents(t *testing.T) {
+	store := repository.New()
+	d := newDispatcher(store)
+	got := &collector{}
+	d.Subscribe("test", got.handle)
+
+	stop := runDispatcher(d)
+	defer stop()
+
+	record(t, store, event.UserRegistered{UserID: "u1"})
+	got.waitFor(t, 1)
+
+	// Rolled back writes never reach subscribers.
+	_ = store.WithTx(context.Background(), func(tx repository.Tx) error {
+		if err := event.Record(context.Background(), tx, event.UserDeleted{UserID: "u1"}); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	record(t, store, event.UserUpdated{UserID: "u1"})
+	got.waitFor(t, 2)
+
+	if want := []int64{1, 2}; !slices.Equal(got.offsets(), want) {
+		t.Errorf("expected deliveries %v, got %v", want, got.offsets())
+	}
+	if _, ok := got.msgs[1].Event.(event.UserUpdated); !ok {
+		t.Errorf("expected the committed event, got %#v", got.msgs[1].Event)
+	}
+}
+
+func TestDispatcherDecodesEveryEventType(t *testing.T) {
+	store := repository.New()
+	want := []event.Event{
+		event.UserRegistered{UserID: "u1"},
+		event.UserUpdated{UserID: "u1", Version: 2},
+		event.UserDeleted{UserID: "u1"},
+		event.UserErased{UserID: "u1"},
+	}
+	for _, e := range want {
+		record(t, store, e)
+	}
+
+	d := newDispatcher(store)
+	got := &collector{}
+	d.Subscribe("test", got.handle)
+
+	stop := runDispatcher(d)
+	got.waitFor(t, len(want))
+	stop()
+
+	for i, e := range want {
+		if got.msgs[i].Event != e {
+			t.Errorf("event %d: expected %#v, got %#v", i+1, e, got.msgs[i].Event)
+		}
+	}
+}
+
+func newDispatcher(store repository.Outbox) *event.Dispatcher {
+	return event.NewDispatcher(store, event.Options{
+		PollInterval: 5 * time.Millisecond,
+		MinBackoff:   time.Millisecond,
+		MaxBackoff:   5 * time.Millisecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func runDispatcher(d *event.Dispatcher) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func record(t *testing.T, store repository.Store, e event.Event) {
+	t.Helper()
+	err := store.WithTx(context.Background(), func(tx repository.Tx) error {
+		return event.Record(context.Background(), tx, e)
+	})
+	if err != nil {
+		t.Fatalf("record %s: %v", e.EventType(), err)
+	}
+}
+
+// collector records deliveries. It fails the first `failures` attempts to
+// handle failOffset.
+type collector struct {
+	mu         sync.Mutex
+	msgs       []event.Message
+	attempts   int
+	failOffset int64
+	failures   int
+}
+
+func (c *collector) handle(ctx context.Context, msg event.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attempts++
+	if msg.Offset == c.failOffset && c.failures > 0 {
+		c.failures--
+		return errors.New("subscriber unavailable")
+	}
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func (c *collector) offsets() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]int64, len(c.msgs))
+	for i, m := range c.msgs {
+		out[i] = m.Offset
+	}
+	return out
+}
+
+func (c *collector) waitFor(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		got := len(c.msgs)
+		c.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d deliveries, got %v", n, c.offsets())
+}
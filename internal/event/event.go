@@ -0,0 +1,116 @@
+// Package event defines the domain events recorded in the repository outbox
+// and a dispatcher that delivers them to in-process subscribers.
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+// Event types as stored in the outbox.
+const (
+	TypeUserRegistered = "user.registered"
+	TypeUserUpdated    = "user.updated"
+	TypeUserDeleted    = "user.deleted"
+	TypeUserErased     = "user.erased"
+)
+
+// Event is a domain event. Implementations are plain structs that
+// serialize to JSON.
+type Event interface {
+	EventType() string
+	AggregateID() string
+}
+
+// UserRegistered is recorded when a user is created, either through
+// registration or by an administrator.
+//
+// Events name users by ID only: the outbox is kept after a user is erased,
+// so it must not hold their personal data. Subscribers that need the
+// profile read it from the store.
+type UserRegistered struct {
+	UserID string `json:"user_id"`
+}
+
+// UserUpdated is recorded when a user's profile changes or a deleted user
+// is restored. Version is the user's version after the change.
+type UserUpdated struct {
+	UserID  string `json:"user_id"`
+	Version int64  `json:"version"`
+}
+
+// UserDeleted is recorded when a user is soft-deleted.
+type UserDeleted struct {
+	UserID string `json:"user_id"`
+}
+
+// UserErased is recorded when a user's personal data has been anonymized
+// at their request. Subscribers holding copies of it must erase them too.
+type UserErased struct {
+	UserID string `json:"user_id"`
+}
+
+func (UserRegistered) EventType() string { return TypeUserRegistered }
+func (UserUpdated) EventType() string    { return TypeUserUpdated }
+func (UserDeleted) EventType() string    { return TypeUserDeleted }
+func (UserErased) EventType() string     { return TypeUserErased }
+
+func (e UserRegistered) AggregateID() string { return e.UserID }
+func (e UserUpdated) AggregateID() string    { return e.UserID }
+func (e UserDeleted) AggregateID() string    { return e.UserID }
+func (e UserErased) AggregateID() string     { return e.UserID }
+
+// Record adds e to the outbox as part of tx.
+func Record(ctx context.Context, tx repository.Tx, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode %s event: %w", e.EventType(), err)
+	}
+	return tx.AppendEvent(ctx, repository.Event{
+		Type:        e.EventType(),
+		AggregateID: e.AggregateID(),
+		Payload:     payload,
+	})
+}
+
+// Message is an event as delivered to a subscriber.
+type Message struct {
+	Offset     int64
+	TenantID   string // the tenant the event was recorded in
+	OccurredAt time.Time
+	Event      Event
+}
+
+// Decode turns an outbox entry back into a typed Message.
+func Decode(e repository.Event) (Message, error) {
+	var ev Event
+	var err error
+	switch e.Type {
+	case TypeUserRegistered:
+		ev, err = decode[UserRegistered](e.Payload)
+	case TypeUserUpdated:
+		ev, err = decode[UserUpdated](e.Payload)
+	case TypeUserDeleted:
+		ev, err = decode[UserDeleted](e.Payload)
+	case TypeUserErased:
+		ev, err = decode[UserErased](e.Payload)
+	default:
+		return Message{}, fmt.Errorf("unknown event type %q", e.Type)
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("decode %s event: %w", e.Type, err)
+	}
+	return Message{Offset: e.Offset, TenantID: e.TenantID, OccurredAt: e.CreatedAt, Event: ev}, nil
+}
+
+func decode[T Event](payload json.RawMessage) (Event, error) {
+	var v T
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/muflihunaf/boilerplate-go/internal/httputil"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/pkg/response"
+)
+
+// ListAudit godoc
+// @Summary      List audit log entries
+// @Description  Returns recorded user writes, newest first. Admin only.
+// @Description  Pass meta.next_cursor back as cursor to fetch older entries.
+// @Tags         audit
+// @Produce      json
+// @Security     BearerAuth
+// @Param        actor   query     string  false  "Only entries made by this user ID"
+// @Param        target  query     string  false  "Only entries about this user ID"
+// @Param        since   query     string  false  "RFC 3339 timestamp, inclusive"
+// @Param        until   query     string  false  "RFC 3339 timestamp, exclusive"
+// @Param        limit   query     int     false  "Page size (default 50, max 200)"
+// @Param        cursor  query     string  false  "Opaque cursor from meta.next_cursor"
+// @Success      200  {array}   repository.AuditEntry
+// @Failure      400  {object}  response.Response
+// @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response
+// @Failure      500  {object}  response.Response
+// @Router       /audit [get]
+func (h *Handler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		BadRequest(w, err.Error())
+		return
+	}
+
+	entries, next, err := h.svc.ListAudit(r.Context(), f)
+	if err != nil {
+		slog.Error("list audit failed", "error", err)
+		InternalError(w)
+		return
+	}
+	if entries == nil {
+		entries = []repository.AuditEntry{}
+	}
+
+	meta := &response.Meta{PerPage: f.Limit}
+	if next > 0 {
+		meta.NextCursor = strconv.FormatInt(next, 10)
+	}
+	response.WithMeta(w, entries, meta)
+}
+
+func parseAuditFilter(q url.Values) (repository.AuditFilter, error) {
+	f := repository.AuditFilter{
+		ActorID:  q.Get("actor"),
+		TargetID: q.Get("target"),
+	}
+
+	var err error
+	if f.Since, err = timestamp(q, "since"); err != nil {
+		return f, err
+	}
+	if f.Until, err = timestamp(q, "until"); err != nil {
+		return f, err
+	}
+	if f.Limit, err = httputil.PositiveInt(q, "limit"); err != nil {
+		return f, err
+	}
+	if f.Limit == 0 {
+		f.Limit = repository.DefaultAuditLimit
+	}
+	if f.Limit > repository.MaxAuditLimit {
+		f.Limit = repository.MaxAuditLimit
+	}
+	if v := q.Get("cursor"); v != "" {
+		if f.BeforeID, err = strconv.ParseInt(v, 10, 64); err != nil || f.BeforeID < 1 {
+			return f, errors.New("invalid cursor")
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,86 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+)
+
+func TestListAudit(t *testing.T) {
+	svc := service.New(repository.New())
+	ctx := audit.WithActor(context.Background(), audit.Actor{UserID: "admin", RequestID: "req-1", IP: "10.0.0.1"})
+
+	alice, err := svc.CreateUser(ctx, "Alice", "alice@example.com", nil)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := svc.CreateUser(ctx, "Bob", "bob@example.com", nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := svc.UpdateUser(ctx, alice.ID, repository.UserUpdate{Name: "Alicia"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	h := handler.New(svc, nil, nil, handler.Options{})
+
+	type page struct {
+		Data []repository.AuditEntry `json:"data"`
+		Meta struct {
+			NextCursor string `json:"next_cursor"`
+		} `json:"meta"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ListAudit(rec, httptest.NewRequest(http.MethodGet, "/api/v1/audit?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", query, http.StatusOK, rec.Code)
+		}
+		var p page
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return p
+	}
+
+	first := list("target=" + alice.ID + "&limit=1")
+	if len(first.Data) != 1 || first.Data[0].Action != audit.ActionUserUpdate || first.Meta.NextCursor == "" {
+		t.Fatalf("expected the newest entry and a cursor, got %+v", first)
+	}
+	e := first.Data[0]
+	if e.ActorID != "admin" || e.RequestID != "req-1" || e.IP != "10.0.0.1" {
+		t.Errorf("expected the actor to be recorded, got %+v", e)
+	}
+	if len(e.Changes) == 0 || e.Changes[0] != (repository.FieldChange{Field: "name", From: "Alice", To: "Alicia"}) {
+		t.Errorf("expected the name change first, got %+v", e.Changes)
+	}
+
+	second := list("target=" + alice.ID + "&limit=1&cursor=" + first.Meta.NextCursor)
+	if len(second.Data) != 1 || second.Data[0].Action != audit.ActionUserCreate || second.Meta.NextCursor != "" {
+		t.Errorf("expected the create entry on the last page, got %+v", second)
+	}
+
+	if all := list("actor=admin"); len(all.Data) != 3 {
+		t.Errorf("expected 3 entries by admin, got %d", len(all.Data))
+	}
+}
+
+func TestListAuditInvalidQuery(t *testing.T) {
+	h := newTestHandler()
+
+	for _, query := range []string{"limit=0", "since=yesterday", "until=1", "cursor=abc", "cursor=-1"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/audit?"+query, nil)
+		rec := httptest.NewRecorder()
+		h.ListAudit(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
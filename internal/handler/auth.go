@@ -2,11 +2,16 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
 	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
 )
 
 // --- Request/Response Types ---
@@ -14,6 +19,9 @@ import (
 type LoginRequest struct {
 	Email    string `json:"email" example:"user@example.com"`
 	Password string `json:"password" example:"secret123"`
+	// OrganizationID selects the organization to sign in to; omit it for
+	// accounts that belong to none.
+	OrganizationID string `json:"organization_id,omitempty" example:""`
 }
 
 type RegisterRequest struct {
@@ -22,23 +30,44 @@ type RegisterRequest struct {
 	Password string `json:"password" example:"secret123"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" example:"q3Zk1t0m7yQ4c2vR8pW5nX9bL6hJ0sD3fG1aE7uI2oY"`
+}
+
+// LogoutRequest is optional. RefreshToken also revokes the session the
+// refresh token belongs to; All signs the user out of every session.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty" example:"q3Zk1t0m7yQ4c2vR8pW5nX9bL6hJ0sD3fG1aE7uI2oY"`
+	All          bool   `json:"all,omitempty" example:"false"`
+}
+
+// AuthResponse carries an access token and the refresh token that replaces
+// it before it expires. Lifetimes are in seconds.
 type AuthResponse struct {
-	Token     string       `json:"token"`
-	ExpiresIn int64        `json:"expires_in"`
-	User      UserResponse `json:"user"`
+	Token            string       `json:"token"`
+	ExpiresIn        int64        `json:"expires_in"`
+	RefreshToken     string       `json:"refresh_token"`
+	RefreshExpiresIn int64        `json:"refresh_expires_in"`
+	User             UserResponse `json:"user"`
 }
 
 type UserResponse struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Email          string `json:"email"`
+	OrganizationID string `json:"organization_id,omitempty"`
+	// EraseAt is set while the user's erasure is pending.
+	EraseAt  *time.Time     `json:"erase_at,omitempty"`
+	Roles    []string       `json:"roles,omitempty" example:"member"`
+	Metadata map[string]any `json:"metadata,omitempty"`
 }
 
 // --- Handlers ---
 
 // Login godoc
 // @Summary      User login
-// @Description  Authenticate user with email and password
+// @Description  Authenticate user with email and password. Emails are unique per organization,
+// @Description  so members of an organization must also send its ID.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -59,7 +88,8 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.authSvc.Login(r.Context(), req.Email, req.Password)
+	ctx := tenant.WithID(r.Context(), req.OrganizationID)
+	result, err := h.authSvc.Login(ctx, req.Email, req.Password)
 	if err != nil {
 		if err == service.ErrInvalidCredentials {
 			Unauthorized(w, "invalid email or password")
@@ -91,13 +121,8 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Name == "" || req.Email == "" || req.Password == "" {
-		BadRequest(w, "name, email and password are required")
-		return
-	}
-
-	if len(req.Password) < 6 {
-		BadRequest(w, "password must be at least 6 characters")
+	if msg := checkRegistration(req); msg != "" {
+		BadRequest(w, msg)
 		return
 	}
 
@@ -115,6 +140,85 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	Created(w, toAuthResponse(result))
 }
 
+// Refresh godoc
+// @Summary      Refresh tokens
+// @Description  Exchanges a refresh token for a new access token and a new refresh token. Each
+// @Description  refresh token works once; presenting one again revokes every token descended
+// @Description  from the same login.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request  body      RefreshRequest  true  "Refresh token"
+// @Success      200      {object}  AuthResponse
+// @Failure      400      {object}  response.Response
+// @Failure      401      {object}  response.Response
+// @Router       /auth/refresh [post]
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, "invalid request body")
+		return
+	}
+
+	if req.RefreshToken == "" {
+		BadRequest(w, "refresh_token is required")
+		return
+	}
+
+	result, err := h.authSvc.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		if err == service.ErrInvalidRefreshToken {
+			Unauthorized(w, "invalid or expired refresh token")
+			return
+		}
+		slog.Error("token refresh failed", "error", err)
+		InternalError(w)
+		return
+	}
+
+	OK(w, toAuthResponse(result))
+}
+
+// Logout godoc
+// @Summary      Log out
+// @Description  Revokes the access token used for the request. Send the refresh token to end its
+// @Description  session too, or all=true to revoke every access and refresh token of the user.
+// @Tags         auth
+// @Accept       json
+// @Security     BearerAuth
+// @Param        request  body  LogoutRequest  false  "Session to end"
+// @Success      204
+// @Failure      400  {object}  response.Response
+// @Failure      401  {object}  response.Response
+// @Router       /auth/logout [post]
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaims(r.Context())
+	if !ok {
+		Unauthorized(w, "user not found in context")
+		return
+	}
+
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		BadRequest(w, "invalid request body")
+		return
+	}
+
+	var err error
+	if req.All {
+		err = h.authSvc.RevokeAllTokens(r.Context(), claims.UserID)
+	} else {
+		err = h.authSvc.Logout(r.Context(), claims, req.RefreshToken)
+	}
+	if err != nil && err != service.ErrUserNotFound {
+		slog.Error("logout failed", "error", err, "user_id", claims.UserID)
+		InternalError(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Me godoc
 // @Summary      Get current user
 // @Description  Returns the authenticated user's profile
@@ -144,16 +248,37 @@ func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	OK(w, UserResponse{ID: user.ID, Name: user.Name, Email: user.Email})
+	OK(w, toUserResponse(user))
 }
 
 // --- Helpers ---
 
 func toAuthResponse(r *service.AuthResult) AuthResponse {
 	return AuthResponse{
-		Token:     r.Token,
-		ExpiresIn: 86400,
-		User:      UserResponse{ID: r.User.ID, Name: r.User.Name, Email: r.User.Email},
+		Token:            r.Token,
+		ExpiresIn:        secondsUntil(r.ExpiresAt),
+		RefreshToken:     r.RefreshToken,
+		RefreshExpiresIn: secondsUntil(r.RefreshExpiresAt),
+		User:             toUserResponse(r.User),
+	}
+}
+
+func secondsUntil(t time.Time) int64 {
+	return int64(time.Until(t).Round(time.Second) / time.Second)
+}
+
+func toUserResponse(u *repository.User) UserResponse {
+	return UserResponse{ID: u.ID, Name: u.Name, Email: u.Email, OrganizationID: u.TenantID, EraseAt: u.EraseAt, Roles: u.Roles, Metadata: u.Metadata}
+}
+
+// checkRegistration returns what is wrong with a registration, or "".
+func checkRegistration(req RegisterRequest) string {
+	if req.Name == "" || req.Email == "" || req.Password == "" {
+		return "name, email and password are required"
+	}
+	if len(req.Password) < 6 {
+		return "password must be at least 6 characters"
 	}
+	return ""
 }
 
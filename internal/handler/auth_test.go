@@ -0,0 +1,210 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
+)
+
+func TestLoginRehashesPassword(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.New()
+	legacy, err := password.Bcrypt{Cost: bcrypt.MinCost}.Hash("secret123")
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	user, err := repo.CreateUserWithHash(ctx, "Alice", "alice@example.com", legacy)
+	if err != nil {
+		t.Fatalf("CreateUserWithHash: %v", err)
+	}
+
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: 3600, Issuer: "test"})
+	authSvc := service.NewAuthService(repo, jwtSvc, time.Hour, 24*time.Hour)
+	argon := password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	authSvc.SetPasswordHasher(password.New(argon, password.Bcrypt{Cost: bcrypt.MinCost}))
+	h := handler.New(service.New(repo), authSvc, nil, handler.Options{})
+
+	login := func(pw string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login",
+			strings.NewReader(`{"email":"alice@example.com","password":"`+pw+`"}`))
+		rec := httptest.NewRecorder()
+		h.Login(rec, req)
+		return rec.Code
+	}
+
+	if code := login("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if got, _ := repo.GetUser(ctx, user.ID); got.Password != legacy {
+		t.Fatal("expected a failed login to leave the hash alone")
+	}
+
+	if code := login("secret123"); code != http.StatusOK {
+		t.Fatalf("login: expected %d, got %d", http.StatusOK, code)
+	}
+	got, _ := repo.GetUser(ctx, user.ID)
+	if !strings.HasPrefix(got.Password, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("expected the bcrypt hash to be replaced by argon2id, got %q", got.Password)
+	}
+	if code := login("secret123"); code != http.StatusOK {
+		t.Fatalf("login after rehash: expected %d, got %d", http.StatusOK, code)
+	}
+	if again, _ := repo.GetUser(ctx, user.ID); again.Password != got.Password {
+		t.Error("expected a current hash not to be replaced again")
+	}
+
+	entries, _, err := repo.ListAudit(ctx, repository.AuditFilter{TargetID: user.ID})
+	if err != nil || len(entries) != 1 || entries[0].Action != audit.ActionPasswordRehash || entries[0].ActorID != user.ID {
+		t.Errorf("expected one rehash audit entry by the user, got %+v, %v", entries, err)
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	repo := repository.New()
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: 15 * time.Minute, Issuer: "test"})
+	authSvc := service.NewAuthService(repo, jwtSvc, 15*time.Minute, 24*time.Hour)
+	h := handler.New(service.New(repo), authSvc, nil, handler.Options{})
+
+	call := func(fn http.HandlerFunc, body string) (int, handler.AuthResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		fn(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		var env struct {
+			Data handler.AuthResponse `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &env)
+		return rec.Code, env.Data
+	}
+	refresh := func(token string) (int, handler.AuthResponse) {
+		return call(h.Refresh, `{"refresh_token":"`+token+`"}`)
+	}
+
+	code, login := call(h.Register, `{"name":"Alice","email":"alice@example.com","password":"secret123"}`)
+	if code != http.StatusCreated || login.RefreshToken == "" {
+		t.Fatalf("register: expected %d with a refresh token, got %d %+v", http.StatusCreated, code, login)
+	}
+	if login.ExpiresIn != 900 || login.RefreshExpiresIn != 86400 {
+		t.Errorf("expected the configured lifetimes, got expires_in=%d refresh_expires_in=%d", login.ExpiresIn, login.RefreshExpiresIn)
+	}
+
+	code, first := refresh(login.RefreshToken)
+	if code != http.StatusOK || first.RefreshToken == "" || first.RefreshToken == login.RefreshToken || first.User.Email != "alice@example.com" {
+		t.Fatalf("refresh: expected a new refresh token, got %d %+v", code, first)
+	}
+	if _, err := jwtSvc.ValidateToken(first.Token); err != nil {
+		t.Errorf("expected a valid access token, got %v", err)
+	}
+	code, second := refresh(first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("second refresh: expected %d, got %d", http.StatusOK, code)
+	}
+
+	// Replaying a used token revokes the family, including the latest token.
+	if code, _ := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reused token: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := refresh(second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("token of a revoked family: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	// Other logins are unaffected.
+	code, other := call(h.Login, `{"email":"alice@example.com","password":"secret123"}`)
+	if code != http.StatusOK {
+		t.Fatalf("login: expected %d, got %d", http.StatusOK, code)
+	}
+	if code, _ := refresh(other.RefreshToken); code != http.StatusOK {
+		t.Errorf("refresh after a new login: expected %d, got %d", http.StatusOK, code)
+	}
+
+	if code, _ := refresh("unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code, _ := call(h.Refresh, `{}`); code != http.StatusBadRequest {
+		t.Errorf("missing token: expected %d, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestLogoutRevokesTokens(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.New()
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: 15 * time.Minute, Issuer: "test"})
+	authSvc := service.NewAuthService(repo, jwtSvc, 15*time.Minute, 24*time.Hour)
+	h := handler.New(service.New(repo), authSvc, nil, handler.Options{})
+
+	r := chi.NewRouter()
+	r.Post("/auth/refresh", h.Refresh)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Auth(jwtSvc, authSvc))
+		r.Post("/auth/logout", h.Logout)
+		r.Get("/me", h.Me)
+	})
+	do := func(method, path, token, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	refresh := func(token string) int {
+		return do(http.MethodPost, "/auth/refresh", "", `{"refresh_token":"`+token+`"}`)
+	}
+
+	first, err := authSvc.Register(ctx, "Alice", "alice@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	second, err := authSvc.Login(ctx, "alice@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if code := do(http.MethodPost, "/auth/logout", first.Token, `{"refresh_token":"`+first.RefreshToken+`"}`); code != http.StatusNoContent {
+		t.Fatalf("logout: expected %d, got %d", http.StatusNoContent, code)
+	}
+	if code := do(http.MethodGet, "/me", first.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := do(http.MethodGet, "/me", second.Token, ""); code != http.StatusOK {
+		t.Errorf("other session after logout: expected %d, got %d", http.StatusOK, code)
+	}
+	if code := do(http.MethodPost, "/auth/logout", first.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("second logout with a revoked token: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	// Signing out everywhere revokes every token issued so far.
+	if code := do(http.MethodPost, "/auth/logout", second.Token, `{"all":true}`); code != http.StatusNoContent {
+		t.Fatalf("logout everywhere: expected %d, got %d", http.StatusNoContent, code)
+	}
+	if code := do(http.MethodGet, "/me", second.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("access token after logout everywhere: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := refresh(second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout everywhere: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	entries, _, err := repo.ListAudit(ctx, repository.AuditFilter{TargetID: first.User.ID})
+	if err != nil || len(entries) == 0 || entries[0].Action != audit.ActionTokensRevoke || entries[0].ActorID != first.User.ID {
+		t.Errorf("expected a tokens_revoke audit entry by the user, got %+v, %v", entries, err)
+	}
+
+}
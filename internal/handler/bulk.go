@@ -0,0 +1,417 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/response"
+)
+
+// Import limits. Rows are parsed up front so that no transaction stays
+// open while the client is still uploading.
+const (
+	MaxImportRows  = 10000
+	maxImportBytes = 10 << 20
+)
+
+// Bulk formats, selected with ?format= or the Content-Type / Accept header.
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+var errTooManyRows = fmt.Errorf("an import is limited to %d rows", MaxImportRows)
+
+// --- Request/Response Types ---
+
+// ImportRecord is one NDJSON import line. CSV imports use the same fields
+// as header names, except metadata; other columns are ignored.
+type ImportRecord struct {
+	Name     string         `json:"name" example:"John Doe"`
+	Email    string         `json:"email" example:"user@example.com"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type ImportResponse struct {
+	Mode    string            `json:"mode" example:"atomic"`
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+type ImportRowResult struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status" example:"created"`
+	UserID string `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// --- Handlers ---
+
+// ImportUsers godoc
+// @Summary      Import users
+// @Description  Creates users from a CSV (header row with name and email columns) or NDJSON body
+// @Description  and reports the outcome of every row. In atomic mode (default) nothing is imported
+// @Description  if any row fails, and the failures are returned with status 422; in best_effort mode
+// @Description  every valid row is imported. dry_run validates without creating anyone.
+// @Tags         users
+// @Accept       text/csv
+// @Accept       application/x-ndjson
+// @Produce      json
+// @Security     BearerAuth
+// @Param        format   query     string  false  "csv or ndjson; defaults to the Content-Type"
+// @Param        mode     query     string  false  "atomic (default) or best_effort"
+// @Param        dry_run  query     bool    false  "Validate only"
+// @Success      200  {object}  ImportResponse
+// @Failure      400  {object}  response.Response
+// @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response
+// @Failure      413  {object}  response.Response
+// @Failure      415  {object}  response.Response
+// @Failure      422  {object}  response.Response
+// @Failure      500  {object}  response.Response
+// @Router       /users/import [post]
+func (h *Handler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := importFormat(q.Get("format"), r.Header.Get("Content-Type"))
+	if format == "" {
+		Error(w, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "send text/csv or application/x-ndjson, or set format")
+		return
+	}
+	opts := service.ImportOptions{Mode: service.ImportMode(q.Get("mode"))}
+	if v := q.Get("dry_run"); v != "" {
+		var err error
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			BadRequest(w, "dry_run must be true or false")
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var rows []service.ImportRow
+	var err error
+	if format == formatCSV {
+		rows, err = parseCSVImport(body)
+	} else {
+		rows, err = parseNDJSONImport(body)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			Error(w, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "import body is too large")
+			return
+		}
+		BadRequest(w, err.Error())
+		return
+	}
+	if len(rows) == 0 {
+		BadRequest(w, "no rows to import")
+		return
+	}
+
+	res, err := h.svc.ImportUsers(r.Context(), rows, opts)
+	if err != nil {
+		if err == service.ErrInvalidInput {
+			BadRequest(w, "mode must be atomic or best_effort")
+			return
+		}
+		slog.Error("import users failed", "error", err, "rows", len(rows))
+		InternalError(w)
+		return
+	}
+
+	if res.Mode == service.ImportAtomic && res.Failed > 0 && !res.DryRun {
+		details := make(map[string]string, res.Failed)
+		for _, row := range res.Rows {
+			if row.Status == service.RowFailed {
+				details["row "+strconv.Itoa(row.Row)] = row.Error
+			}
+		}
+		msg := fmt.Sprintf("%d of %d rows failed; nothing was imported", res.Failed, len(res.Rows))
+		response.ValidationError(w, msg, details)
+		return
+	}
+	OK(w, toImportResponse(res))
+}
+
+// ExportUsers godoc
+// @Summary      Export users
+// @Description  Streams every user matching the filters, oldest first, as CSV or NDJSON
+// @Tags         users
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Security     BearerAuth
+// @Param        format          query     string  false  "csv or ndjson; defaults to the Accept header, then ndjson"
+// @Param        email_domain    query     string  false  "Only users whose email is at this domain"
+// @Param        name            query     string  false  "Case-insensitive name substring"
+// @Param        created_after   query     string  false  "RFC 3339 timestamp, inclusive"
+// @Param        created_before  query     string  false  "RFC 3339 timestamp, exclusive"
+// @Param        include_deleted query     bool    false  "Include soft-deleted users"
+// @Success      200
+// @Failure      400  {object}  response.Response
+// @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response
+// @Failure      500  {object}  response.Response
+// @Router       /users/export [get]
+func (h *Handler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := exportFormat(q.Get("format"), r.Header.Get("Accept"))
+	if format == "" {
+		BadRequest(w, "format must be csv or ndjson")
+		return
+	}
+	opts, err := parseListOptions(q)
+	if err != nil {
+		BadRequest(w, err.Error())
+		return
+	}
+
+	exp := newUserExporter(w, format)
+	err = h.svc.ExportUsers(r.Context(), opts, exp.write)
+	if err == nil {
+		err = exp.close()
+	}
+	if err != nil {
+		if !exp.started {
+			if err == service.ErrEncryptedField {
+				BadRequest(w, err.Error())
+				return
+			}
+			slog.Error("export users failed", "error", err)
+			InternalError(w)
+			return
+		}
+		// The status line is gone; cutting the stream short is all we can do.
+		slog.Error("export users aborted", "error", err, "rows", exp.rows)
+	}
+}
+
+// --- Helpers ---
+
+func importFormat(param, contentType string) string {
+	if param != "" {
+		return bulkFormat(param)
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return formatCSV
+	case "application/x-ndjson", "application/ndjson":
+		return formatNDJSON
+	}
+	return ""
+}
+
+func exportFormat(param, accept string) string {
+	if param != "" {
+		return bulkFormat(param)
+	}
+	if strings.Contains(accept, "text/csv") {
+		return formatCSV
+	}
+	return formatNDJSON
+}
+
+func bulkFormat(s string) string {
+	switch s {
+	case formatCSV, formatNDJSON:
+		return s
+	}
+	return ""
+}
+
+// parseCSVImport reads rows from a CSV body whose header names the name and
+// email columns. A malformed line fails its row only.
+func parseCSVImport(body io.Reader) ([]service.ImportRow, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	nameCol, emailCol := -1, -1
+	for i, col := range header {
+		switch strings.ToLower(strings.TrimSpace(col)) {
+		case "name":
+			nameCol = i
+		case "email":
+			emailCol = i
+		}
+	}
+	if nameCol < 0 || emailCol < 0 {
+		return nil, errors.New("CSV header must include name and email columns")
+	}
+
+	var rows []service.ImportRow
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if len(rows) == MaxImportRows {
+			return nil, errTooManyRows
+		}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			rows = append(rows, service.ImportRow{ParseErr: errors.New("malformed CSV line")})
+			continue
+		case err != nil:
+			return nil, err
+		}
+		rows = append(rows, service.ImportRow{
+			Name:  strings.TrimSpace(field(rec, nameCol)),
+			Email: strings.TrimSpace(field(rec, emailCol)),
+		})
+	}
+}
+
+// parseNDJSONImport reads one ImportRecord per non-blank line. A line that
+// is not a valid record fails its row only.
+func parseNDJSONImport(body io.Reader) ([]service.ImportRow, error) {
+	sc := bufio.NewScanner(body)
+	var rows []service.ImportRow
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if len(rows) == MaxImportRows {
+			return nil, errTooManyRows
+		}
+		var rec ImportRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			rows = append(rows, service.ImportRow{ParseErr: errors.New("malformed JSON line")})
+			continue
+		}
+		rows = append(rows, service.ImportRow{
+			Name:     strings.TrimSpace(rec.Name),
+			Email:    strings.TrimSpace(rec.Email),
+			Metadata: rec.Metadata,
+		})
+	}
+	return rows, sc.Err()
+}
+
+func field(rec []string, i int) string {
+	if i < len(rec) {
+		return rec[i]
+	}
+	return ""
+}
+
+func toImportResponse(res *service.ImportResult) ImportResponse {
+	out := ImportResponse{
+		Mode:    string(res.Mode),
+		DryRun:  res.DryRun,
+		Total:   len(res.Rows),
+		Created: res.Created,
+		Failed:  res.Failed,
+		Rows:    make([]ImportRowResult, len(res.Rows)),
+	}
+	for i, r := range res.Rows {
+		out.Rows[i] = ImportRowResult{Row: r.Row, Email: r.Email, Status: r.Status, UserID: r.UserID, Error: r.Error}
+	}
+	return out
+}
+
+var exportColumns = []string{"id", "name", "email", "version", "created_at", "updated_at", "deleted_at"}
+
+// userExporter writes users to the response as they arrive. Headers are
+// only sent with the first write, so a failure before it can still be
+// reported as an error response.
+type userExporter struct {
+	w       http.ResponseWriter
+	format  string
+	csv     *csv.Writer
+	json    *json.Encoder
+	started bool
+	rows    int
+}
+
+func newUserExporter(w http.ResponseWriter, format string) *userExporter {
+	return &userExporter{w: w, format: format}
+}
+
+func (e *userExporter) start() error {
+	e.started = true
+	if e.format == formatCSV {
+		e.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		e.w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		e.csv = csv.NewWriter(e.w)
+		return e.csv.Write(exportColumns)
+	}
+	e.w.Header().Set("Content-Type", "application/x-ndjson")
+	e.w.Header().Set("Content-Disposition", `attachment; filename="users.ndjson"`)
+	e.json = json.NewEncoder(e.w)
+	return nil
+}
+
+func (e *userExporter) write(u *repository.User) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	e.rows++
+	if e.json != nil {
+		return e.json.Encode(u)
+	}
+	var deletedAt string
+	if u.DeletedAt != nil {
+		deletedAt = u.DeletedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return e.csv.Write([]string{
+		u.ID,
+		csvCell(u.Name),
+		csvCell(u.Email),
+		strconv.FormatInt(u.Version, 10),
+		u.CreatedAt.UTC().Format(time.RFC3339Nano),
+		u.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		deletedAt,
+	})
+}
+
+// csvCell keeps a user-supplied value from being read as a formula by
+// spreadsheet applications, by prefixing those that could start one with
+// an apostrophe.
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// close finishes the stream, starting it first if there were no users.
+func (e *userExporter) close() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/server"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+)
+
+func TestImportUsers(t *testing.T) {
+	svc := service.New(repository.New())
+	if _, err := svc.CreateUser(context.Background(), "Existing", "taken@example.com", nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	h := handler.New(svc, nil, nil, handler.Options{})
+
+	importUsers := func(query, contentType, body string) (int, handler.ImportResponse, map[string]string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/import?"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.ImportUsers(rec, req)
+
+		var envelope struct {
+			Data  handler.ImportResponse
+			Error struct{ Details map[string]string }
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&envelope); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", query, err)
+		}
+		return rec.Code, envelope.Data, envelope.Error.Details
+	}
+	count := func() int {
+		t.Helper()
+		page, err := svc.ListUsers(context.Background(), repository.ListOptions{PerPage: repository.MaxPerPage})
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+		return len(page.Users)
+	}
+
+	csvBody := "Email,Name,Team\n" +
+		"ann@example.com,Ann,red\n" +
+		"not-an-email,Bad,blue\n" +
+		"TAKEN@example.com,Dup,green\n" +
+		"ben@example.com,Ben\n"
+
+	code, _, details := importUsers("", "text/csv", csvBody)
+	if code != http.StatusUnprocessableEntity {
+		t.Fatalf("atomic import with failures: expected %d, got %d", http.StatusUnprocessableEntity, code)
+	}
+	if len(details) != 2 || details["row 2"] == "" || details["row 3"] != "email already exists" {
+		t.Errorf("expected rows 2 and 3 to be reported, got %v", details)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expected a failed atomic import to create nobody, found %d users", n)
+	}
+
+	code, res, _ := importUsers("mode=best_effort&dry_run=true", "text/csv", csvBody)
+	if code != http.StatusOK || !res.DryRun || res.Created != 0 || res.Failed != 2 {
+		t.Fatalf("dry run: unexpected result %d %+v", code, res)
+	}
+	if res.Rows[0].Status != service.RowValid || res.Rows[3].Status != service.RowValid {
+		t.Errorf("dry run: expected valid rows to be reported as such, got %+v", res.Rows)
+	}
+	if n := count(); n != 1 {
+		t.Fatalf("expected a dry run to create nobody, found %d users", n)
+	}
+
+	code, res, _ = importUsers("mode=best_effort", "text/csv", csvBody)
+	if code != http.StatusOK || res.Total != 4 || res.Created != 2 || res.Failed != 2 {
+		t.Fatalf("best effort: unexpected result %d %+v", code, res)
+	}
+	if res.Rows[0].Status != service.RowCreated || res.Rows[0].UserID == "" {
+		t.Errorf("best effort: expected row 1 to be created, got %+v", res.Rows[0])
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("expected best effort to create the two valid rows, found %d users", n)
+	}
+
+	ndjson := `{"name":"Cat","email":"cat@example.com"}` + "\n\n" +
+		`{"name":"Cat again","email":"CAT@example.com"}` + "\n" +
+		`{"name":` + "\n"
+	code, _, details = importUsers("format=ndjson", "text/plain", ndjson)
+	if code != http.StatusUnprocessableEntity || details["row 2"] != "email already exists" || details["row 3"] != "malformed JSON line" {
+		t.Errorf("ndjson with duplicate rows: unexpected result %d %v", code, details)
+	}
+	code, res, _ = importUsers("", "application/x-ndjson", `{"name":"Cat","email":"cat@example.com"}`)
+	if code != http.StatusOK || res.Mode != "atomic" || res.Created != 1 {
+		t.Errorf("ndjson import: unexpected result %d %+v", code, res)
+	}
+
+	for _, tc := range []struct {
+		query, contentType, body string
+		want                     int
+	}{
+		{"", "application/json", `{}`, http.StatusUnsupportedMediaType},
+		{"mode=sometimes", "text/csv", "name,email\nA,a@example.com\n", http.StatusBadRequest},
+		{"", "text/csv", "name,phone\nA,555\n", http.StatusBadRequest},
+		{"", "text/csv", "name,email\n", http.StatusBadRequest},
+	} {
+		if code, _, _ := importUsers(tc.query, tc.contentType, tc.body); code != tc.want {
+			t.Errorf("%q %s %q: expected %d, got %d", tc.query, tc.contentType, tc.body, tc.want, code)
+		}
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	svc := service.New(repository.New())
+	ctx := context.Background()
+	// More than a page, so the export has to follow cursors.
+	total := repository.MaxPerPage + 5
+	for i := 0; i < total; i++ {
+		if _, err := svc.CreateUser(ctx, fmt.Sprintf("User %d", i), fmt.Sprintf("user%d@example.com", i), nil); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	if _, err := svc.CreateUser(ctx, "Other", "other@other.test", nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	h := handler.New(svc, nil, nil, handler.Options{})
+
+	export := func(query, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users/export?"+query, nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		h.ExportUsers(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", query, http.StatusOK, rec.Code)
+		}
+		return rec
+	}
+
+	rec := export("email_domain=example.com", "text/csv")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected a CSV content type, got %q", ct)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+	if len(records) != total+1 || records[0][0] != "id" {
+		t.Fatalf("expected a header and %d users, got %d records", total, len(records))
+	}
+	if records[1][1] != "User 0" || records[total][1] != fmt.Sprintf("User %d", total-1) {
+		t.Errorf("expected users oldest first, got %q ... %q", records[1][1], records[total][1])
+	}
+
+	rec = export("format=ndjson&email_domain=other.test", "text/csv")
+	var users []repository.User
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		var u repository.User
+		if err := json.Unmarshal(sc.Bytes(), &u); err != nil {
+			t.Fatalf("failed to decode line %q: %v", sc.Text(), err)
+		}
+		users = append(users, u)
+	}
+	if len(users) != 1 || users[0].Email != "other@other.test" {
+		t.Errorf("expected only the other.test user, got %+v", users)
+	}
+
+	// Values that a spreadsheet would run as formulas are defused.
+	if _, err := svc.CreateUser(ctx, "=HYPERLINK(\"http://evil.test\")", "formula@formula.test", nil); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	records, err = csv.NewReader(export("email_domain=formula.test", "text/csv").Body).ReadAll()
+	if err != nil || len(records) != 2 || records[1][1] != `'=HYPERLINK("http://evil.test")` {
+		t.Errorf("expected the name to be prefixed with an apostrophe, got %q, %v", records, err)
+	}
+
+	rec = export("format=csv&email_domain=nobody.test", "")
+	if body := rec.Body.String(); body != "id,name,email,version,created_at,updated_at,deleted_at\n" {
+		t.Errorf("expected just the header for an empty export, got %q", body)
+	}
+}
+
+// Imports are exempt from the global 1 MB body limit and have their own.
+func TestImportUsersBodyLimit(t *testing.T) {
+	repo := repository.New()
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test"})
+	authSvc := service.NewAuthService(repo, jwtSvc, time.Hour, 24*time.Hour)
+	r := chi.NewRouter()
+	server.SetupMiddleware(r)
+	server.RegisterRoutes(r, handler.New(service.New(repo), authSvc, nil, handler.Options{}), jwtSvc, authSvc)
+
+	admin, err := authSvc.Register(context.Background(), "Ann", "ann@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	post := func(path, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+admin.Token)
+		req.Header.Set("Content-Type", "text/csv")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Two users with notes, in an extra column the import ignores.
+	notes := strings.Repeat("x", 600<<10)
+	body := "name,email,notes\n" +
+		"Ben,ben@example.com," + notes + "\n" +
+		"Cat,cat@example.com," + notes + "\n"
+	if code := post("/api/v1/users/import?dry_run=true", body); code != http.StatusOK {
+		t.Errorf("import over 1 MB: expected %d, got %d", http.StatusOK, code)
+	}
+	big := "name,email,notes\n" + strings.Repeat("Ben,ben@example.com,"+notes+"\n", 18)
+	if code := post("/api/v1/users/import?dry_run=true", big); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("import over 10 MB: expected %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+}
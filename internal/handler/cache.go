@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"net/http"
+)
+
+// CacheStats godoc
+// @Summary      User cache statistics
+// @Description  Returns the user cache's hit, miss, coalescing, eviction and invalidation
+// @Description  counters since startup, and its size. Admin only.
+// @Tags         admin
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  repository.CacheStats
+// @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response
+// @Failure      404  {object}  response.Response
+// @Router       /admin/cache [get]
+func (h *Handler) CacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.opts.CacheStats == nil {
+		NotFound(w, "the user cache is disabled")
+		return
+	}
+	OK(w, h.opts.CacheStats())
+}
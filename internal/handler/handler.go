@@ -1,17 +1,73 @@
 package handler
 
 import (
+	"context"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
 	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
 )
 
+// UserService is the user management logic the handlers depend on.
+type UserService interface {
+	ListUsers(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error)
+	GetUser(ctx context.Context, id string) (*repository.User, error)
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*repository.User, error)
+	UpdateUser(ctx context.Context, id string, upd repository.UserUpdate) (*repository.User, error)
+	DeleteUser(ctx context.Context, id string, ifVersion int64) error
+	RestoreUser(ctx context.Context, id string) (*repository.User, error)
+	ListAudit(ctx context.Context, f repository.AuditFilter) ([]repository.AuditEntry, int64, error)
+	ImportUsers(ctx context.Context, rows []service.ImportRow, opts service.ImportOptions) (*service.ImportResult, error)
+	ExportUsers(ctx context.Context, opts repository.ListOptions, fn func(u *repository.User) error) error
+	ExportPersonalData(ctx context.Context, userID string) (*service.PersonalData, error)
+	RequestErasure(ctx context.Context, userID, password string, grace time.Duration) (*repository.User, error)
+	CancelErasure(ctx context.Context, userID string) (*repository.User, error)
+}
+
+// AuthService is the authentication logic the handlers depend on.
+type AuthService interface {
+	Login(ctx context.Context, email, password string) (*service.AuthResult, error)
+	Register(ctx context.Context, name, email, password string) (*service.AuthResult, error)
+	Refresh(ctx context.Context, refreshToken string) (*service.AuthResult, error)
+	Logout(ctx context.Context, claims *jwt.Claims, refreshToken string) error
+	RevokeAllTokens(ctx context.Context, userID string) error
+	GetCurrentUser(ctx context.Context, userID string) (*repository.User, error)
+}
+
+// OrgService is the organization logic the handlers depend on.
+type OrgService interface {
+	CreateOrganization(ctx context.Context, name, ownerName, ownerEmail, ownerPassword string) (*repository.Organization, *service.AuthResult, error)
+	GetOrganization(ctx context.Context, id string) (*repository.Organization, error)
+	Invite(ctx context.Context, orgID, email string) (*service.Invitation, error)
+	AcceptInvite(ctx context.Context, token, name, password string) (*service.AuthResult, error)
+}
+
+// Options tunes handler behaviour.
+type Options struct {
+	// RequireIfMatch makes PUT and DELETE on users fail with 428 unless the
+	// client sends an If-Match header.
+	RequireIfMatch bool
+	// ErasureGracePeriod is how long after a DELETE /me the account's
+	// data is erased, during which the user can cancel.
+	ErasureGracePeriod time.Duration
+	// CacheStats reports the user cache's counters; nil when there is no
+	// cache.
+	CacheStats func() repository.CacheStats
+}
+
 type Handler struct {
-	svc     *service.Service
-	authSvc *service.AuthService
+	svc     UserService
+	authSvc AuthService
+	orgSvc  OrgService
+	opts    Options
 }
 
-func New(svc *service.Service, authSvc *service.AuthService) *Handler {
+func New(svc UserService, authSvc AuthService, orgSvc OrgService, opts Options) *Handler {
 	return &Handler{
 		svc:     svc,
 		authSvc: authSvc,
+		orgSvc:  orgSvc,
+		opts:    opts,
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/muflihunaf/boilerplate-go/internal/handler"
 	"github.com/muflihunaf/boilerplate-go/internal/repository"
@@ -56,7 +57,8 @@ func newTestHandler() *handler.Handler {
 		Expiration: 3600,
 		Issuer:     "test",
 	})
-	authSvc := service.NewAuthService(repo, jwtSvc, 3600)
-	return handler.New(svc, authSvc)
+	authSvc := service.NewAuthService(repo, jwtSvc, time.Hour, 24*time.Hour)
+	orgSvc := service.NewOrgService(repo, authSvc, jwtSvc, time.Hour)
+	return handler.New(svc, authSvc, orgSvc, handler.Options{})
 }
 
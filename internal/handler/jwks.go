@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+)
+
+// JWKS godoc
+// @Summary      Token verification keys
+// @Description  Returns the public keys access tokens are verified with as a JSON Web Key Set,
+// @Description  without the usual response envelope. A token's kid header names its key. The set
+// @Description  is empty when tokens are signed with a shared secret.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  jwt.JWKSet
+// @Router       /.well-known/jwks.json [get]
+func JWKS(set jwt.JWKSet) http.HandlerFunc {
+	body, _ := json.Marshal(set)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Write(body)
+	}
+}
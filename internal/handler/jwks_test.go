@@ -0,0 +1,48 @@
+package handler_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+)
+
+func TestJWKS(t *testing.T) {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	key, err := jwt.NewKey(private)
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	jwtSvc := jwt.NewService(jwt.Config{Expiration: time.Hour, Issuer: "test", SigningKey: key})
+
+	rec := httptest.NewRecorder()
+	handler.JWKS(jwtSvc.JWKS())(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected a JSON 200, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := map[string]string{"kty": "OKP", "crv": "Ed25519", "alg": "EdDSA", "use": "sig", "kid": key.ID}
+	if len(set.Keys) != 1 {
+		t.Fatalf("expected one key, got %+v", set.Keys)
+	}
+	for k, v := range want {
+		if set.Keys[0][k] != v {
+			t.Errorf("expected %s %q, got %q", k, v, set.Keys[0][k])
+		}
+	}
+	if set.Keys[0]["x"] == "" || set.Keys[0]["d"] != "" {
+		t.Errorf("expected only the public key, got %+v", set.Keys[0])
+	}
+}
@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+)
+
+// --- Request/Response Types ---
+
+type CreateOrganizationRequest struct {
+	Name  string          `json:"name" example:"Acme"`
+	Owner RegisterRequest `json:"owner"`
+}
+
+// OrganizationResponse is a new organization plus a token for its owner.
+type OrganizationResponse struct {
+	Organization *repository.Organization `json:"organization"`
+	AuthResponse
+}
+
+type InviteRequest struct {
+	Email string `json:"email" example:"colleague@example.com"`
+}
+
+type InvitationResponse struct {
+	Token          string    `json:"token"`
+	OrganizationID string    `json:"organization_id"`
+	Email          string    `json:"email"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+type AcceptInviteRequest struct {
+	Token    string `json:"token"`
+	Name     string `json:"name" example:"John Doe"`
+	Password string `json:"password" example:"secret123"`
+}
+
+// --- Handlers ---
+
+// CreateOrganization godoc
+// @Summary      Create an organization
+// @Description  Creates an organization and its first member, and signs that member in
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Param        request  body      CreateOrganizationRequest  true  "Organization and owner details"
+// @Success      201      {object}  OrganizationResponse
+// @Failure      400      {object}  response.Response
+// @Failure      500      {object}  response.Response
+// @Router       /orgs [post]
+func (h *Handler) CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var req CreateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, "invalid request body")
+		return
+	}
+
+	if req.Name == "" {
+		BadRequest(w, "name is required")
+		return
+	}
+	if msg := checkRegistration(req.Owner); msg != "" {
+		BadRequest(w, "owner: "+msg)
+		return
+	}
+
+	org, result, err := h.orgSvc.CreateOrganization(r.Context(), req.Name, req.Owner.Name, req.Owner.Email, req.Owner.Password)
+	if err != nil {
+		slog.Error("create organization failed", "error", err, "name", req.Name)
+		InternalError(w)
+		return
+	}
+
+	Created(w, OrganizationResponse{Organization: org, AuthResponse: toAuthResponse(result)})
+}
+
+// GetOrganization godoc
+// @Summary      Get organization
+// @Description  Returns the caller's organization
+// @Tags         organizations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "Organization ID"
+// @Success      200  {object}  repository.Organization
+// @Failure      400  {object}  response.Response
+// @Failure      401  {object}  response.Response
+// @Failure      404  {object}  response.Response
+// @Failure      500  {object}  response.Response
+// @Router       /orgs/{id} [get]
+func (h *Handler) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	org, err := h.orgSvc.GetOrganization(r.Context(), id)
+	if err != nil {
+		if err == service.ErrNotFound {
+			NotFound(w, "organization not found")
+			return
+		}
+		slog.Error("get organization failed", "error", err, "id", id)
+		InternalError(w)
+		return
+	}
+	OK(w, org)
+}
+
+// InviteMember godoc
+// @Summary      Invite a member
+// @Description  Issues an invitation token for email to join the caller's organization.
+// @Description  Deliver it to the invitee, who redeems it at /invitations/accept.
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id       path      string         true  "Organization ID"
+// @Param        request  body      InviteRequest  true  "Invitee"
+// @Success      201      {object}  InvitationResponse
+// @Failure      400      {object}  response.Response
+// @Failure      401      {object}  response.Response
+// @Failure      403      {object}  response.Response
+// @Failure      404      {object}  response.Response
+// @Failure      409      {object}  response.Response
+// @Failure      500      {object}  response.Response
+// @Router       /orgs/{id}/invitations [post]
+func (h *Handler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, "invalid request body")
+		return
+	}
+	if req.Email == "" {
+		BadRequest(w, "email is required")
+		return
+	}
+
+	inv, err := h.orgSvc.Invite(r.Context(), id, req.Email)
+	if err != nil {
+		switch err {
+		case service.ErrNotFound:
+			NotFound(w, "organization not found")
+		case service.ErrConflict:
+			Conflict(w, "already a member")
+		default:
+			slog.Error("invite member failed", "error", err, "id", id)
+			InternalError(w)
+		}
+		return
+	}
+
+	Created(w, InvitationResponse{
+		Token:          inv.Token,
+		OrganizationID: inv.OrganizationID,
+		Email:          inv.Email,
+		ExpiresAt:      inv.ExpiresAt,
+	})
+}
+
+// AcceptInvite godoc
+// @Summary      Accept an invitation
+// @Description  Creates the invited account in the inviting organization and signs it in
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Param        request  body      AcceptInviteRequest  true  "Invitation token and account details"
+// @Success      201      {object}  AuthResponse
+// @Failure      400      {object}  response.Response
+// @Failure      409      {object}  response.Response
+// @Failure      500      {object}  response.Response
+// @Router       /invitations/accept [post]
+func (h *Handler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	var req AcceptInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, "invalid request body")
+		return
+	}
+
+	if req.Token == "" || req.Name == "" || req.Password == "" {
+		BadRequest(w, "token, name and password are required")
+		return
+	}
+	if len(req.Password) < 6 {
+		BadRequest(w, "password must be at least 6 characters")
+		return
+	}
+
+	result, err := h.orgSvc.AcceptInvite(r.Context(), req.Token, req.Name, req.Password)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidInvite:
+			BadRequest(w, "invalid or expired invitation")
+		case service.ErrConflict:
+			Conflict(w, "email already registered")
+		default:
+			slog.Error("accept invite failed", "error", err)
+			InternalError(w)
+		}
+		return
+	}
+
+	Created(w, toAuthResponse(result))
+}
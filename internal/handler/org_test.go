@@ -0,0 +1,116 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+)
+
+func TestOrganizationsIsolateTenants(t *testing.T) {
+	repo := repository.New()
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test"})
+	authSvc := service.NewAuthService(repo, jwtSvc, time.Hour, 24*time.Hour)
+	h := handler.New(service.New(repo), authSvc, service.NewOrgService(repo, authSvc, jwtSvc, time.Hour), handler.Options{})
+
+	r := chi.NewRouter()
+	r.Post("/auth/login", h.Login)
+	r.Post("/orgs", h.CreateOrganization)
+	r.Post("/invitations/accept", h.AcceptInvite)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Auth(jwtSvc, authSvc))
+		r.Get("/users", h.ListUsers)
+		r.Get("/users/{id}", h.GetUser)
+		r.Get("/orgs/{id}", h.GetOrganization)
+		r.Post("/orgs/{id}/invitations", h.InviteMember)
+	})
+
+	do := func(method, path, token, body string, out any) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		if out != nil && rec.Code < 300 {
+			envelope := struct{ Data any }{Data: out}
+			if err := json.NewDecoder(rec.Body).Decode(&envelope); err != nil {
+				t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+			}
+		}
+		return rec.Code
+	}
+
+	// Both organizations' owners use the same email: it is unique per tenant.
+	var acme, globex handler.OrganizationResponse
+	owner := `"owner":{"name":"Owner","email":"owner@example.com","password":"secret123"}`
+	if code := do(http.MethodPost, "/orgs", "", `{"name":"Acme",`+owner+`}`, &acme); code != http.StatusCreated {
+		t.Fatalf("create Acme: expected %d, got %d", http.StatusCreated, code)
+	}
+	if code := do(http.MethodPost, "/orgs", "", `{"name":"Globex",`+owner+`}`, &globex); code != http.StatusCreated {
+		t.Fatalf("create Globex: expected %d, got %d", http.StatusCreated, code)
+	}
+	if acme.User.OrganizationID != acme.Organization.ID {
+		t.Errorf("expected the owner to belong to the new organization, got %+v", acme.User)
+	}
+
+	var inv handler.InvitationResponse
+	path := "/orgs/" + acme.Organization.ID + "/invitations"
+	if code := do(http.MethodPost, path, acme.Token, `{"email":"bob@example.com"}`, &inv); code != http.StatusCreated {
+		t.Fatalf("invite: expected %d, got %d", http.StatusCreated, code)
+	}
+	if code := do(http.MethodPost, path, globex.Token, `{"email":"eve@example.com"}`, nil); code != http.StatusNotFound {
+		t.Errorf("invite into another organization: expected %d, got %d", http.StatusNotFound, code)
+	}
+	if code := do(http.MethodPost, path, acme.Token, `{"email":"owner@example.com"}`, nil); code != http.StatusConflict {
+		t.Errorf("invite an existing member: expected %d, got %d", http.StatusConflict, code)
+	}
+
+	var bob handler.AuthResponse
+	accept := `{"token":"` + inv.Token + `","name":"Bob","password":"secret123"}`
+	if code := do(http.MethodPost, "/invitations/accept", "", accept, &bob); code != http.StatusCreated {
+		t.Fatalf("accept: expected %d, got %d", http.StatusCreated, code)
+	}
+	if bob.User.Email != "bob@example.com" || bob.User.OrganizationID != acme.Organization.ID {
+		t.Errorf("expected Bob to join Acme with the invited email, got %+v", bob.User)
+	}
+	if code := do(http.MethodPost, "/invitations/accept", "", `{"token":"bogus","name":"X","password":"secret123"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("accept a bogus invite: expected %d, got %d", http.StatusBadRequest, code)
+	}
+
+	login := `{"email":"bob@example.com","password":"secret123"`
+	if code := do(http.MethodPost, "/auth/login", "", login+`,"organization_id":"`+acme.Organization.ID+`"}`, nil); code != http.StatusOK {
+		t.Errorf("login to Acme: expected %d, got %d", http.StatusOK, code)
+	}
+	if code := do(http.MethodPost, "/auth/login", "", login+`}`, nil); code != http.StatusUnauthorized {
+		t.Errorf("login without an organization: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	var users []repository.User
+	if code := do(http.MethodGet, "/users", acme.Token, "", &users); code != http.StatusOK {
+		t.Fatalf("list users: expected %d, got %d", http.StatusOK, code)
+	}
+	if len(users) != 2 {
+		t.Errorf("expected Acme's owner and Bob only, got %+v", users)
+	}
+	if code := do(http.MethodGet, "/users/"+bob.User.ID, globex.Token, "", nil); code != http.StatusNotFound {
+		t.Errorf("read another organization's user: expected %d, got %d", http.StatusNotFound, code)
+	}
+	if code := do(http.MethodGet, "/orgs/"+acme.Organization.ID, globex.Token, "", nil); code != http.StatusNotFound {
+		t.Errorf("read another organization: expected %d, got %d", http.StatusNotFound, code)
+	}
+	if code := do(http.MethodGet, "/orgs/"+acme.Organization.ID, bob.Token, "", nil); code != http.StatusOK {
+		t.Errorf("read own organization: expected %d, got %d", http.StatusOK, code)
+	}
+}
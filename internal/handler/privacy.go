@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+)
+
+// --- Request/Response Types ---
+
+// PersonalDataExport is the archive returned by /me/export.
+type PersonalDataExport struct {
+	ExportedAt   time.Time                `json:"exported_at"`
+	Profile      *repository.User         `json:"profile"`
+	Organization *repository.Organization `json:"organization,omitempty"`
+	AuditLog     []repository.AuditEntry  `json:"audit_log"`
+	Sessions     []SessionExport          `json:"sessions"`
+}
+
+// SessionExport is one refresh token of the user. Tokens of the same login
+// share a family; the token hash is left out.
+type SessionExport struct {
+	ID        string     `json:"id"`
+	FamilyID  string     `json:"family_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+type EraseRequest struct {
+	// Password confirms the request.
+	Password string `json:"password" example:"secret123"`
+}
+
+type ErasureResponse struct {
+	EraseAt time.Time `json:"erase_at"`
+}
+
+// --- Handlers ---
+
+// ExportMe godoc
+// @Summary      Export my data
+// @Description  Downloads everything held about the current user as a JSON document:
+// @Description  profile, organization, audit log entries and sessions
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  PersonalDataExport
+// @Failure      401  {object}  response.Response
+// @Failure      404  {object}  response.Response
+// @Failure      500  {object}  response.Response
+// @Router       /me/export [get]
+func (h *Handler) ExportMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		Unauthorized(w, "user not found in context")
+		return
+	}
+
+	data, err := h.svc.ExportPersonalData(r.Context(), userID)
+	if err != nil {
+		if err == service.ErrUserNotFound {
+			NotFound(w, "user not found")
+			return
+		}
+		slog.Error("export personal data failed", "error", err, "user_id", userID)
+		InternalError(w)
+		return
+	}
+
+	audit := data.Audit
+	if audit == nil {
+		audit = []repository.AuditEntry{}
+	}
+	sessions := make([]SessionExport, 0, len(data.Sessions))
+	for _, rt := range data.Sessions {
+		sessions = append(sessions, SessionExport{
+			ID:        rt.ID,
+			FamilyID:  rt.FamilyID,
+			CreatedAt: rt.CreatedAt,
+			ExpiresAt: rt.ExpiresAt,
+			UsedAt:    rt.UsedAt,
+			RevokedAt: rt.RevokedAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="personal-data.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(PersonalDataExport{
+		ExportedAt:   data.ExportedAt,
+		Profile:      data.Profile,
+		Organization: data.Organization,
+		AuditLog:     audit,
+		Sessions:     sessions,
+	})
+}
+
+// RequestErasure godoc
+// @Summary      Erase my account
+// @Description  Schedules the current user's personal data for irreversible erasure, confirmed
+// @Description  by their password. The account keeps working until erase_at and the erasure can
+// @Description  be cancelled until then with DELETE /me/erasure.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        request  body      EraseRequest  true  "Confirmation"
+// @Success      202      {object}  ErasureResponse
+// @Failure      400      {object}  response.Response
+// @Failure      401      {object}  response.Response
+// @Failure      403      {object}  response.Response
+// @Failure      404      {object}  response.Response
+// @Failure      500      {object}  response.Response
+// @Router       /me [delete]
+func (h *Handler) RequestErasure(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		Unauthorized(w, "user not found in context")
+		return
+	}
+
+	var req EraseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		BadRequest(w, "invalid request body")
+		return
+	}
+	if req.Password == "" {
+		BadRequest(w, "password is required to confirm the erasure")
+		return
+	}
+
+	user, err := h.svc.RequestErasure(r.Context(), userID, req.Password, h.opts.ErasureGracePeriod)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidCredentials:
+			Forbidden(w, "password does not match")
+		case service.ErrUserNotFound:
+			NotFound(w, "user not found")
+		default:
+			slog.Error("request erasure failed", "error", err, "user_id", userID)
+			InternalError(w)
+		}
+		return
+	}
+
+	JSON(w, http.StatusAccepted, ErasureResponse{EraseAt: *user.EraseAt})
+}
+
+// CancelErasure godoc
+// @Summary      Cancel my account's erasure
+// @Description  Calls off a pending erasure of the current user
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  UserResponse
+// @Failure      401  {object}  response.Response
+// @Failure      404  {object}  response.Response
+// @Failure      500  {object}  response.Response
+// @Router       /me/erasure [delete]
+func (h *Handler) CancelErasure(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		Unauthorized(w, "user not found in context")
+		return
+	}
+
+	user, err := h.svc.CancelErasure(r.Context(), userID)
+	if err != nil {
+		if err == service.ErrNotFound {
+			NotFound(w, "no erasure is pending")
+			return
+		}
+		slog.Error("cancel erasure failed", "error", err, "user_id", userID)
+		InternalError(w)
+		return
+	}
+
+	OK(w, toUserResponse(user))
+}
@@ -0,0 +1,130 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+)
+
+func TestExportAndEraseMe(t *testing.T) {
+	repo := repository.New()
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test"})
+	svc := service.New(repo)
+	authSvc := service.NewAuthService(repo, jwtSvc, time.Hour, 24*time.Hour)
+	h := handler.New(svc, authSvc, nil, handler.Options{ErasureGracePeriod: 24 * time.Hour})
+
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Auth(jwtSvc, authSvc))
+		r.Get("/me", h.Me)
+		r.Delete("/me", h.RequestErasure)
+		r.Get("/me/export", h.ExportMe)
+		r.Delete("/me/erasure", h.CancelErasure)
+	})
+
+	ctx := context.Background()
+	ann, err := authSvc.Register(ctx, "Ann", "ann@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+ann.Token)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/me/export", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: expected %d, got %d", http.StatusOK, rec.Code)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("expected the export to be a download, got %q", cd)
+	}
+	var archive handler.PersonalDataExport
+	if err := json.NewDecoder(rec.Body).Decode(&archive); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+	if archive.Profile == nil || archive.Profile.Email != "ann@example.com" {
+		t.Errorf("expected Ann's profile, got %+v", archive.Profile)
+	}
+	if len(archive.AuditLog) != 1 || archive.AuditLog[0].Action != audit.ActionUserRegister {
+		t.Errorf("expected Ann's registration in the audit log, got %+v", archive.AuditLog)
+	}
+	if len(archive.Sessions) != 1 || archive.Sessions[0].FamilyID == "" {
+		t.Errorf("expected Ann's session, got %+v", archive.Sessions)
+	}
+
+	if rec := do(http.MethodDelete, "/me", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("erase without confirmation: expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/me", `{"password":"wrong"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("erase with a wrong password: expected %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = do(http.MethodDelete, "/me", `{"password":"secret123"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("erase: expected %d, got %d", http.StatusAccepted, rec.Code)
+	}
+	var scheduled struct{ Data handler.ErasureResponse }
+	if err := json.NewDecoder(rec.Body).Decode(&scheduled); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if d := time.Until(scheduled.Data.EraseAt); d < 23*time.Hour || d > 24*time.Hour {
+		t.Errorf("expected erasure after the grace period, got %v", scheduled.Data.EraseAt)
+	}
+
+	var me struct{ Data handler.UserResponse }
+	if err := json.NewDecoder(do(http.MethodGet, "/me", "").Body).Decode(&me); err != nil {
+		t.Fatalf("failed to decode /me: %v", err)
+	}
+	if me.Data.EraseAt == nil || !me.Data.EraseAt.Equal(scheduled.Data.EraseAt) {
+		t.Errorf("expected /me to show the pending erasure, got %+v", me.Data)
+	}
+
+	if rec := do(http.MethodDelete, "/me/erasure", ""); rec.Code != http.StatusOK {
+		t.Errorf("cancel: expected %d, got %d", http.StatusOK, rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/me/erasure", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("cancel with nothing pending: expected %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if n, err := svc.EraseDueUsers(ctx); err != nil || n != 0 {
+		t.Fatalf("expected a cancelled erasure not to run, got %d, %v", n, err)
+	}
+
+	// Skip the grace period and let the purge job's erasure step run.
+	if _, err := svc.RequestErasure(ctx, ann.User.ID, "secret123", -time.Minute); err != nil {
+		t.Fatalf("RequestErasure: %v", err)
+	}
+	if n, err := svc.EraseDueUsers(ctx); err != nil || n != 1 {
+		t.Fatalf("expected Ann to be erased, got %d, %v", n, err)
+	}
+	if rec := do(http.MethodGet, "/me", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("/me after erasure: expected %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if _, err := authSvc.Login(ctx, "ann@example.com", "secret123"); err != service.ErrInvalidCredentials {
+		t.Errorf("expected an erased user to be unable to log in, got %v", err)
+	}
+	entries, _, err := repo.ListAudit(ctx, repository.AuditFilter{TargetID: ann.User.ID, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != audit.ActionUserErase || len(entries[0].Changes) != 0 {
+		t.Errorf("expected an erasure entry without personal data, got %+v", entries)
+	}
+}
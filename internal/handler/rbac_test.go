@@ -0,0 +1,156 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/server"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+)
+
+func TestRolesAndPermissions(t *testing.T) {
+	repo := repository.New()
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test"})
+	authSvc := service.NewAuthService(repo, jwtSvc, time.Hour, 24*time.Hour)
+	h := handler.New(service.New(repo), authSvc, nil, handler.Options{})
+	r := chi.NewRouter()
+	server.RegisterRoutes(r, h, jwtSvc, authSvc)
+
+	do := func(method, path, token, body string) (int, json.RawMessage) {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/v1"+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		var env struct {
+			Data json.RawMessage `json:"data"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &env)
+		return rec.Code, env.Data
+	}
+	register := func(name, email string) handler.AuthResponse {
+		t.Helper()
+		code, data := do(http.MethodPost, "/auth/register", "", `{"name":"`+name+`","email":"`+email+`","password":"secret123"}`)
+		if code != http.StatusCreated {
+			t.Fatalf("register %s: expected %d, got %d", email, http.StatusCreated, code)
+		}
+		var res handler.AuthResponse
+		json.Unmarshal(data, &res)
+		return res
+	}
+
+	// The first user of the tenant becomes its admin.
+	ann := register("Ann", "ann@example.com")
+	bob := register("Bob", "bob@example.com")
+	if !slices.Equal(ann.User.Roles, []string{"admin"}) || !slices.Equal(bob.User.Roles, []string{"member"}) {
+		t.Fatalf("expected an admin and a member, got %v and %v", ann.User.Roles, bob.User.Roles)
+	}
+	if claims, _ := jwtSvc.ValidateToken(bob.Token); !slices.Equal(claims.Roles, []string{"member"}) {
+		t.Errorf("expected the roles in the token, got %v", claims.Roles)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodGet, "/users", "", http.StatusOK},
+		{http.MethodGet, "/users/" + ann.User.ID, "", http.StatusOK},
+		{http.MethodPost, "/users", `{"name":"Cy","email":"cy@example.com"}`, http.StatusForbidden},
+		{http.MethodPut, "/users/" + ann.User.ID, `{"name":"Anna"}`, http.StatusForbidden},
+		{http.MethodDelete, "/users/" + ann.User.ID, "", http.StatusForbidden},
+		{http.MethodPost, "/users/" + ann.User.ID + "/restore", "", http.StatusForbidden},
+		{http.MethodGet, "/audit", "", http.StatusForbidden},
+		{http.MethodGet, "/admin/cache", "", http.StatusForbidden},
+		{http.MethodPut, "/users/" + bob.User.ID, `{"roles":["admin"]}`, http.StatusForbidden},
+		{http.MethodPut, "/users/" + bob.User.ID, `{"name":"Robert"}`, http.StatusOK},
+	} {
+		if code, _ := do(tc.method, tc.path, bob.Token, tc.body); code != tc.want {
+			t.Errorf("member %s %s: expected %d, got %d", tc.method, tc.path, tc.want, code)
+		}
+	}
+
+	if code, _ := do(http.MethodGet, "/audit", ann.Token, ""); code != http.StatusOK {
+		t.Errorf("admin GET /audit: expected %d, got %d", http.StatusOK, code)
+	}
+	if code, _ := do(http.MethodPut, "/users/"+bob.User.ID, ann.Token, `{"roles":["owner"]}`); code != http.StatusBadRequest {
+		t.Errorf("unknown role: expected %d, got %d", http.StatusBadRequest, code)
+	}
+	code, data := do(http.MethodPut, "/users/"+bob.User.ID, ann.Token, `{"roles":["admin","admin"]}`)
+	var promoted repository.User
+	json.Unmarshal(data, &promoted)
+	if code != http.StatusOK || !slices.Equal(promoted.Roles, []string{"admin"}) {
+		t.Fatalf("promote: expected %d with roles [admin], got %d %v", http.StatusOK, code, promoted.Roles)
+	}
+
+	// A token with outdated roles is refused until the client refreshes it.
+	if code, _ := do(http.MethodGet, "/users", bob.Token, ""); code != http.StatusUnauthorized {
+		t.Errorf("token with outdated roles: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	code, data = do(http.MethodPost, "/auth/refresh", "", `{"refresh_token":"`+bob.RefreshToken+`"}`)
+	var refreshed handler.AuthResponse
+	json.Unmarshal(data, &refreshed)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: expected %d, got %d", http.StatusOK, code)
+	}
+	if code, _ := do(http.MethodGet, "/audit", refreshed.Token, ""); code != http.StatusOK {
+		t.Errorf("promoted GET /audit: expected %d, got %d", http.StatusOK, code)
+	}
+
+	// A deleted admin's token stops working at once, not when it expires.
+	if code, _ := do(http.MethodDelete, "/users/"+ann.User.ID, refreshed.Token, ""); code != http.StatusNoContent {
+		t.Fatalf("delete admin: expected %d, got %d", http.StatusNoContent, code)
+	}
+	for _, path := range []string{"/users", "/audit", "/me"} {
+		if code, _ := do(http.MethodGet, path, ann.Token, ""); code != http.StatusUnauthorized {
+			t.Errorf("deleted admin GET %s: expected %d, got %d", path, http.StatusUnauthorized, code)
+		}
+	}
+	if code, _ := do(http.MethodPost, "/auth/refresh", "", `{"refresh_token":"`+ann.RefreshToken+`"}`); code != http.StatusUnauthorized {
+		t.Errorf("deleted admin refresh: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestAdminEmailsGrantAdminAtLogin(t *testing.T) {
+	repo := repository.New()
+	jwtSvc := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test"})
+	authSvc := service.NewAuthService(repo, jwtSvc, time.Hour, 24*time.Hour)
+	authSvc.SetAdminEmails([]string{" Bob@Example.com "})
+
+	ctx := context.Background()
+	if _, err := authSvc.Register(ctx, "Ann", "ann@example.com", "secret123"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	bob, err := authSvc.Register(ctx, "Bob", "bob@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if !slices.Equal(bob.User.Roles, []string{"member"}) {
+		t.Fatalf("expected Bob to register as a member, got %v", bob.User.Roles)
+	}
+
+	res, err := authSvc.Login(ctx, "bob@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if !slices.Equal(res.User.Roles, []string{"member", "admin"}) {
+		t.Errorf("expected the login to grant admin, got %v", res.User.Roles)
+	}
+	entries, _, _ := repo.ListAudit(ctx, repository.AuditFilter{TargetID: bob.User.ID})
+	if len(entries) == 0 || entries[0].Action != audit.ActionAdminGrant {
+		t.Errorf("expected an admin_grant audit entry, got %+v", entries)
+	}
+}
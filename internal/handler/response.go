@@ -41,6 +41,14 @@ func Conflict(w http.ResponseWriter, msg string) {
 	response.Conflict(w, msg)
 }
 
+func PreconditionFailed(w http.ResponseWriter, msg string) {
+	response.PreconditionFailed(w, msg)
+}
+
+func PreconditionRequired(w http.ResponseWriter, msg string) {
+	response.PreconditionRequired(w, msg)
+}
+
 func InternalError(w http.ResponseWriter) {
 	response.InternalError(w)
 }
@@ -2,46 +2,95 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/muflihunaf/boilerplate-go/internal/httputil"
+	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/rbac"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
 	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/response"
 )
 
 // --- Request Types ---
 
 type CreateUserRequest struct {
-	Name  string `json:"name" example:"John Doe"`
-	Email string `json:"email" example:"user@example.com"`
+	Name     string         `json:"name" example:"John Doe"`
+	Email    string         `json:"email" example:"user@example.com"`
+	Metadata map[string]any `json:"metadata,omitempty"`
 }
 
 type UpdateUserRequest struct {
 	Name  string `json:"name" example:"John Doe"`
 	Email string `json:"email" example:"user@example.com"`
+	// Metadata replaces the user's metadata when present; {} clears it.
+	Metadata map[string]any `json:"metadata,omitempty"`
+	// Roles replaces the user's roles when present; [] removes them, which
+	// leaves a member. Changing roles requires the roles:assign permission.
+	Roles []string `json:"roles,omitempty" example:"member"`
 }
 
 // --- Handlers ---
 
 // ListUsers godoc
-// @Summary      List all users
-// @Description  Returns a list of all users
+// @Summary      List users
+// @Description  Returns a page of users. Use page/per_page for numbered pages or cursor for keyset pagination;
+// @Description  next/prev page URLs are also returned in the Link header.
 // @Tags         users
 // @Accept       json
 // @Produce      json
 // @Security     BearerAuth
+// @Param        page            query     int     false  "Page number (1-based)"
+// @Param        per_page        query     int     false  "Page size (max 100)"
+// @Param        cursor          query     string  false  "Opaque cursor from meta.next_cursor or meta.prev_cursor"
+// @Param        sort            query     string  false  "created_at, name or email; prefix with - for descending"
+// @Param        email_domain    query     string  false  "Only users whose email is at this domain"
+// @Param        name            query     string  false  "Case-insensitive name substring"
+// @Param        created_after   query     string  false  "RFC 3339 timestamp, inclusive"
+// @Param        created_before  query     string  false  "RFC 3339 timestamp, exclusive"
+// @Param        include_deleted query     bool    false  "Include soft-deleted users"
+// @Param        metadata.{key}  query     string  false  "Only users whose metadata key equals this value (repeatable for several keys)"
 // @Success      200  {array}   UserResponse
+// @Failure      400  {object}  response.Response
 // @Failure      401  {object}  response.Response
+// @Failure      403  {object}  response.Response
 // @Failure      500  {object}  response.Response
 // @Router       /users [get]
 func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.svc.ListUsers(r.Context())
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := h.svc.ListUsers(r.Context(), opts)
 	if err != nil {
+		if err == service.ErrInvalidInput {
+			BadRequest(w, "invalid cursor or sort")
+			return
+		}
+		if err == service.ErrEncryptedField {
+			BadRequest(w, err.Error())
+			return
+		}
 		slog.Error("list users failed", "error", err)
 		InternalError(w)
 		return
 	}
-	OK(w, users)
+
+	meta := response.NewMeta(page.Page, page.PerPage, page.Total)
+	meta.NextCursor = page.NextCursor
+	meta.PrevCursor = page.PrevCursor
+
+	response.SetLinks(w, httputil.PageLinks(r.URL, opts.Cursor != "", meta)...)
+	response.WithMeta(w, page.Users, meta)
 }
 
 // GetUser godoc
@@ -53,6 +102,8 @@ func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
 // @Security     BearerAuth
 // @Param        id   path      string  true  "User ID"
 // @Success      200  {object}  UserResponse
+// @Failure      400  {object}  response.Response
+// @Failure      403  {object}  response.Response
 // @Failure      404  {object}  response.Response
 // @Failure      500  {object}  response.Response
 // @Router       /users/{id} [get]
@@ -73,6 +124,7 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 		InternalError(w)
 		return
 	}
+	setETag(w, user)
 	OK(w, user)
 }
 
@@ -86,6 +138,9 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 // @Param        request  body      CreateUserRequest  true  "User details"
 // @Success      201      {object}  UserResponse
 // @Failure      400      {object}  response.Response
+// @Failure      403      {object}  response.Response
+// @Failure      409      {object}  response.Response
+// @Failure      422      {object}  response.Response  "Metadata does not match the schema"
 // @Failure      500      {object}  response.Response
 // @Router       /users [post]
 func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -95,28 +150,43 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.svc.CreateUser(r.Context(), req.Name, req.Email)
+	user, err := h.svc.CreateUser(r.Context(), req.Name, req.Email, req.Metadata)
 	if err != nil {
+		if err == service.ErrConflict {
+			Conflict(w, "email already registered")
+			return
+		}
+		if metadataInvalid(w, err) {
+			return
+		}
 		slog.Error("create user failed", "error", err, "email", req.Email)
 		InternalError(w)
 		return
 	}
+	setETag(w, user)
 	Created(w, user)
 }
 
 // UpdateUser godoc
 // @Summary      Update a user
-// @Description  Updates an existing user by ID
+// @Description  Updates an existing user by ID. Without the users:write permission users can only update
+// @Description  themselves, and changing roles requires roles:assign.
 // @Tags         users
 // @Accept       json
 // @Produce      json
 // @Security     BearerAuth
-// @Param        id       path      string             true  "User ID"
-// @Param        request  body      UpdateUserRequest  true  "User details"
-// @Success      200      {object}  UserResponse
-// @Failure      400      {object}  response.Response
-// @Failure      404      {object}  response.Response
-// @Failure      500      {object}  response.Response
+// @Param        id        path      string             true   "User ID"
+// @Param        If-Match  header    string             false  "ETag from a previous read; the update fails with 412 if the user changed since"
+// @Param        request   body      UpdateUserRequest  true   "User details"
+// @Success      200       {object}  UserResponse
+// @Failure      400       {object}  response.Response
+// @Failure      403       {object}  response.Response
+// @Failure      404       {object}  response.Response
+// @Failure      409       {object}  response.Response
+// @Failure      412       {object}  response.Response
+// @Failure      422       {object}  response.Response  "Metadata does not match the schema"
+// @Failure      428       {object}  response.Response
+// @Failure      500       {object}  response.Response
 // @Router       /users/{id} [put]
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -125,36 +195,73 @@ func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ifVersion, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var req UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		BadRequest(w, "invalid request body")
 		return
 	}
+	if req.Roles != nil && !middleware.Can(r.Context(), rbac.RolesAssign) {
+		Forbidden(w, "not allowed to change roles")
+		return
+	}
 
-	user, err := h.svc.UpdateUser(r.Context(), id, req.Name, req.Email)
+	user, err := h.svc.UpdateUser(r.Context(), id, repository.UserUpdate{
+		Name:      req.Name,
+		Email:     req.Email,
+		Metadata:  req.Metadata,
+		Roles:     req.Roles,
+		IfVersion: ifVersion,
+	})
 	if err != nil {
 		if err == service.ErrNotFound {
 			NotFound(w, "user not found")
 			return
 		}
+		if err == service.ErrInvalidRole {
+			BadRequest(w, err.Error())
+			return
+		}
+		if err == service.ErrConflict {
+			Conflict(w, "email already registered")
+			return
+		}
+		if err == service.ErrPreconditionFailed {
+			PreconditionFailed(w, "user was modified since it was read")
+			return
+		}
+		if metadataInvalid(w, err) {
+			return
+		}
 		slog.Error("update user failed", "error", err, "id", id)
 		InternalError(w)
 		return
 	}
+	setETag(w, user)
 	OK(w, user)
 }
 
 // DeleteUser godoc
 // @Summary      Delete a user
-// @Description  Deletes a user by ID
+// @Description  Soft-deletes a user by ID. The user can be restored until the retention window expires.
+// @Description  Without the users:write permission users can only delete themselves.
 // @Tags         users
 // @Accept       json
 // @Produce      json
 // @Security     BearerAuth
-// @Param        id   path      string  true  "User ID"
-// @Success      204  "No Content"
-// @Failure      404  {object}  response.Response
-// @Failure      500  {object}  response.Response
+// @Param        id        path      string  true   "User ID"
+// @Param        If-Match  header    string  false  "ETag from a previous read; the delete fails with 412 if the user changed since"
+// @Success      204       "No Content"
+// @Failure      400       {object}  response.Response
+// @Failure      403       {object}  response.Response
+// @Failure      404       {object}  response.Response
+// @Failure      412       {object}  response.Response
+// @Failure      428       {object}  response.Response
+// @Failure      500       {object}  response.Response
 // @Router       /users/{id} [delete]
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -163,14 +270,150 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.svc.DeleteUser(r.Context(), id); err != nil {
+	ifVersion, ok := h.ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.svc.DeleteUser(r.Context(), id, ifVersion); err != nil {
 		if err == service.ErrNotFound {
 			NotFound(w, "user not found")
 			return
 		}
+		if err == service.ErrPreconditionFailed {
+			PreconditionFailed(w, "user was modified since it was read")
+			return
+		}
 		slog.Error("delete user failed", "error", err, "id", id)
 		InternalError(w)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// RestoreUser godoc
+// @Summary      Restore a deleted user
+// @Description  Undoes a soft delete, as long as the user has not been purged yet
+// @Tags         users
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  UserResponse
+// @Failure      400  {object}  response.Response
+// @Failure      403  {object}  response.Response
+// @Failure      404  {object}  response.Response
+// @Failure      500  {object}  response.Response
+// @Router       /users/{id}/restore [post]
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		BadRequest(w, "id is required")
+		return
+	}
+
+	user, err := h.svc.RestoreUser(r.Context(), id)
+	if err != nil {
+		if err == service.ErrNotFound {
+			NotFound(w, "user not found")
+			return
+		}
+		slog.Error("restore user failed", "error", err, "id", id)
+		InternalError(w)
+		return
+	}
+	setETag(w, user)
+	OK(w, user)
+}
+
+// --- Helpers ---
+
+// setETag exposes the user's version as a strong entity tag.
+func setETag(w http.ResponseWriter, u *repository.User) {
+	httputil.SetETag(w, u.Version)
+}
+
+// metadataInvalid writes the response for metadata the service rejected and
+// reports whether err was such a rejection.
+func metadataInvalid(w http.ResponseWriter, err error) bool {
+	var verr *service.ValidationError
+	if errors.As(err, &verr) {
+		response.ValidationError(w, "metadata does not match the schema", verr.Fields)
+		return true
+	}
+	if err == service.ErrInvalidInput {
+		BadRequest(w, "invalid metadata")
+		return true
+	}
+	return false
+}
+
+// ifMatch reads the If-Match precondition as an expected version, where 0
+// means "any". It writes the error response and returns false when the
+// request cannot proceed.
+func (h *Handler) ifMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	return httputil.IfMatch(w, r, h.opts.RequireIfMatch)
+}
+
+func parseListOptions(q url.Values) (repository.ListOptions, error) {
+	opts := repository.ListOptions{
+		Cursor:       q.Get("cursor"),
+		EmailDomain:  q.Get("email_domain"),
+		NameContains: q.Get("name"),
+	}
+
+	var err error
+	if opts.Page, err = httputil.PositiveInt(q, "page"); err != nil {
+		return opts, err
+	}
+	if opts.PerPage, err = httputil.PositiveInt(q, "per_page"); err != nil {
+		return opts, err
+	}
+
+	if sort := q.Get("sort"); sort != "" {
+		opts.Sort, opts.Desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+		switch opts.Sort {
+		case repository.SortCreatedAt, repository.SortName, repository.SortEmail:
+		default:
+			return opts, errors.New("sort must be one of created_at, name, email")
+		}
+	}
+
+	if opts.CreatedAfter, err = timestamp(q, "created_after"); err != nil {
+		return opts, err
+	}
+	if opts.CreatedBefore, err = timestamp(q, "created_before"); err != nil {
+		return opts, err
+	}
+	if v := q.Get("include_deleted"); v != "" {
+		if opts.IncludeDeleted, err = strconv.ParseBool(v); err != nil {
+			return opts, errors.New("include_deleted must be true or false")
+		}
+	}
+	for param, values := range q {
+		key, ok := strings.CutPrefix(param, "metadata.")
+		if !ok {
+			continue
+		}
+		if !repository.ValidMetadataKey(key) {
+			return opts, errors.New("metadata keys must be 1-64 letters, digits, underscores or hyphens")
+		}
+		if opts.Metadata == nil {
+			opts.Metadata = make(map[string]string)
+		}
+		opts.Metadata[key] = values[0]
+	}
+	return opts, nil
+}
+
+func timestamp(q url.Values, key string) (time.Time, error) {
+	v := q.Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.New(key + " must be an RFC 3339 timestamp")
+	}
+	return t, nil
+}
@@ -0,0 +1,230 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/jsonschema"
+)
+
+func TestListUsersPagination(t *testing.T) {
+	repo := repository.New()
+	for i := 0; i < 5; i++ {
+		if _, err := repo.CreateUser(context.Background(), fmt.Sprintf("User %d", i), fmt.Sprintf("u%d@example.com", i)); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	h := handler.New(service.New(repo), nil, nil, handler.Options{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users?per_page=2&page=2&sort=-name", nil)
+	rec := httptest.NewRecorder()
+	h.ListUsers(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var resp struct {
+		Data []struct {
+			Name string `json:"name"`
+		} `json:"data"`
+		Meta struct {
+			Page       int   `json:"page"`
+			Total      int64 `json:"total"`
+			TotalPages int   `json:"total_pages"`
+		} `json:"meta"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	if len(resp.Data) != 2 || resp.Data[0].Name != "User 2" || resp.Data[1].Name != "User 1" {
+		t.Errorf("unexpected page contents: %+v", resp.Data)
+	}
+	if resp.Meta.Page != 2 || resp.Meta.Total != 5 || resp.Meta.TotalPages != 3 {
+		t.Errorf("unexpected meta: %+v", resp.Meta)
+	}
+
+	link := rec.Header().Get("Link")
+	for _, want := range []string{`page=3`, `rel="next"`, `page=1`, `rel="prev"`, `sort=-name`} {
+		if !strings.Contains(link, want) {
+			t.Errorf("expected Link header to contain %q, got %q", want, link)
+		}
+	}
+}
+
+func TestListUsersInvalidQuery(t *testing.T) {
+	h := newTestHandler()
+
+	for _, query := range []string{"page=0", "per_page=abc", "sort=password", "created_after=yesterday", "cursor=bogus", "metadata.a.b=x"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users?"+query, nil)
+		rec := httptest.NewRecorder()
+		h.ListUsers(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	repo := repository.New()
+	user, err := repo.CreateUser(context.Background(), "Alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	router := func(opts handler.Options) http.Handler {
+		h := handler.New(service.New(repo), nil, nil, opts)
+		r := chi.NewRouter()
+		r.Get("/users/{id}", h.GetUser)
+		r.Put("/users/{id}", h.UpdateUser)
+		r.Delete("/users/{id}", h.DeleteUser)
+		return r
+	}
+	do := func(h http.Handler, method, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/users/"+user.ID, strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	h := router(handler.Options{})
+	etag := do(h, http.MethodGet, "", "").Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("expected ETag %q, got %q", `"1"`, etag)
+	}
+
+	rec := do(h, http.MethodPut, etag, `{"name":"Alice B"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update with current ETag: expected %d, got %d", http.StatusOK, rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("expected new ETag %q, got %q", `"2"`, got)
+	}
+
+	if rec := do(h, http.MethodPut, etag, `{"name":"Stale"}`); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("update with stale ETag: expected %d, got %d", http.StatusPreconditionFailed, rec.Code)
+	}
+	if rec := do(h, http.MethodDelete, etag, ""); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("delete with stale ETag: expected %d, got %d", http.StatusPreconditionFailed, rec.Code)
+	}
+	if rec := do(h, http.MethodPut, "", `{"name":"Unconditional"}`); rec.Code != http.StatusOK {
+		t.Errorf("update without If-Match: expected %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	strict := router(handler.Options{RequireIfMatch: true})
+	if rec := do(strict, http.MethodPut, "", `{"name":"Nope"}`); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("strict update without If-Match: expected %d, got %d", http.StatusPreconditionRequired, rec.Code)
+	}
+	if rec := do(strict, http.MethodDelete, "", ""); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("strict delete without If-Match: expected %d, got %d", http.StatusPreconditionRequired, rec.Code)
+	}
+	if rec := do(strict, http.MethodDelete, "*", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("strict delete with wildcard: expected %d, got %d", http.StatusNoContent, rec.Code)
+	}
+}
+
+func TestUserMetadata(t *testing.T) {
+	schema, err := jsonschema.Compile([]byte(`{
+		"type": "object",
+		"required": ["plan"],
+		"properties": {
+			"plan": {"enum": ["free", "pro"]},
+			"seats": {"type": "integer", "minimum": 1}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	svc := service.New(repository.New())
+	svc.SetMetadataSchema(schema)
+	h := handler.New(svc, nil, nil, handler.Options{})
+	r := chi.NewRouter()
+	r.Get("/users", h.ListUsers)
+	r.Post("/users", h.CreateUser)
+	r.Put("/users/{id}", h.UpdateUser)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/users", `{"name":"Ann","email":"ann@example.com","metadata":{"seats":0}}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusUnprocessableEntity, rec.Code, rec.Body)
+	}
+	var verr struct {
+		Error struct {
+			Details map[string]string `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&verr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	want := map[string]string{"/metadata/plan": "is required", "/metadata/seats": "must be at least 1"}
+	if !reflect.DeepEqual(verr.Error.Details, want) {
+		t.Errorf("expected field errors %v, got %v", want, verr.Error.Details)
+	}
+
+	var ann struct {
+		Data struct {
+			ID       string         `json:"id"`
+			Metadata map[string]any `json:"metadata"`
+		} `json:"data"`
+	}
+	rec = do(http.MethodPost, "/users", `{"name":"Ann","email":"ann@example.com","metadata":{"plan":"pro","seats":3}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+	json.NewDecoder(rec.Body).Decode(&ann)
+	if ann.Data.Metadata["plan"] != "pro" {
+		t.Errorf("expected metadata in the response, got %v", ann.Data.Metadata)
+	}
+	if rec := do(http.MethodPost, "/users", `{"name":"Bob","email":"bob@example.com","metadata":{"plan":"free"}}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+
+	if rec := do(http.MethodPut, "/users/"+ann.Data.ID, `{"metadata":{"plan":"gold"}}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("update with invalid metadata: expected status %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	if rec := do(http.MethodPut, "/users/"+ann.Data.ID, `{"name":"Anne"}`); rec.Code != http.StatusOK {
+		t.Errorf("update without metadata: expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	for query, wantNames := range map[string][]string{
+		"metadata.plan=pro":                  {"Anne"},
+		"metadata.plan=free":                 {"Bob"},
+		"metadata.plan=pro&metadata.seats=3": {"Anne"},
+		"metadata.seats=4":                   {},
+	} {
+		rec := do(http.MethodGet, "/users?sort=name&"+query, "")
+		var resp struct {
+			Data []struct {
+				Name string `json:"name"`
+			} `json:"data"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		got := []string{}
+		for _, u := range resp.Data {
+			got = append(got, u.Name)
+		}
+		if rec.Code != http.StatusOK || !reflect.DeepEqual(got, wantNames) {
+			t.Errorf("%s: expected %v, got %d %v", query, wantNames, rec.Code, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package httputil holds the request and response conventions shared by the
+// resource handlers: entity tags from versions, If-Match preconditions,
+// pagination links and query parameter parsing.
+package httputil
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/response"
+)
+
+// SetETag exposes version as a strong entity tag. Call it before writing
+// the body.
+func SetETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// IfMatch reads the If-Match precondition as an expected version, where 0
+// means "any". Without the header it answers 428 if required is set. It
+// writes the error response and returns false when the request cannot
+// proceed.
+func IfMatch(w http.ResponseWriter, r *http.Request, required bool) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if required {
+			response.PreconditionRequired(w, "If-Match header is required")
+			return 0, false
+		}
+		return 0, true
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	tag, err := strconv.Unquote(header)
+	if err != nil {
+		// Weak or malformed tags never match a strong comparison.
+		response.PreconditionFailed(w, "If-Match does not match the current ETag")
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 1 {
+		response.PreconditionFailed(w, "If-Match does not match the current ETag")
+		return 0, false
+	}
+	return version, true
+}
+
+// PositiveInt parses the query parameter key, returning 0 if it is absent.
+func PositiveInt(q url.Values, key string) (int, error) {
+	v := q.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, errors.New(key + " must be a positive integer")
+	}
+	return n, nil
+}
+
+// PageLinks builds RFC 8288 links for a listing, preserving the caller's
+// filters. Cursor requests get cursor links; numbered requests get page links.
+func PageLinks(u *url.URL, cursorMode bool, meta *response.Meta) []response.Link {
+	link := func(rel string, set func(q url.Values)) response.Link {
+		q := u.Query()
+		q.Del("page")
+		q.Del("cursor")
+		set(q)
+		return response.Link{URL: u.Path + "?" + q.Encode(), Rel: rel}
+	}
+	withCursor := func(c string) func(url.Values) {
+		return func(q url.Values) { q.Set("cursor", c) }
+	}
+	withPage := func(p int) func(url.Values) {
+		return func(q url.Values) { q.Set("page", strconv.Itoa(p)) }
+	}
+
+	var links []response.Link
+	if cursorMode {
+		if meta.NextCursor != "" {
+			links = append(links, link("next", withCursor(meta.NextCursor)))
+		}
+		if meta.PrevCursor != "" {
+			links = append(links, link("prev", withCursor(meta.PrevCursor)))
+		}
+		return links
+	}
+
+	if meta.Page < meta.TotalPages {
+		links = append(links, link("next", withPage(meta.Page+1)))
+	}
+	if meta.Page > 1 {
+		links = append(links, link("prev", withPage(min(meta.Page-1, max(meta.TotalPages, 1)))))
+	}
+	if meta.TotalPages > 0 {
+		links = append(links,
+			link("first", withPage(1)),
+			link("last", withPage(meta.TotalPages)),
+		)
+	}
+	return links
+}
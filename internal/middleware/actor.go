@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+)
+
+// Actor records the request ID and client IP for the audit log. It must run
+// after chi's RequestID and RealIP middleware; Auth adds the user ID.
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		ctx := audit.WithActor(r.Context(), audit.Actor{
+			RequestID: chimw.GetReqID(r.Context()),
+			IP:        ip,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
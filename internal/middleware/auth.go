@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
 	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
 	"github.com/muflihunaf/boilerplate-go/pkg/response"
 )
@@ -14,10 +17,20 @@ type contextKey string
 const (
 	UserIDKey contextKey = "user_id"
 	EmailKey  contextKey = "email"
+	ClaimsKey contextKey = "claims"
+	RolesKey  contextKey = "roles"
 )
 
-// Auth validates JWT tokens and injects user claims into context.
-func Auth(jwtSvc *jwt.Service) func(http.Handler) http.Handler {
+// Revocations tells whether a token that is otherwise valid was revoked
+// before its expiry.
+type Revocations interface {
+	IsRevoked(ctx context.Context, claims *jwt.Claims) (bool, error)
+}
+
+// Auth validates JWT tokens and injects user claims into context, including
+// the tenant that every repository query is then scoped to. Tokens that
+// revocations reports as revoked are rejected; revocations may be nil.
+func Auth(jwtSvc *jwt.Service, revocations Revocations) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			token := extractToken(r)
@@ -35,9 +48,28 @@ func Auth(jwtSvc *jwt.Service) func(http.Handler) http.Handler {
 				}
 				return
 			}
+			if revocations != nil {
+				revoked, err := revocations.IsRevoked(r.Context(), claims)
+				if err != nil {
+					slog.Error("check token revocation failed", "error", err, "user_id", claims.UserID)
+					response.InternalError(w)
+					return
+				}
+				if revoked {
+					response.Unauthorized(w, "token has been revoked")
+					return
+				}
+			}
 
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
 			ctx = context.WithValue(ctx, EmailKey, claims.Email)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
+			ctx = context.WithValue(ctx, RolesKey, claims.Roles)
+			ctx = tenant.WithID(ctx, claims.TenantID)
+
+			actor := audit.ActorFrom(ctx)
+			actor.UserID = claims.UserID
+			ctx = audit.WithActor(ctx, actor)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -62,3 +94,16 @@ func GetEmail(ctx context.Context) (string, bool) {
 	email, ok := ctx.Value(EmailKey).(string)
 	return email, ok
 }
+
+// GetRoles extracts the roles the validated token was issued with from
+// context.
+func GetRoles(ctx context.Context) ([]string, bool) {
+	roles, ok := ctx.Value(RolesKey).([]string)
+	return roles, ok
+}
+
+// GetClaims extracts the validated token's claims from context.
+func GetClaims(ctx context.Context) (*jwt.Claims, bool) {
+	claims, ok := ctx.Value(ClaimsKey).(*jwt.Claims)
+	return claims, ok
+}
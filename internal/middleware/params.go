@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/response"
+)
+
+// ValidParam rejects requests whose URL parameter name is not accepted by
+// valid with 400 Bad Request. It must run after routing, e.g. through
+// chi's With or Group, so that the parameter is known.
+func ValidParam(name string, valid func(string) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !valid(chi.URLParam(r, name)) {
+				response.BadRequest(w, "invalid "+name)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/muflihunaf/boilerplate-go/internal/rbac"
+	"github.com/muflihunaf/boilerplate-go/pkg/response"
+)
+
+// Require rejects requests whose token's roles do not grant every one of
+// perms with 403 Forbidden. It must run after Auth.
+func Require(perms ...rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !Can(r.Context(), perms...) {
+				response.Forbidden(w, "permission denied")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSelfOr lets requests through whose URL parameter name is the
+// authenticated user's ID, so that users may act on themselves, and
+// otherwise requires perms like Require. It must run after Auth and after
+// routing, e.g. through chi's With or Group.
+func RequireSelfOr(name string, perms ...rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ := GetUserID(r.Context())
+			if (userID == "" || chi.URLParam(r, name) != userID) && !Can(r.Context(), perms...) {
+				response.Forbidden(w, "permission denied")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Can reports whether the roles of the authenticated user in ctx grant
+// every one of perms. It is false for unauthenticated requests.
+func Can(ctx context.Context, perms ...rbac.Permission) bool {
+	roles, ok := GetRoles(ctx)
+	return ok && rbac.Has(roles, perms...)
+}
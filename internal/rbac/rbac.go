@@ -0,0 +1,65 @@
+// Package rbac defines the roles a user can hold and the permissions they
+// grant. Roles are stored on the user and copied into its access tokens;
+// middleware.Require checks a request's permissions against them.
+package rbac
+
+import "slices"
+
+// Permission allows a kind of operation.
+type Permission string
+
+const (
+	// UsersRead allows listing, reading and exporting users.
+	UsersRead Permission = "users:read"
+	// UsersWrite allows creating, importing, updating, deleting and
+	// restoring any user. Without it, users can only update and delete
+	// themselves.
+	UsersWrite Permission = "users:write"
+	// RolesAssign allows changing users' roles.
+	RolesAssign Permission = "roles:assign"
+	// OrgsInvite allows inviting members to the organization.
+	OrgsInvite Permission = "orgs:invite"
+	// AuditRead allows reading the audit log.
+	AuditRead Permission = "audit:read"
+	// CacheRead allows reading the user cache statistics.
+	CacheRead Permission = "cache:read"
+)
+
+const (
+	// RoleAdmin holds every permission. The first user of a tenant gets it.
+	RoleAdmin = "admin"
+	// RoleMember can read users and manage its own account. Users without
+	// any role are treated as members.
+	RoleMember = "member"
+)
+
+var grants = map[string][]Permission{
+	RoleAdmin:  {UsersRead, UsersWrite, RolesAssign, OrgsInvite, AuditRead, CacheRead},
+	RoleMember: {UsersRead},
+}
+
+// Valid reports whether role is a known role.
+func Valid(role string) bool {
+	_, ok := grants[role]
+	return ok
+}
+
+// Has reports whether roles, together, grant every one of perms.
+func Has(roles []string, perms ...Permission) bool {
+	if len(roles) == 0 {
+		roles = []string{RoleMember}
+	}
+	for _, p := range perms {
+		granted := false
+		for _, role := range roles {
+			if slices.Contains(grants[role], p) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
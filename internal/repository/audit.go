@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// Audit listing bounds.
+const (
+	DefaultAuditLimit = 50
+	MaxAuditLimit     = 200
+)
+
+// AuditEntry records one write: who made it, from where, and what changed.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	TenantID  string    `json:"organization_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	ActorID   string `json:"actor_id,omitempty"` // empty for anonymous or system actions
+	RequestID string `json:"request_id,omitempty"`
+	IP        string `json:"ip,omitempty"`
+
+	Action   string        `json:"action"`
+	TargetID string        `json:"target_id,omitempty"`
+	Changes  []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange is the before and after value of one field. Secrets are
+// recorded as a fixed placeholder, never as their value.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// ErasedValue replaces the values of personal fields in the audit entries
+// about a user whose data has been erased.
+const ErasedValue = "[ERASED]"
+
+// personalField reports whether a FieldChange holds a user's personal data:
+// what anonymize removes from the user itself.
+func personalField(field string) bool {
+	return field == FieldName || field == FieldEmail || strings.HasPrefix(field, "metadata.")
+}
+
+// AuditFilter selects audit entries, newest first.
+type AuditFilter struct {
+	ActorID  string
+	TargetID string
+	Since    time.Time // inclusive
+	Until    time.Time // exclusive
+
+	// BeforeID continues a listing: only entries older than it are returned.
+	BeforeID int64
+	Limit    int
+}
+
+// AuditLog is the read side of the audit trail.
+// Entries are appended through Tx.AppendAudit. Like users, entries belong
+// to the tenant in ctx and listings only return that tenant's.
+type AuditLog interface {
+	// ListAudit returns matching entries, newest first. If more remain,
+	// the second result is the BeforeID that continues the listing.
+	ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, int64, error)
+}
+
+func (f AuditFilter) normalize() AuditFilter {
+	if f.Limit <= 0 {
+		f.Limit = DefaultAuditLimit
+	}
+	if f.Limit > MaxAuditLimit {
+		f.Limit = MaxAuditLimit
+	}
+	return f
+}
+
+func (f AuditFilter) matches(tenantID string, e *AuditEntry) bool {
+	if e.TenantID != tenantID {
+		return false
+	}
+	if f.ActorID != "" && e.ActorID != f.ActorID {
+		return false
+	}
+	if f.TargetID != "" && e.TargetID != f.TargetID {
+		return false
+	}
+	if !f.Since.IsZero() && e.CreatedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.CreatedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+func (e *AuditEntry) clone() AuditEntry {
+	c := *e
+	c.Changes = append([]FieldChange(nil), e.Changes...)
+	return c
+}
+
+// erasePersonalData removes the personal data of the user with the given ID
+// from e: the values of personal fields if e is about them, and the IP
+// address and request ID if they made the change. It reports whether there
+// was any. e must own its Changes.
+func (e *AuditEntry) erasePersonalData(userID string) bool {
+	erased := false
+	if e.ActorID == userID && (e.IP != "" || e.RequestID != "") {
+		e.IP, e.RequestID = "", ""
+		erased = true
+	}
+	if e.TargetID != userID {
+		return erased
+	}
+	for i := range e.Changes {
+		c := &e.Changes[i]
+		if !personalField(c.Field) {
+			continue
+		}
+		for _, v := range []*string{&c.From, &c.To} {
+			if *v != "" && *v != ErasedValue {
+				*v = ErasedValue
+				erased = true
+			}
+		}
+	}
+	return erased
+}
+
+func (r *Repository) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, int64, error) {
+	f = f.normalize()
+	tenantID := tenant.ID(ctx)
+	r.logMu.RLock()
+	defer r.logMu.RUnlock()
+
+	// audit[i].ID == i+1, so entries older than BeforeID end at index BeforeID-1.
+	end := int64(len(r.audit))
+	if f.BeforeID > 0 {
+		end = min(end, f.BeforeID-1)
+	}
+	out := make([]AuditEntry, 0)
+	for i := end - 1; i >= 0; i-- {
+		e := &r.audit[i]
+		if !f.matches(tenantID, e) {
+			continue
+		}
+		if len(out) == f.Limit {
+			return out, out[len(out)-1].ID, nil
+		}
+		out = append(out, e.clone())
+	}
+	return out, 0, nil
+}
+
+// AppendAudit records e; it is rolled back with the rest of the transaction.
+func (t *memTx) AppendAudit(ctx context.Context, e AuditEntry) error {
+	r := t.r
+	n := len(r.audit)
+	e.ID = int64(n + 1)
+	e.TenantID = tenant.ID(ctx)
+	e.CreatedAt = time.Now()
+	e = e.clone()
+
+	t.undo = append(t.undo, func() { r.audit = r.audit[:n] })
+	r.audit = append(r.audit, e)
+	t.ops = append(t.ops, auditOp(e))
+	return nil
+}
+
+// eraseAudit removes the personal data of user id from the audit entries
+// about them or made by them, and records how to undo it. IDs are unique
+// across tenants.
+func (t *memTx) eraseAudit(id string) {
+	r := t.r
+	for i := range r.audit {
+		if r.audit[i].TargetID != id && r.audit[i].ActorID != id {
+			continue
+		}
+		erased := r.audit[i].clone()
+		if !erased.erasePersonalData(id) {
+			continue
+		}
+		prev := r.audit[i]
+		t.undo = append(t.undo, func() { r.audit[i] = prev })
+		r.audit[i] = erased
+	}
+	t.ops = append(t.ops, eraseAuditOp(id))
+}
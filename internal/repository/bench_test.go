@@ -0,0 +1,90 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+const benchUsers = 1000
+
+// BenchmarkRepositoryMixed measures throughput of parallel GetUser and
+// UpdateUser calls spread across many users, at several read ratios.
+func BenchmarkRepositoryMixed(b *testing.B) {
+	for _, readPct := range []int{100, 90, 50} {
+		b.Run(fmt.Sprintf("reads=%d%%", readPct), func(b *testing.B) {
+			ctx := context.Background()
+			r := repository.New()
+			ids := seedUsers(b, r)
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					id := ids[rand.IntN(len(ids))]
+					if rand.IntN(100) < readPct {
+						if _, err := r.GetUser(ctx, id); err != nil {
+							b.Error(err)
+						}
+						continue
+					}
+					if _, err := r.UpdateUser(ctx, id, repository.UserUpdate{Name: "Renamed"}); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetUserDuringRegistration measures reads while registrations run
+// in the background. Passwords are hashed outside the store's locks, so reads
+// should not slow down to the pace of hashing.
+func BenchmarkGetUserDuringRegistration(b *testing.B) {
+	ctx := context.Background()
+	r := repository.New()
+	ids := seedUsers(b, r)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_, _ = r.CreateUserWithPassword(ctx, "New", fmt.Sprintf("new%d@example.com", i), "secret123")
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := r.GetUser(ctx, ids[rand.IntN(len(ids))]); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+}
+
+func seedUsers(b *testing.B, r *repository.Repository) []string {
+	b.Helper()
+	ids := make([]string, benchUsers)
+	for i := range ids {
+		u, err := r.CreateUser(context.Background(), "User", fmt.Sprintf("user%d@example.com", i))
+		if err != nil {
+			b.Fatalf("CreateUser: %v", err)
+		}
+		ids[i] = u.ID
+	}
+	return ids
+}
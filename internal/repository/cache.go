@@ -0,0 +1,295 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// CacheOptions configures a CachedStore.
+type CacheOptions struct {
+	// Size is the most users kept; the least recently used are evicted
+	// beyond it. It must be positive.
+	Size int
+	// TTL bounds how long a user is served from the cache. Zero means
+	// entries only leave on eviction or invalidation.
+	TTL time.Duration
+}
+
+// CacheStats are a CachedStore's counters since it was created.
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`    // lookups the cache could not answer
+	Coalesced     uint64 `json:"coalesced"` // misses that waited for another caller's load
+	Evictions     uint64 `json:"evictions"` // entries dropped to stay within Size
+	Invalidations uint64 `json:"invalidations"`
+	Size          int    `json:"size"`
+	Capacity      int    `json:"capacity"`
+}
+
+// CachedStore is a read-through cache in front of another Store's GetUser.
+//
+// Every write made through the CachedStore, directly or inside its WithTx,
+// evicts the users it touched once the write is done, and a load that
+// raced with the write is not cached. Writes that bypass the decorator are
+// only picked up when the entry expires, so the wrapped store must not be
+// written to directly. Concurrent misses for the same user share one load.
+// Only found users are cached.
+//
+// Any other method is passed straight through. A new UserStore write must
+// be given an invalidating override here.
+type CachedStore struct {
+	Store
+	opts CacheOptions
+
+	mu       sync.Mutex
+	lru      *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+	inflight map[string]*cacheLoad
+	stats    CacheStats
+}
+
+var _ Store = (*CachedStore)(nil)
+
+type cacheEntry struct {
+	key     string
+	user    *User
+	expires time.Time // zero if there is no TTL
+}
+
+// cacheLoad is a GetUser in progress that other misses can wait for.
+type cacheLoad struct {
+	done  chan struct{}
+	user  *User
+	err   error
+	stale bool // a write invalidated the key while loading
+}
+
+// NewCachedStore wraps s with a cache.
+func NewCachedStore(s Store, opts CacheOptions) *CachedStore {
+	return &CachedStore{
+		Store:    s,
+		opts:     opts,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*cacheLoad),
+	}
+}
+
+// cacheKey scopes id to the tenant in ctx, as the store does.
+func cacheKey(ctx context.Context, id string) string {
+	return tenant.ID(ctx) + "\x00" + id
+}
+
+// Stats returns a snapshot of the cache's counters.
+func (c *CachedStore) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	st.Size = c.lru.Len()
+	st.Capacity = c.opts.Size
+	return st
+}
+
+func (c *CachedStore) GetUser(ctx context.Context, id string) (*User, error) {
+	key := cacheKey(ctx, id)
+
+	c.mu.Lock()
+	if u, ok := c.lookupLocked(key); ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return u.clone(), nil
+	}
+	c.stats.Misses++
+	if load, ok := c.inflight[key]; ok {
+		c.stats.Coalesced++
+		c.mu.Unlock()
+		select {
+		case <-load.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if load.err != nil {
+			return nil, load.err
+		}
+		return load.user.clone(), nil
+	}
+	load := &cacheLoad{done: make(chan struct{})}
+	c.inflight[key] = load
+	c.mu.Unlock()
+
+	c.load(ctx, key, id, load)
+	if load.err != nil {
+		return nil, load.err
+	}
+	return load.user.clone(), nil
+}
+
+// load fetches a user for everyone waiting on l. It is not cut short by
+// the caller's cancellation, since other callers may be waiting for it.
+func (c *CachedStore) load(ctx context.Context, key, id string, l *cacheLoad) {
+	defer close(l.done)
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.inflight[key] == l {
+			delete(c.inflight, key)
+		}
+		if l.err == nil && !l.stale {
+			c.addLocked(key, l.user)
+		}
+	}()
+	l.err = errLoadPanicked // overwritten unless GetUser panics
+	l.user, l.err = c.Store.GetUser(context.WithoutCancel(ctx), id)
+}
+
+var errLoadPanicked = errors.New("cached user load panicked")
+
+// lookupLocked returns the cached user for key, dropping it if expired.
+func (c *CachedStore) lookupLocked(key string) (*User, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.user, true
+}
+
+func (c *CachedStore) addLocked(key string, u *User) {
+	e := &cacheEntry{key: key, user: u}
+	if c.opts.TTL > 0 {
+		e.expires = time.Now().Add(c.opts.TTL)
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.opts.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the given keys and keeps loads already under way for
+// them from caching what may be the state before the write.
+func (c *CachedStore) invalidate(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+			c.stats.Invalidations++
+		}
+		if l, ok := c.inflight[key]; ok {
+			l.stale = true
+			delete(c.inflight, key)
+		}
+	}
+}
+
+func (c *CachedStore) UpdateUser(ctx context.Context, id string, upd UserUpdate) (*User, error) {
+	defer c.invalidate(cacheKey(ctx, id))
+	return c.Store.UpdateUser(ctx, id, upd)
+}
+
+func (c *CachedStore) DeleteUser(ctx context.Context, id string, ifVersion int64) error {
+	defer c.invalidate(cacheKey(ctx, id))
+	return c.Store.DeleteUser(ctx, id, ifVersion)
+}
+
+func (c *CachedStore) RestoreUser(ctx context.Context, id string) (*User, error) {
+	defer c.invalidate(cacheKey(ctx, id))
+	return c.Store.RestoreUser(ctx, id)
+}
+
+func (c *CachedStore) ScheduleErasure(ctx context.Context, id string, at *time.Time) (*User, error) {
+	defer c.invalidate(cacheKey(ctx, id))
+	return c.Store.ScheduleErasure(ctx, id, at)
+}
+
+func (c *CachedStore) EraseDueUsers(ctx context.Context, before time.Time) ([]string, error) {
+	ids, err := c.Store.EraseDueUsers(ctx, before)
+	c.invalidate(cacheKeys(ctx, ids)...)
+	return ids, err
+}
+
+// PurgeDeletedUsers needs no invalidation: deleted users are never cached.
+
+// WithTx runs fn against the wrapped store's transaction and, once it has
+// committed or rolled back, invalidates every user it wrote to.
+func (c *CachedStore) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	tx := &cachedTx{}
+	defer func() { c.invalidate(tx.touched...) }()
+	return c.Store.WithTx(ctx, func(inner Tx) error {
+		tx.Tx = inner
+		return fn(tx)
+	})
+}
+
+// Close closes the wrapped store if it has a Close method.
+func (c *CachedStore) Close() error {
+	if cl, ok := c.Store.(io.Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+func cacheKeys(ctx context.Context, ids []string) []string {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = cacheKey(ctx, id)
+	}
+	return keys
+}
+
+// cachedTx is a Tx that notes which users it writes to. Reads go to the
+// transaction, never the cache, so they see its own writes.
+type cachedTx struct {
+	Tx
+	touched []string
+}
+
+func (t *cachedTx) UpdateUser(ctx context.Context, id string, upd UserUpdate) (*User, error) {
+	t.touched = append(t.touched, cacheKey(ctx, id))
+	return t.Tx.UpdateUser(ctx, id, upd)
+}
+
+func (t *cachedTx) DeleteUser(ctx context.Context, id string, ifVersion int64) error {
+	t.touched = append(t.touched, cacheKey(ctx, id))
+	return t.Tx.DeleteUser(ctx, id, ifVersion)
+}
+
+func (t *cachedTx) RestoreUser(ctx context.Context, id string) (*User, error) {
+	t.touched = append(t.touched, cacheKey(ctx, id))
+	return t.Tx.RestoreUser(ctx, id)
+}
+
+func (t *cachedTx) ScheduleErasure(ctx context.Context, id string, at *time.Time) (*User, error) {
+	t.touched = append(t.touched, cacheKey(ctx, id))
+	return t.Tx.ScheduleErasure(ctx, id, at)
+}
+
+func (t *cachedTx) EraseDueUsers(ctx context.Context, before time.Time) ([]string, error) {
+	ids, err := t.Tx.EraseDueUsers(ctx, before)
+	t.touched = append(t.touched, cacheKeys(ctx, ids)...)
+	return ids, err
+}
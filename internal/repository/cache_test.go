@@ -0,0 +1,206 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/repository/repotest"
+)
+
+func TestCachedStoreConformance(t *testing.T) {
+	repotest.RunUserStore(t, func(t *testing.T) repository.Store {
+		return repository.NewCachedStore(repository.New(), repository.CacheOptions{Size: 100, TTL: time.Minute})
+	})
+}
+
+// countingStore counts GetUser calls and, while gate is open, blocks them
+// until it is closed.
+type countingStore struct {
+	repository.Store
+	loads atomic.Int64
+	gate  chan struct{}
+}
+
+func (s *countingStore) GetUser(ctx context.Context, id string) (*repository.User, error) {
+	s.loads.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	return s.Store.GetUser(ctx, id)
+}
+
+func TestCachedStore(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: repository.New()}
+	c := repository.NewCachedStore(backend, repository.CacheOptions{Size: 2, TTL: time.Minute})
+
+	ann, err := c.CreateUser(ctx, "Ann", "ann@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetUser(ctx, ann.ID); err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+	}
+	if n := backend.loads.Load(); n != 1 {
+		t.Errorf("expected one backend load for repeated reads, got %d", n)
+	}
+	if st := c.Stats(); st.Hits != 2 || st.Misses != 1 || st.Size != 1 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	got, _ := c.GetUser(ctx, ann.ID)
+	got.Name = "Mutated"
+	if again, _ := c.GetUser(ctx, ann.ID); again.Name != "Ann" {
+		t.Errorf("expected cached users to be copies, got %q", again.Name)
+	}
+
+	if _, err := c.UpdateUser(ctx, ann.ID, repository.UserUpdate{Name: "Anna"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if u, _ := c.GetUser(ctx, ann.ID); u.Name != "Anna" {
+		t.Errorf("expected an update to invalidate the entry, got %q", u.Name)
+	}
+
+	err = c.WithTx(ctx, func(tx repository.Tx) error {
+		_, err := tx.UpdateUser(ctx, ann.ID, repository.UserUpdate{Name: "Annie"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if u, _ := c.GetUser(ctx, ann.ID); u.Name != "Annie" {
+		t.Errorf("expected a transaction to invalidate the entry, got %q", u.Name)
+	}
+
+	if err := c.DeleteUser(ctx, ann.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := c.GetUser(ctx, ann.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected a deleted user to be gone from the cache, got %v", err)
+	}
+
+	// Capacity 2: reading a third user evicts the least recently used.
+	var ids []string
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		u, err := c.CreateUser(ctx, "User", email)
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		ids = append(ids, u.ID)
+		if _, err := c.GetUser(ctx, u.ID); err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+	}
+	if st := c.Stats(); st.Size != 2 || st.Evictions != 1 {
+		t.Errorf("expected the cache to stay within its capacity, got %+v", st)
+	}
+	before := backend.loads.Load()
+	if _, err := c.GetUser(ctx, ids[0]); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if backend.loads.Load() != before+1 {
+		t.Errorf("expected the evicted user to be loaded again")
+	}
+}
+
+func TestCachedStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: repository.New()}
+	c := repository.NewCachedStore(backend, repository.CacheOptions{Size: 10, TTL: 20 * time.Millisecond})
+
+	u, err := c.CreateUser(ctx, "Ann", "ann@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	c.GetUser(ctx, u.ID)
+	c.GetUser(ctx, u.ID)
+	time.Sleep(30 * time.Millisecond)
+	c.GetUser(ctx, u.ID)
+	if n := backend.loads.Load(); n != 2 {
+		t.Errorf("expected the entry to be loaded again after its TTL, got %d loads", n)
+	}
+}
+
+func TestCachedStoreCoalescesMisses(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: repository.New()}
+	c := repository.NewCachedStore(backend, repository.CacheOptions{Size: 10})
+
+	u, err := c.CreateUser(ctx, "Ann", "ann@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	backend.gate = make(chan struct{})
+
+	const readers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.GetUser(ctx, u.ID)
+			if err == nil && got.ID != u.ID {
+				err = errors.New("wrong user")
+			}
+			errs <- err
+		}()
+	}
+	// Let every reader reach the cache before the load completes.
+	for c.Stats().Misses < readers {
+		time.Sleep(time.Millisecond)
+	}
+	close(backend.gate)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("GetUser: %v", err)
+		}
+	}
+	if n := backend.loads.Load(); n != 1 {
+		t.Errorf("expected concurrent misses to share one load, got %d", n)
+	}
+	if st := c.Stats(); st.Coalesced != readers-1 {
+		t.Errorf("expected %d coalesced misses, got %+v", readers-1, st)
+	}
+}
+
+func TestCachedStoreDropsLoadRacingWrite(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingStore{Store: repository.New()}
+	c := repository.NewCachedStore(backend, repository.CacheOptions{Size: 10})
+
+	u, err := c.CreateUser(ctx, "Ann", "ann@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	backend.gate = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.GetUser(ctx, u.ID)
+	}()
+	for backend.loads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// The load has read nothing yet, but the write is invalidated before
+	// it finishes: whatever it read must not be cached.
+	if _, err := c.UpdateUser(ctx, u.ID, repository.UserUpdate{Name: "Anna"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	close(backend.gate)
+	<-done
+
+	if st := c.Stats(); st.Size != 0 {
+		t.Errorf("expected a load racing a write not to be cached, got %+v", st)
+	}
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/fieldcrypt"
+)
+
+// User fields that can be encrypted at rest.
+const (
+	FieldName  = "name"
+	FieldEmail = "email"
+)
+
+// ErrEncryptedField is returned by ListUsers for filters and sorts that
+// would need the plaintext of an encrypted column.
+var ErrEncryptedField = errors.New("cannot filter or sort on an encrypted field")
+
+// FieldCipher encrypts the configured User fields before a store persists
+// them, and decrypts them when it reads them back. The in-memory state of a
+// store is always plaintext.
+//
+// When the email is encrypted, the store's email key (which enforces
+// uniqueness and serves GetUserByEmail) becomes a blind index of the
+// normalized address instead of the address itself.
+//
+// A nil *FieldCipher stores everything in plaintext. Values that are
+// already encrypted are decrypted whatever the configuration, so a field can
+// be taken off the list and migrated back with a re-encryption. Plaintext
+// that happens to look encrypted is escaped when stored, so a user cannot
+// make their record unreadable by choosing such a name.
+type FieldCipher struct {
+	keys  *fieldcrypt.Keyring
+	name  bool
+	email bool
+}
+
+// NewFieldCipher encrypts fields (FieldName, FieldEmail) with keys.
+func NewFieldCipher(keys *fieldcrypt.Keyring, fields []string) (*FieldCipher, error) {
+	c := &FieldCipher{keys: keys}
+	for _, f := range fields {
+		switch f {
+		case FieldName:
+			c.name = true
+		case FieldEmail:
+			c.email = true
+		default:
+			return nil, fmt.Errorf("field %q cannot be encrypted", f)
+		}
+	}
+	return c, nil
+}
+
+// escapePrefix marks a stored plaintext value that begins with the "enc:"
+// of encrypted values.
+const escapePrefix = "enc:plain:"
+
+func (c *FieldCipher) encrypts(field string) bool {
+	if c == nil {
+		return false
+	}
+	switch field {
+	case FieldName:
+		return c.name
+	case FieldEmail:
+		return c.email
+	}
+	return false
+}
+
+// seal encrypts value if field is configured, and escapes it otherwise.
+// The user's ID is bound to the ciphertext so that it cannot be moved to
+// another record.
+func (c *FieldCipher) seal(field, id, value string) (string, error) {
+	if !c.encrypts(field) {
+		if strings.HasPrefix(value, "enc:") {
+			return escapePrefix + value, nil
+		}
+		return value, nil
+	}
+	return c.keys.Encrypt(value, field+"\x00"+id)
+}
+
+// open decrypts value if it is encrypted, or unescapes it.
+func (c *FieldCipher) open(field, id, value string) (string, error) {
+	if plain, ok := strings.CutPrefix(value, escapePrefix); ok {
+		return plain, nil
+	}
+	if !fieldcrypt.IsEncrypted(value) {
+		return value, nil
+	}
+	if c == nil {
+		return "", fmt.Errorf("user %s: %s is encrypted but no keys are configured", id, field)
+	}
+	v, err := c.keys.Decrypt(value, field+"\x00"+id)
+	if err != nil {
+		return "", fmt.Errorf("user %s: decrypt %s: %w", id, field, err)
+	}
+	return v, nil
+}
+
+// stale reports whether a stored value is not in the form seal would now
+// produce: plaintext that should be encrypted, encrypted under an older key,
+// or encrypted when it no longer should be.
+func (c *FieldCipher) stale(field, stored string) bool {
+	if !c.encrypts(field) {
+		return fieldcrypt.IsEncrypted(stored)
+	}
+	return c.keys.ReencryptNeeded(stored)
+}
+
+// emailKey is the stored uniqueness and lookup key for email.
+func (c *FieldCipher) emailKey(email string) string {
+	key := NormalizeEmail(email)
+	if c.encrypts(FieldEmail) {
+		return c.keys.BlindIndex(key)
+	}
+	return key
+}
+
+// nameKey is the stored key that name filters match against: the name in
+// lower case, or nothing when names are encrypted.
+func (c *FieldCipher) nameKey(name string) string {
+	if c.encrypts(FieldName) {
+		return ""
+	}
+	return strings.ToLower(name)
+}
+
+// sealUser returns the persisted name and email of u.
+func (c *FieldCipher) sealUser(u *User) (name, email string, err error) {
+	if name, err = c.seal(FieldName, u.ID, u.Name); err != nil {
+		return "", "", err
+	}
+	if email, err = c.seal(FieldEmail, u.ID, u.Email); err != nil {
+		return "", "", err
+	}
+	return name, email, nil
+}
+
+// openUser decrypts u's name and email in place.
+func (c *FieldCipher) openUser(u *User) error {
+	var err error
+	if u.Name, err = c.open(FieldName, u.ID, u.Name); err != nil {
+		return err
+	}
+	u.Email, err = c.open(FieldEmail, u.ID, u.Email)
+	return err
+}
+
+// sealable reports whether values of an audited field go through seal and
+// open: those of the fields a FieldCipher can encrypt.
+func sealable(field string) bool {
+	return field == FieldName || field == FieldEmail
+}
+
+// sealAudit returns e with the values of its encrypted fields sealed, bound
+// to the target user like the user's own fields. e is left untouched.
+func (c *FieldCipher) sealAudit(e AuditEntry) (AuditEntry, error) {
+	e = e.clone()
+	for i := range e.Changes {
+		ch := &e.Changes[i]
+		if !sealable(ch.Field) {
+			continue
+		}
+		for _, v := range []*string{&ch.From, &ch.To} {
+			if *v == "" {
+				continue
+			}
+			sealed, err := c.seal(ch.Field, e.TargetID, *v)
+			if err != nil {
+				return AuditEntry{}, fmt.Errorf("audit entry %d: %w", e.ID, err)
+			}
+			*v = sealed
+		}
+	}
+	return e, nil
+}
+
+// openAudit decrypts the values in e's changes in place.
+func (c *FieldCipher) openAudit(e *AuditEntry) error {
+	for i := range e.Changes {
+		ch := &e.Changes[i]
+		if !sealable(ch.Field) {
+			continue
+		}
+		for _, v := range []*string{&ch.From, &ch.To} {
+			opened, err := c.open(ch.Field, e.TargetID, *v)
+			if err != nil {
+				return fmt.Errorf("audit entry %d: %w", e.ID, err)
+			}
+			*v = opened
+		}
+	}
+	return nil
+}
+
+// staleAudit reports whether any value in e's changes is stale.
+func (c *FieldCipher) staleAudit(e *AuditEntry) bool {
+	for _, ch := range e.Changes {
+		if !sealable(ch.Field) {
+			continue
+		}
+		for _, v := range []string{ch.From, ch.To} {
+			if v != "" && c.stale(ch.Field, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkList rejects list options the store cannot evaluate on ciphertext.
+func (c *FieldCipher) checkList(o ListOptions) error {
+	if c.encrypts(FieldName) && (o.NameContains != "" || o.Sort == SortName) {
+		return ErrEncryptedField
+	}
+	if c.encrypts(FieldEmail) && (o.EmailDomain != "" || o.Sort == SortEmail) {
+		return ErrEncryptedField
+	}
+	return nil
+}
+
+// Reencrypter is implemented by stores that can rewrite their users with
+// the current FieldCipher configuration, e.g. after adding a key.
+type Reencrypter interface {
+	// ReencryptUsers rewrites every user, across all tenants, whose stored
+	// fields are not sealed as they now would be, and returns how many it
+	// rewrote. Versions and timestamps are left alone: nothing changed.
+	// Audit entries holding values of those fields are rewritten too.
+	ReencryptUsers(ctx context.Context) (int, error)
+}
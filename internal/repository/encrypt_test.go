@@ -0,0 +1,309 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/repository/repotest"
+	"github.com/muflihunaf/boilerplate-go/internal/service"
+	"github.com/muflihunaf/boilerplate-go/pkg/fieldcrypt"
+)
+
+// newFieldCipher encrypts the name and email with keys of the given versions.
+func newFieldCipher(t *testing.T, versions ...uint32) *repository.FieldCipher {
+	t.Helper()
+	keys := make(map[uint32][]byte)
+	for _, v := range versions {
+		keys[v] = bytes.Repeat([]byte{byte(v)}, fieldcrypt.KeySize)
+	}
+	keyring, err := fieldcrypt.New(keys, bytes.Repeat([]byte{0xff}, fieldcrypt.KeySize))
+	if err != nil {
+		t.Fatalf("fieldcrypt.New: %v", err)
+	}
+	c, err := repository.NewFieldCipher(keyring, []string{repository.FieldName, repository.FieldEmail})
+	if err != nil {
+		t.Fatalf("NewFieldCipher: %v", err)
+	}
+	return c
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	repotest.RunUserStore(t, func(t *testing.T) repository.Store {
+		s, err := repository.OpenFileStore(t.TempDir(), repository.FileOptions{Fields: newFieldCipher(t, 1)})
+		if err != nil {
+			t.Fatalf("OpenFileStore: %v", err)
+		}
+		return s
+	})
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := repository.OpenFileStore(dir, repository.FileOptions{Fields: newFieldCipher(t, 1)})
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	ann, _ := s.CreateUser(ctx, "Ann", "ann@example.com")
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	s.CreateUser(ctx, "Bob", "bob@example.com")
+	// Writes through the service also record audit entries and events.
+	cat, err := service.New(s).CreateUser(ctx, "Cat", "cat@example.com", nil)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	assertNotOnDisk(t, dir, "ann@example.com", "bob@example.com", "cat@example.com")
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	assertNotOnDisk(t, dir, "ann@example.com", "bob@example.com", "cat@example.com")
+
+	// Reopen with a new key: old records stay readable and a re-encryption
+	// moves them all to the new one.
+	s, err = repository.OpenFileStore(dir, repository.FileOptions{Fields: newFieldCipher(t, 1, 2)})
+	if err != nil {
+		t.Fatalf("reopen with a new key: %v", err)
+	}
+	if got, err := s.GetUserByEmail(ctx, "ANN@example.com"); err != nil || got.ID != ann.ID || got.Name != "Ann" {
+		t.Fatalf("expected Ann after reopening, got %+v, %v", got, err)
+	}
+	if n, err := s.ReencryptUsers(ctx); err != nil || n != 3 {
+		t.Fatalf("ReencryptUsers: got %d, %v", n, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err = repository.OpenFileStore(dir, repository.FileOptions{Fields: newFieldCipher(t, 2)})
+	if err != nil {
+		t.Fatalf("expected the old key to be unneeded after re-encryption, got %v", err)
+	}
+	assertAuditChange(t, s, cat.ID, repository.FieldEmail, "cat@example.com")
+	if _, err := repository.OpenFileStore(dir, repository.FileOptions{}); err == nil {
+		t.Error("expected opening an encrypted store without keys to fail")
+	}
+}
+
+func TestEncryptedSQLStore(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if _, err := repository.MigrateUp(ctx, db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	// Users written before encryption was turned on.
+	plain := repository.NewSQLStore(db)
+	ann, err := plain.CreateUser(ctx, "Ann", "ann@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	s := repository.NewSQLStore(db)
+	s.SetFieldCipher(newFieldCipher(t, 1))
+	if n, err := s.ReencryptUsers(ctx); err != nil || n != 1 {
+		t.Fatalf("ReencryptUsers after enabling encryption: got %d, %v", n, err)
+	}
+	// Writes through the service also record audit entries and events.
+	svc := service.New(s)
+	bob, err := svc.CreateUser(ctx, "Bob", "bob@example.com", nil)
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := svc.UpdateUser(ctx, bob.ID, repository.UserUpdate{Email: "robert@example.com"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	for _, query := range []string{
+		`SELECT name || email || email_key FROM users`,
+		`SELECT changes FROM audit_log`,
+		`SELECT payload FROM outbox_events`,
+	} {
+		var stored string
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		for rows.Next() {
+			rows.Scan(&stored)
+			for _, pii := range []string{"ann@example.com", "bob@example.com", "robert@example.com"} {
+				if strings.Contains(stored, pii) {
+					t.Errorf("expected %q to be encrypted, found it in %q", pii, stored)
+				}
+			}
+		}
+		rows.Close()
+	}
+	assertAuditChange(t, s, bob.ID, repository.FieldEmail, "robert@example.com")
+
+	for email, want := range map[string]string{"ANN@example.com": ann.ID, "robert@example.com": bob.ID} {
+		got, err := s.GetUserByEmail(ctx, email)
+		if err != nil || got.ID != want {
+			t.Errorf("GetUserByEmail(%q): got %+v, %v", email, got, err)
+		}
+	}
+	if got, _ := s.GetUser(ctx, bob.ID); got.Name != "Bob" || got.Email != "robert@example.com" {
+		t.Errorf("expected decrypted fields, got %+v", got)
+	}
+	if _, err := s.CreateUser(ctx, "Ann again", "Ann@Example.com"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected the blind index to keep emails unique, got %v", err)
+	}
+
+	page, err := s.ListUsers(ctx, repository.ListOptions{})
+	if err != nil || page.Total != 2 || page.Users[0].Name != "Ann" {
+		t.Errorf("expected a decrypted list, got %+v, %v", page, err)
+	}
+	for _, opts := range []repository.ListOptions{
+		{NameContains: "ann"},
+		{EmailDomain: "example.com"},
+		{Sort: repository.SortEmail},
+	} {
+		if _, err := s.ListUsers(ctx, opts); !errors.Is(err, repository.ErrEncryptedField) {
+			t.Errorf("ListUsers(%+v): expected ErrEncryptedField, got %v", opts, err)
+		}
+	}
+
+	// Rotate: add key 2, re-encrypt, then drop key 1.
+	s.SetFieldCipher(newFieldCipher(t, 1, 2))
+	if n, err := s.ReencryptUsers(ctx); err != nil || n != 2 {
+		t.Fatalf("ReencryptUsers after rotation: got %d, %v", n, err)
+	}
+	if n, err := s.ReencryptUsers(ctx); err != nil || n != 0 {
+		t.Errorf("expected a second run to find nothing to do, got %d, %v", n, err)
+	}
+	s.SetFieldCipher(newFieldCipher(t, 2))
+	if got, err := s.GetUserByEmail(ctx, "ann@example.com"); err != nil || got.Version != ann.Version {
+		t.Errorf("expected Ann readable under the new key with her version unchanged, got %+v, %v", got, err)
+	}
+	assertAuditChange(t, s, bob.ID, repository.FieldEmail, "robert@example.com")
+}
+
+// assertAuditChange fails unless the newest audit entry about userID
+// changed field to want, i.e. it reads back decrypted.
+func assertAuditChange(t *testing.T, s repository.AuditLog, userID, field, want string) {
+	t.Helper()
+	entries, _, err := s.ListAudit(context.Background(), repository.AuditFilter{TargetID: userID, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	for _, e := range entries {
+		for _, c := range e.Changes {
+			if c.Field == field && c.To == want {
+				return
+			}
+		}
+	}
+	t.Errorf("expected the audit log to show the %s changing to %q, got %+v", field, want, entries)
+}
+
+// assertNotOnDisk fails if any file in dir contains one of values.
+func assertNotOnDisk(t *testing.T, dir string, values ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatalf("read %s: %v", e.Name(), err)
+		}
+		for _, v := range values {
+			if bytes.Contains(data, []byte(v)) {
+				t.Errorf("found %q in plaintext in %s", v, e.Name())
+			}
+		}
+	}
+}
+
+// A name that looks like a sealed value is plaintext all the same, with or
+// without keys, and must not make the store unreadable.
+func TestPlaintextThatLooksEncrypted(t *testing.T) {
+	const name = "enc:v1:x"
+	ctx := context.Background()
+	check := func(t *testing.T, s repository.Store, id string) {
+		t.Helper()
+		if got, err := s.GetUser(ctx, id); err != nil || got.Name != name {
+			t.Errorf("GetUser: got %+v, %v", got, err)
+		}
+		if page, err := s.ListUsers(ctx, repository.ListOptions{}); err != nil || page.Total != 1 || page.Users[0].Name != name {
+			t.Errorf("ListUsers: got %+v, %v", page, err)
+		}
+		assertAuditChange(t, s, id, repository.FieldName, name)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		fields func(t *testing.T) *repository.FieldCipher
+	}{
+		{"plaintext", func(*testing.T) *repository.FieldCipher { return nil }},
+		{"encrypted email", func(t *testing.T) *repository.FieldCipher {
+			keyring, err := fieldcrypt.New(map[uint32][]byte{1: bytes.Repeat([]byte{1}, fieldcrypt.KeySize)}, bytes.Repeat([]byte{0xff}, fieldcrypt.KeySize))
+			if err != nil {
+				t.Fatalf("fieldcrypt.New: %v", err)
+			}
+			c, err := repository.NewFieldCipher(keyring, []string{repository.FieldEmail})
+			if err != nil {
+				t.Fatalf("NewFieldCipher: %v", err)
+			}
+			return c
+		}},
+	} {
+		t.Run("file/"+tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			open := func() *repository.FileStore {
+				t.Helper()
+				s, err := repository.OpenFileStore(dir, repository.FileOptions{Fields: tc.fields(t)})
+				if err != nil {
+					t.Fatalf("OpenFileStore: %v", err)
+				}
+				return s
+			}
+			s := open()
+			ann, err := service.New(s).CreateUser(ctx, "Ann", "ann@example.com", nil)
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			if _, err := service.New(s).UpdateUser(ctx, ann.ID, repository.UserUpdate{Name: name}); err != nil {
+				t.Fatalf("UpdateUser: %v", err)
+			}
+
+			// Replayed from the log, then read from a snapshot.
+			replayed := open()
+			check(t, replayed, ann.ID)
+			replayed.Close()
+			if err := s.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			s = open()
+			check(t, s, ann.ID)
+			s.Close()
+		})
+
+		t.Run("sql/"+tc.name, func(t *testing.T) {
+			db := openSQLite(t)
+			if _, err := repository.MigrateUp(ctx, db); err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+			s := repository.NewSQLStore(db)
+			s.SetFieldCipher(tc.fields(t))
+			ann, err := service.New(s).CreateUser(ctx, "Ann", "ann@example.com", nil)
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			if _, err := service.New(s).UpdateUser(ctx, ann.ID, repository.UserUpdate{Name: name}); err != nil {
+				t.Fatalf("UpdateUser: %v", err)
+			}
+			check(t, s, ann.ID)
+			if _, err := s.ReencryptUsers(ctx); err != nil {
+				t.Fatalf("ReencryptUsers: %v", err)
+			}
+			check(t, s, ann.ID)
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/repository/repotest"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+func TestFileStore(t *testing.T) {
+	repotest.RunUserStore(t, func(t *testing.T) repository.Store {
+		return openFileStore(t, t.TempDir(), 0)
+	})
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openFileStore(t, dir, 0)
+	alice, _ := s.CreateUser(ctx, "Alice", "alice@example.com")
+	bob, _ := s.CreateUserWithPassword(ctx, "Bob", "bob@example.com", "secret123")
+	if _, err := s.UpdateUser(ctx, alice.ID, repository.UserUpdate{Name: "Alice Liddell"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	carol, _ := s.CreateUser(ctx, "Carol", "carol@example.com")
+	if err := s.DeleteUser(ctx, carol.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	// Simulate a crash: drop the store without Close so only the WAL remains.
+
+	reopened := openFileStore(t, dir, 0)
+	got, err := reopened.GetUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("GetUser after replay: %v", err)
+	}
+	if got.Name != "Alice Liddell" {
+		t.Errorf("expected replayed update, got %q", got.Name)
+	}
+	gotBob, err := reopened.GetUser(ctx, bob.ID)
+	if err != nil {
+		t.Fatalf("GetUser after replay: %v", err)
+	}
+	if !reopened.CheckPassword(gotBob.Password, "secret123") {
+		t.Error("expected password hash to survive replay")
+	}
+	if _, err := reopened.GetUser(ctx, carol.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected deleted user to stay deleted, got %v", err)
+	}
+}
+
+func TestFileStoreTx(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openFileStore(t, dir, 0)
+	var alice, bob *repository.User
+	err := s.WithTx(ctx, func(tx repository.Tx) error {
+		var err error
+		if alice, err = tx.CreateUser(ctx, "Alice", "alice@example.com"); err != nil {
+			return err
+		}
+		bob, err = tx.CreateUser(ctx, "Bob", "bob@example.com")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	_ = s.WithTx(ctx, func(tx repository.Tx) error {
+		if _, err := tx.CreateUser(ctx, "Carol", "carol@example.com"); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+
+	reopened := openFileStore(t, dir, 0)
+	for _, u := range []*repository.User{alice, bob} {
+		if _, err := reopened.GetUser(ctx, u.ID); err != nil {
+			t.Errorf("expected committed user %s to replay, got %v", u.Name, err)
+		}
+	}
+	if _, err := reopened.GetUserByEmail(ctx, "carol@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected rolled back write not to be journaled, got %v", err)
+	}
+
+	// The committed transaction is a single record, so chopping the tail
+	// must drop both of its writes together.
+	walPath := filepath.Join(dir, "wal.log")
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	if err := os.Truncate(walPath, info.Size()-10); err != nil {
+		t.Fatalf("truncate wal: %v", err)
+	}
+	again := openFileStore(t, dir, 0)
+	page, err := again.ListUsers(ctx, repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if page.Total != 0 {
+		t.Errorf("expected torn transaction to be dropped as a whole, got %d users", page.Total)
+	}
+}
+
+func TestFileStoreOutbox(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openFileStore(t, dir, 2)
+	for _, typ := range []string{"a", "b", "c"} {
+		err := s.WithTx(ctx, func(tx repository.Tx) error {
+			return tx.AppendEvent(ctx, repository.Event{Type: typ, AggregateID: "u1", Payload: []byte(`{}`)})
+		})
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+	}
+	if err := s.SetConsumerOffset(ctx, "mailer", 2); err != nil {
+		t.Fatalf("SetConsumerOffset: %v", err)
+	}
+
+	// With SnapshotEvery 2 the state is split across snapshot and log.
+	reopened := openFileStore(t, dir, 2)
+	events, err := reopened.ReadEvents(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadEvents: %v", err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	if strings.Join(types, "") != "abc" || events[2].Offset != 3 {
+		t.Errorf("expected events a, b, c to survive reopen, got %+v", events)
+	}
+	if off, _ := reopened.ConsumerOffset(ctx, "mailer"); off != 2 {
+		t.Errorf("expected consumer offset to survive reopen, got %d", off)
+	}
+}
+
+func TestFileStoreTenants(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openFileStore(t, dir, 2)
+	var orgIDs []string
+	for _, name := range []string{"Acme", "Globex", "Initech"} {
+		org, err := s.CreateOrganization(context.Background(), name)
+		if err != nil {
+			t.Fatalf("CreateOrganization: %v", err)
+		}
+		orgIDs = append(orgIDs, org.ID)
+		ctx := tenant.WithID(context.Background(), org.ID)
+		if _, err := s.CreateUser(ctx, name+" Admin", "admin@example.com"); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	// With SnapshotEvery 2 the state is split across snapshot and log.
+	reopened := openFileStore(t, dir, 2)
+	orgs, err := reopened.ListOrganizations(context.Background())
+	if err != nil || len(orgs) != 3 {
+		t.Fatalf("expected 3 organizations to survive reopen, got %+v, %v", orgs, err)
+	}
+	for _, id := range orgIDs {
+		ctx := tenant.WithID(context.Background(), id)
+		u, err := reopened.GetUserByEmail(ctx, "admin@example.com")
+		if err != nil || u.TenantID != id {
+			t.Errorf("expected the tenant's admin to survive reopen, got %+v, %v", u, err)
+		}
+	}
+}
+
+func TestFileStoreTokens(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	expires := time.Now().Add(time.Hour)
+
+	s := openFileStore(t, dir, 2)
+	var ids []string
+	for _, hash := range []string{"h1", "h2", "h3"} {
+		rt, err := s.CreateRefreshToken(ctx, repository.RefreshToken{UserID: "u1", TokenHash: hash, ExpiresAt: expires})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+		ids = append(ids, rt.ID)
+	}
+	if err := s.UseRefreshToken(ctx, ids[0], time.Now()); err != nil {
+		t.Fatalf("UseRefreshToken: %v", err)
+	}
+	if _, err := s.RevokeTokenFamily(ctx, ids[2], time.Now()); err != nil {
+		t.Fatalf("RevokeTokenFamily: %v", err)
+	}
+	if err := s.RevokeAccessToken(ctx, "jti-1", expires); err != nil {
+		t.Fatalf("RevokeAccessToken: %v", err)
+	}
+
+	// With SnapshotEvery 2 the state is split across snapshot and log.
+	reopened := openFileStore(t, dir, 2)
+	if rt, err := reopened.GetRefreshToken(ctx, "h1"); err != nil || rt.UsedAt == nil {
+		t.Errorf("expected the used token to survive reopen, got %+v, %v", rt, err)
+	}
+	if rt, err := reopened.GetRefreshToken(ctx, "h2"); err != nil || rt.UsedAt != nil || rt.RevokedAt != nil {
+		t.Errorf("expected the fresh token to survive reopen, got %+v, %v", rt, err)
+	}
+	if rt, err := reopened.GetRefreshToken(ctx, "h3"); err != nil || rt.RevokedAt == nil {
+		t.Errorf("expected the revoked token to survive reopen, got %+v, %v", rt, err)
+	}
+	if revoked, err := reopened.IsAccessTokenRevoked(ctx, "jti-1"); err != nil || !revoked {
+		t.Errorf("expected the access token revocation to survive reopen, got %v, %v", revoked, err)
+	}
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openFileStore(t, dir, 3)
+	var ids []string
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+		u, err := s.CreateUser(ctx, "User", email)
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		ids = append(ids, u.ID)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "snapshot.json")); err != nil {
+		t.Fatalf("expected snapshot after compaction: %v", err)
+	}
+
+	reopened := openFileStore(t, dir, 3)
+	page, err := reopened.ListUsers(ctx, repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if page.Total != int64(len(ids)) {
+		t.Errorf("expected %d users after snapshot + replay, got %d", len(ids), page.Total)
+	}
+}
+
+func TestFileStoreErasure(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openFileStore(t, dir, 0)
+	ann, _ := s.CreateUser(ctx, "Ann Example", "ann@example.com")
+	err := s.WithTx(ctx, func(tx repository.Tx) error {
+		return tx.AppendAudit(ctx, repository.AuditEntry{
+			ActorID:  ann.ID,
+			IP:       "198.51.100.7",
+			Action:   "create",
+			TargetID: ann.ID,
+			Changes:  []repository.FieldChange{{Field: "name", To: ann.Name}, {Field: "email", To: ann.Email}},
+		})
+	})
+	if err != nil {
+		t.Fatalf("AppendAudit: %v", err)
+	}
+	_, err = s.CreateRefreshToken(ctx, repository.RefreshToken{UserID: ann.ID, TokenHash: "ann-token-hash", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	soon := time.Now().Add(time.Hour)
+	if _, err := s.ScheduleErasure(ctx, ann.ID, &soon); err != nil {
+		t.Fatalf("ScheduleErasure: %v", err)
+	}
+	if _, err := s.EraseDueUsers(ctx, soon.Add(time.Minute)); err != nil {
+		t.Fatalf("EraseDueUsers: %v", err)
+	}
+
+	// The erasure compacts the log, so earlier records do not keep the data.
+	assertNotOnDisk(t, dir, "Ann Example", "ann@example.com", "198.51.100.7", "ann-token-hash")
+
+	reopened := openFileStore(t, dir, 0)
+	entries, _, err := reopened.ListAudit(ctx, repository.AuditFilter{TargetID: ann.ID})
+	if err != nil || len(entries) != 1 || entries[0].Changes[0].To != repository.ErasedValue {
+		t.Errorf("expected the erased audit entry after reopen, got %+v, %v", entries, err)
+	}
+}
+
+func TestFileStoreTornTail(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := openFileStore(t, dir, 0)
+	alice, _ := s.CreateUser(ctx, "Alice", "alice@example.com")
+	bob, _ := s.CreateUser(ctx, "Bob", "bob@example.com")
+
+	// Chop the last record in half, as if the process died mid-write.
+	walPath := filepath.Join(dir, "wal.log")
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatalf("stat wal: %v", err)
+	}
+	if err := os.Truncate(walPath, info.Size()-10); err != nil {
+		t.Fatalf("truncate wal: %v", err)
+	}
+
+	reopened := openFileStore(t, dir, 0)
+	if _, err := reopened.GetUser(ctx, alice.ID); err != nil {
+		t.Errorf("expected intact record to replay, got %v", err)
+	}
+	if _, err := reopened.GetUser(ctx, bob.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected torn record to be dropped, got %v", err)
+	}
+
+	// The torn bytes must be gone so new records are not stranded behind them.
+	carol, err := reopened.CreateUser(ctx, "Carol", "carol@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser after recovery: %v", err)
+	}
+	again := openFileStore(t, dir, 0)
+	if _, err := again.GetUser(ctx, carol.ID); err != nil {
+		t.Errorf("expected record written after recovery to replay, got %v", err)
+	}
+}
+
+func openFileStore(t *testing.T, dir string, snapshotEvery int) *repository.FileStore {
+	t.Helper()
+	s, err := repository.OpenFileStore(dir, repository.FileOptions{SnapshotEvery: snapshotEvery})
+	if err != nil {
+		t.Fatalf("OpenFileStore: %v", err)
+	}
+	return s
+}
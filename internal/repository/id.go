@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IDGenerator makes the IDs of new users and organizations.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// ID schemes, as selected by NewIDGenerator.
+const (
+	IDSchemeUUIDv7 = "uuidv7"
+	IDSchemeULID   = "ulid"
+)
+
+// NewIDGenerator returns the generator for an ID scheme.
+func NewIDGenerator(scheme string) (IDGenerator, error) {
+	switch scheme {
+	case IDSchemeUUIDv7:
+		return NewUUIDv7Generator(), nil
+	case IDSchemeULID:
+		return NewULIDGenerator(), nil
+	}
+	return nil, fmt.Errorf("unknown ID scheme %q", scheme)
+}
+
+// ValidID reports whether id is well-formed in any scheme a store may hold:
+// UUIDs, ULIDs, and the 32 hex digits of IDs made before either existed.
+// Stores keep working across a change of scheme, so this does not check
+// the configured one.
+func ValidID(id string) bool {
+	switch len(id) {
+	case 32:
+		_, err := hex.DecodeString(id)
+		return err == nil
+	case 36:
+		for i := 0; i < len(id); i++ {
+			switch i {
+			case 8, 13, 18, 23:
+				if id[i] != '-' {
+					return false
+				}
+			default:
+				if !isHex(id[i]) {
+					return false
+				}
+			}
+		}
+		return true
+	case 26:
+		// The first character only carries 3 bits of the timestamp.
+		if id[0] > '7' {
+			return false
+		}
+		for i := 0; i < len(id); i++ {
+			if crockfordIndex(id[i]) < 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// monotonic produces a millisecond timestamp and random bits that sort
+// strictly after the previous pair: within the same millisecond (or if the
+// clock steps back) the random bits are incremented instead of redrawn.
+type monotonic struct {
+	bits int // random bits used, 73 to 80
+
+	mu     sync.Mutex
+	lastMs uint64
+	rnd    [10]byte // big-endian; only the low bits are used
+}
+
+func (m *monotonic) next() (uint64, [10]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms > m.lastMs {
+		if err := m.draw(); err != nil {
+			return 0, m.rnd, err
+		}
+		m.lastMs = ms
+		return ms, m.rnd, nil
+	}
+	if m.increment() {
+		return m.lastMs, m.rnd, nil
+	}
+	// The random bits overflowed: borrow the next millisecond.
+	if err := m.draw(); err != nil {
+		return 0, m.rnd, err
+	}
+	m.lastMs++
+	return m.lastMs, m.rnd, nil
+}
+
+// draw fills the random bits.
+func (m *monotonic) draw() error {
+	if _, err := rand.Read(m.rnd[:]); err != nil {
+		return fmt.Errorf("read random bits for ID: %w", err)
+	}
+	m.rnd[0] &= 0xff >> (80 - m.bits)
+	return nil
+}
+
+// increment adds one to the random bits and reports false on overflow.
+func (m *monotonic) increment() bool {
+	for i := len(m.rnd) - 1; i >= 0; i-- {
+		m.rnd[i]++
+		if m.rnd[i] != 0 {
+			// The carry stopped; check it did not reach the unused bits.
+			return m.rnd[0]>>(m.bits-72) == 0
+		}
+	}
+	return false
+}
+
+// UUIDv7Generator makes version 7 UUIDs (RFC 9562) in canonical lowercase
+// form. They start with the creation time in milliseconds, so they sort by
+// it as strings; IDs made in the same millisecond are still increasing.
+type UUIDv7Generator struct {
+	m monotonic
+}
+
+func NewUUIDv7Generator() *UUIDv7Generator {
+	return &UUIDv7Generator{m: monotonic{bits: 74}}
+}
+
+func (g *UUIDv7Generator) NewID() (string, error) {
+	ms, rnd, err := g.m.next()
+	if err != nil {
+		return "", err
+	}
+	// The 74 random bits are rand_a (12) followed by rand_b (62).
+	hi := uint64(rnd[0])<<8 | uint64(rnd[1])
+	lo := binary.BigEndian.Uint64(rnd[2:])
+	randA := hi<<2 | lo>>62
+	randB := lo & (1<<62 - 1)
+
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[0:8], ms<<16|0x7<<12|randA)
+	binary.BigEndian.PutUint64(b[8:16], 0b10<<62|randB)
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:36], b[10:16])
+	return string(s[:]), nil
+}
+
+// ULIDGenerator makes ULIDs: 26 Crockford base32 characters encoding a
+// millisecond timestamp and 80 random bits, monotonic within a millisecond.
+type ULIDGenerator struct {
+	m monotonic
+}
+
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{m: monotonic{bits: 80}}
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (g *ULIDGenerator) NewID() (string, error) {
+	ms, rnd, err := g.m.next()
+	if err != nil {
+		return "", err
+	}
+	var b [16]byte
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	copy(b[6:], rnd[:])
+
+	// 128 bits in 26 characters of 5 bits, the first one carrying 3.
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:]), nil
+}
+
+// crockfordIndex returns the value of a Crockford base32 digit, or -1.
+// Lowercase is accepted; the ambiguous I, L, O and U are not.
+func crockfordIndex(c byte) int {
+	if 'a' <= c && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// defaultIDs is used by stores that were not given a generator.
+var defaultIDs IDGenerator = NewUUIDv7Generator()
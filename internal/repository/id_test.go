@@ -0,0 +1,61 @@
+package repository_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+)
+
+func TestIDGenerators(t *testing.T) {
+	for _, scheme := range []string{repository.IDSchemeUUIDv7, repository.IDSchemeULID} {
+		t.Run(scheme, func(t *testing.T) {
+			g, err := repository.NewIDGenerator(scheme)
+			if err != nil {
+				t.Fatalf("NewIDGenerator: %v", err)
+			}
+			// Far more IDs than milliseconds pass, so most share one.
+			prev := ""
+			for i := 0; i < 10000; i++ {
+				id, err := g.NewID()
+				if err != nil {
+					t.Fatalf("NewID: %v", err)
+				}
+				if !repository.ValidID(id) {
+					t.Fatalf("generated an invalid ID %q", id)
+				}
+				if id <= prev {
+					t.Fatalf("expected IDs to increase, got %q after %q", id, prev)
+				}
+				prev = id
+			}
+			if scheme == repository.IDSchemeUUIDv7 && (prev[14] != '7' || !strings.ContainsRune("89ab", rune(prev[19]))) {
+				t.Errorf("expected a version 7, RFC 9562 variant UUID, got %q", prev)
+			}
+		})
+	}
+
+	if _, err := repository.NewIDGenerator("serial"); err == nil {
+		t.Error("expected an unknown scheme to be rejected")
+	}
+}
+
+func TestValidID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"0123456789abcdef0123456789abcdef":     true, // legacy random hex
+		"01890a5d-ac96-774b-bcce-b302099a8057": true,
+		"01ARZ3NDEKTSV4RRFFQ69G5FAV":           true,
+		"01arz3ndektsv4rrffq69g5fav":           true,
+		"":                                     false,
+		"missing":                              false,
+		"0123456789abcdef0123456789abcdeg":     false,
+		"01890a5d-ac96-774b-bcce_b302099a8057": false,
+		"81ARZ3NDEKTSV4RRFFQ69G5FAV":           false, // overflows 128 bits
+		"01ARZ3NDEKTSV4RRFFQ69G5FAI":           false, // I is not Crockford base32
+		"../../../../etc/passwd":               false,
+	} {
+		if got := repository.ValidID(id); got != want {
+			t.Errorf("ValidID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
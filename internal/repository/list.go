@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort fields accepted by ListOptions.Sort.
+const (
+	SortCreatedAt = "created_at"
+	SortName      = "name"
+	SortEmail     = "email"
+)
+
+// Page bounds applied by ListUsers. MaxPage keeps the offset of a page,
+// (Page-1)*PerPage, well within the range of an int.
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+	MaxPage        = 1_000_000
+)
+
+// NormalizePage applies the page defaults and bounds to a 1-based page
+// number and a page size.
+func NormalizePage(page, perPage int) (int, int) {
+	if perPage <= 0 {
+		perPage = DefaultPerPage
+	}
+	return min(max(page, 1), MaxPage), min(perPage, MaxPerPage)
+}
+
+var (
+	// ErrInvalidCursor is returned when a cursor is malformed or was issued
+	// for a different sort order than the one requested.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInvalidSort is returned for an unknown ListOptions.Sort field.
+	ErrInvalidSort = errors.New("invalid sort field")
+)
+
+// sortKeyLayout renders timestamps as fixed-width UTC strings so they order
+// correctly under plain string comparison, both here and in SQL.
+const sortKeyLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// ListOptions filters, orders and paginates ListUsers.
+// Results are always ordered by (Sort, ID) so pagination is stable.
+type ListOptions struct {
+	// Page is 1-based and ignored when Cursor is set.
+	Page    int
+	PerPage int
+	// Cursor is an opaque NextCursor or PrevCursor from a previous UserPage.
+	Cursor string
+
+	Sort string // SortCreatedAt (default), SortName or SortEmail
+	Desc bool
+
+	EmailDomain   string    // exact domain match, case-insensitive
+	NameContains  string    // case-insensitive substring
+	CreatedAfter  time.Time // inclusive
+	CreatedBefore time.Time // exclusive
+	// Metadata keeps users whose metadata has, for each key, a value equal
+	// to the given text: a string exactly, a number numerically, or a
+	// boolean as "true" or "false". Keys must pass ValidMetadataKey.
+	Metadata map[string]string
+
+	IncludeDeleted bool
+}
+
+// UserPage is one page of ListUsers results.
+type UserPage struct {
+	Users []User
+	// Total is the number of users matching the filters across all pages.
+	Total int64
+	// Page and PerPage echo the effective request; Page is 0 in cursor mode.
+	Page    int
+	PerPage int
+
+	NextCursor string
+	PrevCursor string
+}
+
+// cursor marks a position between two rows of a sorted listing.
+type cursor struct {
+	Sort   string `json:"s"`
+	Desc   bool   `json:"d,omitempty"`
+	Key    string `json:"k"`
+	ID     string `json:"i"`
+	Before bool   `json:"b,omitempty"` // page backwards from this position
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// normalize applies defaults and decodes the cursor, if any.
+func (o ListOptions) normalize() (ListOptions, *cursor, error) {
+	switch o.Sort {
+	case "":
+		o.Sort = SortCreatedAt
+	case SortCreatedAt, SortName, SortEmail:
+	default:
+		return o, nil, ErrInvalidSort
+	}
+	for key := range o.Metadata {
+		if !ValidMetadataKey(key) {
+			return o, nil, ErrInvalidMetadataKey
+		}
+	}
+	o.EmailDomain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(o.EmailDomain), "@"))
+
+	o.Page, o.PerPage = NormalizePage(o.Page, o.PerPage)
+	if o.Cursor == "" {
+		return o, nil, nil
+	}
+
+	c, err := decodeCursor(o.Cursor)
+	if err != nil {
+		return o, nil, err
+	}
+	if c.Sort != o.Sort || c.Desc != o.Desc {
+		return o, nil, ErrInvalidCursor
+	}
+	o.Page = 0
+	return o, c, nil
+}
+
+// matches reports whether u passes the filters in o.
+func (o ListOptions) matches(u *User) bool {
+	if !o.IncludeDeleted && u.IsDeleted() {
+		return false
+	}
+	if o.EmailDomain != "" && !strings.HasSuffix(NormalizeEmail(u.Email), "@"+o.EmailDomain) {
+		return false
+	}
+	if o.NameContains != "" && !strings.Contains(strings.ToLower(u.Name), strings.ToLower(o.NameContains)) {
+		return false
+	}
+	if !o.CreatedAfter.IsZero() && u.CreatedAt.Before(o.CreatedAfter) {
+		return false
+	}
+	if !o.CreatedBefore.IsZero() && !u.CreatedAt.Before(o.CreatedBefore) {
+		return false
+	}
+	for key, want := range o.Metadata {
+		if !metadataMatches(u.Metadata[key], want) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortKey returns the value u is ordered by for the given sort field.
+func sortKey(u *User, field string) string {
+	switch field {
+	case SortName:
+		return u.Name
+	case SortEmail:
+		return NormalizeEmail(u.Email)
+	default:
+		return u.CreatedAt.UTC().Format(sortKeyLayout)
+	}
+}
+
+func cursorAt(u *User, o ListOptions, before bool) string {
+	return cursor{Sort: o.Sort, Desc: o.Desc, Key: sortKey(u, o.Sort), ID: u.ID, Before: before}.encode()
+}
+
+// paginate sorts and slices an already filtered set of users in memory.
+func paginate(users []User, o ListOptions, c *cursor) *UserPage {
+	keys := make([]string, len(users))
+	for i := range users {
+		keys[i] = sortKey(&users[i], o.Sort)
+	}
+	sort.Sort(byKey{users: users, keys: keys, desc: o.Desc})
+
+	// position returns the index of the first row after the cursor position.
+	position := func(c *cursor) int {
+		return sort.Search(len(users), func(i int) bool {
+			cmp := strings.Compare(keys[i], c.Key)
+			if cmp == 0 {
+				cmp = strings.Compare(users[i].ID, c.ID)
+			}
+			if o.Desc {
+				cmp = -cmp
+			}
+			return cmp > 0
+		})
+	}
+
+	var start, end int
+	switch {
+	case c == nil:
+		start = min((o.Page-1)*o.PerPage, len(users))
+		end = min(start+o.PerPage, len(users))
+	case c.Before:
+		// Rows strictly before the cursor; the cursor row itself sorts at position-1.
+		end = position(c)
+		if end > 0 && users[end-1].ID == c.ID {
+			end--
+		}
+		start = max(end-o.PerPage, 0)
+	default:
+		start = position(c)
+		end = min(start+o.PerPage, len(users))
+	}
+
+	page := &UserPage{
+		Users:   append(make([]User, 0, end-start), users[start:end]...),
+		Total:   int64(len(users)),
+		Page:    o.Page,
+		PerPage: o.PerPage,
+	}
+	if end > start {
+		if end < len(users) {
+			page.NextCursor = cursorAt(&users[end-1], o, false)
+		}
+		if start > 0 {
+			page.PrevCursor = cursorAt(&users[start], o, true)
+		}
+	}
+	return page
+}
+
+type byKey struct {
+	users []User
+	keys  []string
+	desc  bool
+}
+
+func (b byKey) Len() int { return len(b.users) }
+
+func (b byKey) Less(i, j int) bool {
+	cmp := strings.Compare(b.keys[i], b.keys[j])
+	if cmp == 0 {
+		cmp = strings.Compare(b.users[i].ID, b.users[j].ID)
+	}
+	if b.desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (b byKey) Swap(i, j int) {
+	b.users[i], b.users[j] = b.users[j], b.users[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
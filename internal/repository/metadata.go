@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	// ErrInvalidMetadata is returned for metadata that cannot be stored as
+	// a JSON object.
+	ErrInvalidMetadata = errors.New("invalid metadata")
+	// ErrInvalidMetadataKey is returned for a ListOptions.Metadata key that
+	// ValidMetadataKey rejects.
+	ErrInvalidMetadataKey = errors.New("invalid metadata key")
+)
+
+// ValidMetadataKey reports whether key can be used in a metadata filter:
+// 1 to 64 ASCII letters, digits, underscores or hyphens.
+func ValidMetadataKey(key string) bool {
+	if key == "" || len(key) > 64 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeMetadata returns a private copy of m in the form it reads back
+// from JSON (numbers as float64, nested objects as map[string]any), so that
+// every store holds and filters the same values. Empty metadata is nil.
+func normalizeMetadata(m map[string]any) (map[string]any, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	return out, nil
+}
+
+// cloneMetadata deep-copies normalized metadata.
+func cloneMetadata(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	return cloneJSON(m).(map[string]any)
+}
+
+func cloneJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for k, e := range v {
+			c[k] = cloneJSON(e)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, e := range v {
+			c[i] = cloneJSON(e)
+		}
+		return c
+	default:
+		return v // strings, float64, bool and nil are immutable
+	}
+}
+
+// metadataMatches reports whether a metadata value equals a filter value
+// given as text: a string exactly, a boolean as "true"/"false", a number
+// numerically. Objects, arrays and null never match.
+func metadataMatches(v any, want string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == want
+	case bool:
+		return strconv.FormatBool(v) == want
+	case float64:
+		f, err := strconv.ParseFloat(want, 64)
+		return err == nil && f == v
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/fieldcrypt"
+)
+
+// Migrations live in migrations/ as NNNN_description.up.sql and
+// NNNN_description.down.sql pairs and are compiled into the binary.
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+// migrationHooks rewrite data that SQL cannot derive the way the store
+// does, such as keys normalized by Go's Unicode case mapping. A hook runs
+// in the transaction of the migration with the same version, after its up
+// file.
+var migrationHooks = map[int]func(ctx context.Context, tx *sql.Tx) error{
+	13: normalizeUserKeys,
+}
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		base, direction, ok := splitMigrationName(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+		prefix, desc, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q", name)
+		}
+
+		body, err := fs.ReadFile(migrationFS, path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: desc}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// SchemaVersion returns the highest applied migration version, or 0.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	if _, err := db.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return 0, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// MigrateUp applies every pending migration and returns how many ran.
+func MigrateUp(ctx context.Context, db *sql.DB) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.up); err != nil {
+				return err
+			}
+			if hook := migrationHooks[m.Version]; hook != nil {
+				if err := hook(ctx, tx); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx,
+				`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+				m.Version, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s up: %w", m.Version, m.Name, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts up to steps applied migrations, newest first, and
+// returns how many were reverted.
+func MigrateDown(ctx context.Context, db *sql.DB, steps int) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	current, err := SchemaVersion(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+		m := migrations[i]
+		if m.Version > current {
+			continue
+		}
+		err := inTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("migration %04d_%s down: %w", m.Version, m.Name, err)
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+func splitMigrationName(name string) (base, direction string, ok bool) {
+	for _, d := range []string{"up", "down"} {
+		if b, found := strings.CutSuffix(name, "."+d+".sql"); found {
+			return b, d, true
+		}
+	}
+	return "", "", false
+}
+
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// normalizeUserKeys sets every user's name_key, and re-derives the email_key
+// that migration 2 made with SQLite's lower(). Encrypted values are left
+// alone: their names are not filtered on, and their email keys are blind
+// indexes already.
+func normalizeUserKeys(ctx context.Context, tx *sql.Tx) error {
+	type keys struct{ id, name, email string }
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, email FROM users`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var users []keys
+	for rows.Next() {
+		var u keys
+		if err := rows.Scan(&u.id, &u.name, &u.email); err != nil {
+			return err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	// A nil FieldCipher opens plaintext and derives plaintext keys.
+	var plain *FieldCipher
+	for _, u := range users {
+		if !fieldcrypt.IsEncrypted(u.name) {
+			name, err := plain.open(FieldName, u.id, u.name)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE users SET name_key = ? WHERE id = ?`, plain.nameKey(name), u.id); err != nil {
+				return err
+			}
+		}
+		if !fieldcrypt.IsEncrypted(u.email) {
+			email, err := plain.open(FieldEmail, u.id, u.email)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE users SET email_key = ? WHERE id = ?`, plain.emailKey(email), u.id); err != nil {
+				return fmt.Errorf("user %s: %w", u.id, mapConstraintError(err))
+			}
+		}
+	}
+	return nil
+}
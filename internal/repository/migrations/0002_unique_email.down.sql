@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_email_key;
+ALTER TABLE users DROP COLUMN email_key;
+CREATE INDEX idx_users_email ON users (email);
@@ -0,0 +1,7 @@
+-- email_key holds NormalizeEmail(email) so uniqueness matches the Go-side
+-- normalization rather than SQLite's ASCII-only lower().
+ALTER TABLE users ADD COLUMN email_key TEXT NOT NULL DEFAULT '';
+UPDATE users SET email_key = lower(trim(email));
+
+DROP INDEX IF EXISTS idx_users_email;
+CREATE UNIQUE INDEX idx_users_email_key ON users (email_key);
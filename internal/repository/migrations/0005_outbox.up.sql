@@ -0,0 +1,14 @@
+-- Consumers read events in id order. SQLite serializes writers, so ids
+-- become visible in the order they were assigned and none is skipped.
+CREATE TABLE outbox_events (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    type         TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    payload      TEXT NOT NULL,
+    created_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE outbox_consumers (
+    name     TEXT PRIMARY KEY,
+    position INTEGER NOT NULL
+);
@@ -0,0 +1,15 @@
+-- changes holds the JSON-encoded []FieldChange.
+CREATE TABLE audit_log (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TIMESTAMP NOT NULL,
+    actor_id   TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    ip         TEXT NOT NULL DEFAULT '',
+    action     TEXT NOT NULL,
+    target_id  TEXT NOT NULL DEFAULT '',
+    changes    TEXT NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX idx_audit_log_actor_id ON audit_log (actor_id, id);
+CREATE INDEX idx_audit_log_target_id ON audit_log (target_id, id);
+CREATE INDEX idx_audit_log_created_at ON audit_log (created_at);
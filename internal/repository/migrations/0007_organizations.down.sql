@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_audit_log_tenant_id;
+ALTER TABLE audit_log DROP COLUMN tenant_id;
+
+ALTER TABLE outbox_events DROP COLUMN tenant_id;
+
+-- Fails if the same email now exists in more than one tenant.
+DROP INDEX IF EXISTS idx_users_tenant_email_key;
+CREATE UNIQUE INDEX idx_users_email_key ON users (email_key);
+ALTER TABLE users DROP COLUMN tenant_id;
+
+DROP TABLE IF EXISTS organizations;
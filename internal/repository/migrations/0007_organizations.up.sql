@@ -0,0 +1,16 @@
+CREATE TABLE organizations (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- tenant_id is the owning organization, or '' for the default tenant.
+-- Emails are unique per tenant rather than globally.
+ALTER TABLE users ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS idx_users_email_key;
+CREATE UNIQUE INDEX idx_users_tenant_email_key ON users (tenant_id, email_key);
+
+ALTER TABLE outbox_events ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE audit_log ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_audit_log_tenant_id ON audit_log (tenant_id, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_erase_at;
+ALTER TABLE users DROP COLUMN erase_at;
@@ -0,0 +1,3 @@
+-- erase_at is when a user asked to have their personal data erased.
+ALTER TABLE users ADD COLUMN erase_at TIMESTAMP;
+CREATE INDEX idx_users_erase_at ON users (tenant_id, erase_at) WHERE erase_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- metadata holds User.Metadata as a JSON object, or NULL when there is none.
+-- It is filtered on with the JSON1 functions, so it is not indexed.
+ALTER TABLE users ADD COLUMN metadata TEXT;
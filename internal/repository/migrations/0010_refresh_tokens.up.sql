@@ -0,0 +1,18 @@
+-- token_hash is the hex SHA-256 of the refresh token; the token itself is
+-- never stored. family_id is the ID of the token issued at login, shared by
+-- every token that replaced it.
+CREATE TABLE refresh_tokens (
+    id         TEXT PRIMARY KEY,
+    family_id  TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    tenant_id  TEXT NOT NULL DEFAULT '',
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id, created_at);
+CREATE INDEX idx_refresh_tokens_expires_at ON refresh_tokens (expires_at);
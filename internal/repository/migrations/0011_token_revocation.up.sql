@@ -0,0 +1,10 @@
+-- tokens_valid_after rejects every access token issued before it.
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMP;
+
+-- revoked_tokens denies access tokens by jti until they expire anyway.
+CREATE TABLE revoked_tokens (
+    jti        TEXT PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
@@ -0,0 +1,2 @@
+-- roles holds User.Roles as a JSON array, or NULL when there are none.
+ALTER TABLE users ADD COLUMN roles TEXT;
@@ -0,0 +1,5 @@
+-- name_key holds the name lowercased by Go, for case-insensitive name
+-- filters that match the memory store's beyond ASCII, which SQLite's lower()
+-- does not. It stays empty while names are encrypted. MigrateUp fills it,
+-- and re-derives email_key with NormalizeEmail, after this file has run.
+ALTER TABLE users ADD COLUMN name_key TEXT NOT NULL DEFAULT '';
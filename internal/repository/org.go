@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Organization is a tenant. Users, outbox events and audit entries each
+// belong to exactly one, identified by its ID; see package tenant.
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrgStore manages organizations. Unlike users, organizations are not
+// scoped to the tenant in ctx: they are the tenants.
+type OrgStore interface {
+	CreateOrganization(ctx context.Context, name string) (*Organization, error)
+	GetOrganization(ctx context.Context, id string) (*Organization, error)
+	// ListOrganizations returns every organization, oldest first.
+	ListOrganizations(ctx context.Context) ([]Organization, error)
+}
+
+func (r *Repository) CreateOrganization(ctx context.Context, name string) (org *Organization, err error) {
+	defer r.afterWrite()
+	r.orgMu.Lock()
+	defer r.orgMu.Unlock()
+
+	err = r.commit(ctx, func(tx *memTx) error {
+		org, err = tx.CreateOrganization(ctx, name)
+		return err
+	})
+	return org, err
+}
+
+func (r *Repository) GetOrganization(ctx context.Context, id string) (*Organization, error) {
+	r.orgMu.RLock()
+	defer r.orgMu.RUnlock()
+	return r.view().GetOrganization(ctx, id)
+}
+
+func (r *Repository) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	r.orgMu.RLock()
+	defer r.orgMu.RUnlock()
+	return r.view().ListOrganizations(ctx)
+}
+
+func (t *memTx) CreateOrganization(ctx context.Context, name string) (*Organization, error) {
+	r := t.r
+	id, err := r.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	org := &Organization{ID: id, Name: name, CreatedAt: time.Now()}
+
+	t.undo = append(t.undo, func() { delete(r.orgs, org.ID) })
+	r.orgs[org.ID] = org
+	t.ops = append(t.ops, orgOp(org))
+
+	c := *org
+	return &c, nil
+}
+
+func (t *memTx) GetOrganization(ctx context.Context, id string) (*Organization, error) {
+	org, ok := t.r.orgs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *org
+	return &c, nil
+}
+
+func (t *memTx) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	out := make([]Organization, 0, len(t.r.orgs))
+	for _, org := range t.r.orgs {
+		out = append(out, *org)
+	}
+	slices.SortFunc(out, func(a, b Organization) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return out, nil
+}
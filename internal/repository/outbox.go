@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// DefaultEventBatch is the number of events ReadEvents returns when no
+// limit is given.
+const DefaultEventBatch = 100
+
+// Event is an outbox entry: a domain event recorded in the same transaction
+// as the change it describes. The repository treats Type and Payload as
+// opaque; see package event for the typed events.
+type Event struct {
+	// Offset is assigned on append. Offsets start at 1 and increase by one
+	// per committed event, so a consumer's progress is a single number.
+	Offset      int64           `json:"offset"`
+	TenantID    string          `json:"tenant_id,omitempty"`
+	Type        string          `json:"type"`
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Outbox is the read side of the event log plus per-consumer progress.
+// Events are appended through Tx.AppendEvent. The log is shared by all
+// tenants, so reads are not scoped; Event.TenantID tells them apart.
+type Outbox interface {
+	// ReadEvents returns up to limit events with an offset greater than
+	// after, oldest first. A limit <= 0 means DefaultEventBatch.
+	ReadEvents(ctx context.Context, after int64, limit int) ([]Event, error)
+	// ConsumerOffset returns the last offset the named consumer has
+	// acknowledged, or 0 if it has never acknowledged one.
+	ConsumerOffset(ctx context.Context, consumer string) (int64, error)
+	SetConsumerOffset(ctx context.Context, consumer string, offset int64) error
+}
+
+func (e *Event) clone() Event {
+	c := *e
+	c.Payload = append(json.RawMessage(nil), e.Payload...)
+	return c
+}
+
+func (r *Repository) ReadEvents(ctx context.Context, after int64, limit int) ([]Event, error) {
+	if limit <= 0 {
+		limit = DefaultEventBatch
+	}
+	r.logMu.RLock()
+	defer r.logMu.RUnlock()
+
+	// events[i].Offset == i+1, so the first event after `after` is at index after.
+	start := min(max(after, 0), int64(len(r.events)))
+	end := min(start+int64(limit), int64(len(r.events)))
+	out := make([]Event, 0, end-start)
+	for i := start; i < end; i++ {
+		out = append(out, r.events[i].clone())
+	}
+	return out, nil
+}
+
+func (r *Repository) ConsumerOffset(ctx context.Context, consumer string) (int64, error) {
+	r.logMu.RLock()
+	defer r.logMu.RUnlock()
+	return r.consumers[consumer], nil
+}
+
+func (r *Repository) SetConsumerOffset(ctx context.Context, consumer string, offset int64) error {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+
+	if err := r.persist(consumerOp(consumer, offset)); err != nil {
+		return err
+	}
+	r.consumers[consumer] = offset
+	return nil
+}
+
+// AppendEvent records e; it is rolled back with the rest of the transaction.
+func (t *memTx) AppendEvent(ctx context.Context, e Event) error {
+	r := t.r
+	n := len(r.events)
+	e.Offset = int64(n + 1)
+	e.TenantID = tenant.ID(ctx)
+	e.CreatedAt = time.Now()
+	e = e.clone()
+
+	t.undo = append(t.undo, func() { r.events = r.events[:n] })
+	r.events = append(r.events, e)
+	t.ops = append(t.ops, eventOp(e))
+	return nil
+}
@@ -1,36 +1,303 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
 )
 
 var (
-	ErrNotFound = errors.New("not found")
-	ErrConflict = errors.New("resource already exists")
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("resource already exists")
+	ErrVersionMismatch = errors.New("version mismatch")
 )
 
-// Repository handles data persistence.
-// Replace the in-memory store with your database of choice (PostgreSQL, MySQL, etc.)
+// UserStore is the persistence contract for users.
+// Repository is the in-memory implementation; any other backend must pass
+// the conformance suite in repotest.
+//
+// Every method is scoped to the tenant in ctx (see package tenant): users
+// of other tenants are invisible to it, as if they did not exist, and new
+// users are created in that tenant. Emails are unique per tenant.
+//
+// DeleteUser is a soft delete: Get* and ListUsers hide deleted users (unless
+// ListOptions.IncludeDeleted is set) and their email stays reserved until
+// PurgeDeletedUsers removes them for good.
+//
+// Users returned by a store belong to the caller: modifying them never
+// affects the store, and later writes never affect them.
+type UserStore interface {
+	ListUsers(ctx context.Context, opts ListOptions) (*UserPage, error)
+	GetUser(ctx context.Context, id string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	CreateUser(ctx context.Context, name, email string) (*User, error)
+	CreateUserWithPassword(ctx context.Context, name, email, password string) (*User, error)
+	// CheckPassword reports whether password matches a hash made by
+	// CreateUserWithPassword, i.e. by the store's password hasher.
+	CheckPassword(hashedPassword, password string) bool
+	// CreateUserWithHash is CreateUserWithPassword for a password already
+	// hashed with HashPassword. Prefer it inside WithTx so the expensive
+	// hash is not computed while the transaction holds its locks.
+	CreateUserWithHash(ctx context.Context, name, email, passwordHash string) (*User, error)
+	// InsertUser creates a user with every field a caller can set, which
+	// the CreateUser* methods are shorthands for.
+	InsertUser(ctx context.Context, u NewUser) (*User, error)
+	UpdateUser(ctx context.Context, id string, upd UserUpdate) (*User, error)
+	DeleteUser(ctx context.Context, id string, ifVersion int64) error
+	RestoreUser(ctx context.Context, id string) (*User, error)
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int, error)
+	// ScheduleErasure sets, or with a nil at clears, User.EraseAt.
+	ScheduleErasure(ctx context.Context, id string, at *time.Time) (*User, error)
+	// EraseDueUsers irreversibly anonymizes the users whose EraseAt is
+	// before the cutoff and returns their IDs. An erased user keeps its ID,
+	// tenant and timestamps; its name becomes ErasedName, its email a
+	// placeholder, its password is removed and it is soft-deleted. In the
+	// audit entries about it, the values of the name, email and metadata
+	// changes become ErasedValue; in those it made, the IP address and
+	// request ID are removed. Its refresh tokens are deleted.
+	EraseDueUsers(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// Tx is the view of a Store inside WithTx. Reads through it see the
+// transaction's own writes; nobody else sees them until it commits.
+type Tx interface {
+	UserStore
+	OrgStore
+	TokenStore
+
+	// AppendEvent adds e to the outbox. Like every other write in the
+	// transaction it only becomes visible if the transaction commits.
+	// Offset and CreatedAt are assigned by the store.
+	AppendEvent(ctx context.Context, e Event) error
+	// AppendAudit adds e to the audit log, committed or rolled back with
+	// the change it describes. ID and CreatedAt are assigned by the store.
+	AppendAudit(ctx context.Context, e AuditEntry) error
+}
+
+// Store is a UserStore that can also group operations into a unit of work
+// and carries the outbox and audit log those operations record.
+type Store interface {
+	UserStore
+	OrgStore
+	TokenStore
+	Outbox
+	AuditLog
+
+	// WithTx runs fn in a transaction. If fn returns an error or panics,
+	// every write made through tx is rolled back; otherwise they commit
+	// together. tx must not be used after fn returns.
+	WithTx(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// shardCount is the number of lock stripes in a Repository. It must be a
+// power of two.
+const shardCount = 32
+
+// Repository is the in-memory UserStore.
+// See FileStore and SQLStore for backends that survive restarts.
+//
+// Users are striped across shards by ID, each with its own lock, so writes
+// to different users do not contend. The email index has a lock of its own
+// that is only taken by creates, email changes and purges, organizations
+// have a third, refresh tokens a fourth and the append-only logs (outbox
+// and audit) share a fifth. Locks are always acquired in the order email
+// index, shards (ascending), organizations, tokens, logs, journal.
+//
+// A write to a single user holds its shard's write lock for the duration of
+// the change; WithTx and PurgeDeletedUsers take every lock, and ListUsers
+// takes every shard's read lock.
+// Readers therefore observe either the state before a write or transaction
+// or the state after it commits, never a partial one, and a ListUsers page
+// is a consistent point-in-time view.
 type Repository struct {
+	shards [shardCount]shard
+
+	emailMu sync.RWMutex
+	emails  map[string]string // emailKey(tenant, email) -> user ID
+
+	orgMu sync.RWMutex
+	orgs  map[string]*Organization
+
+	tokenMu     sync.RWMutex
+	tokens      map[string]*RefreshToken // by ID
+	tokenHashes map[string]string        // token hash -> token ID
+	revoked     map[string]time.Time     // access token jti -> its expiry
+
+	logMu     sync.RWMutex
+	events    []Event          // events[i].Offset == i+1
+	consumers map[string]int64 // consumer name -> acknowledged offset
+	audit     []AuditEntry     // audit[i].ID == i+1
+
+	ids       IDGenerator
+	passwords *password.Hasher
+
+	// journal, when set, durably records every committed transaction.
+	// append is called with the transaction's locks held.
+	journal journal
+}
+
+type shard struct {
 	mu    sync.RWMutex
 	users map[string]*User
 }
 
 func New() *Repository {
-	return &Repository{
-		users: make(map[string]*User),
+	r := &Repository{
+		emails:      make(map[string]string),
+		orgs:        make(map[string]*Organization),
+		tokens:      make(map[string]*RefreshToken),
+		tokenHashes: make(map[string]string),
+		revoked:     make(map[string]time.Time),
+		consumers:   make(map[string]int64),
+		ids:         defaultIDs,
+		passwords:   defaultPasswords,
 	}
+	for i := range r.shards {
+		r.shards[i].users = make(map[string]*User)
+	}
+	return r
 }
 
-// For database connections, you would typically:
-//
-//	func New(db *sql.DB) *Repository {
-//	    return &Repository{db: db}
-//	}
-//
-// Or with GORM:
-//
-//	func New(db *gorm.DB) *Repository {
-//	    return &Repository{db: db}
-//	}
+// SetIDGenerator makes r use g for new IDs instead of UUIDv7. Call it
+// before r is used.
+func (r *Repository) SetIDGenerator(g IDGenerator) {
+	r.ids = g
+}
+
+// SetPasswordHasher makes CreateUserWithPassword hash, and CheckPassword
+// verify, with h instead of password.Default. Call it before r is used.
+func (r *Repository) SetPasswordHasher(h *password.Hasher) {
+	r.passwords = h
+}
+
+// shardFor returns the shard that owns id (FNV-1a).
+func (r *Repository) shardFor(id string) *shard {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return &r.shards[h&(shardCount-1)]
+}
+
+// lockAll takes every lock in the store, in order, for writing.
+func (r *Repository) lockAll() {
+	r.emailMu.Lock()
+	for i := range r.shards {
+		r.shards[i].mu.Lock()
+	}
+	r.orgMu.Lock()
+	r.tokenMu.Lock()
+	r.logMu.Lock()
+}
+
+func (r *Repository) unlockAll() {
+	r.logMu.Unlock()
+	r.tokenMu.Unlock()
+	r.orgMu.Unlock()
+	for i := len(r.shards) - 1; i >= 0; i-- {
+		r.shards[i].mu.Unlock()
+	}
+	r.emailMu.Unlock()
+}
+
+// rlockShards takes every shard lock for reading.
+func (r *Repository) rlockShards() {
+	for i := range r.shards {
+		r.shards[i].mu.RLock()
+	}
+}
+
+func (r *Repository) runlockShards() {
+	for i := len(r.shards) - 1; i >= 0; i-- {
+		r.shards[i].mu.RUnlock()
+	}
+}
+
+// lookupLocked returns the stored user with the given ID, whatever its
+// tenant. Callers must hold its shard's lock.
+func (r *Repository) lookupLocked(id string) (*User, bool) {
+	u, ok := r.shardFor(id).users[id]
+	return u, ok
+}
+
+// lookupInLocked is lookupLocked for a user of the given tenant.
+func (r *Repository) lookupInLocked(tenantID, id string) (*User, bool) {
+	u, ok := r.lookupLocked(id)
+	if !ok || u.TenantID != tenantID {
+		return nil, false
+	}
+	return u, true
+}
+
+// putLocked stores u and keeps the email index in sync. Callers must hold
+// u's shard lock for writing, plus emailMu when u is new or its email
+// changes, and have already checked for email conflicts.
+func (r *Repository) putLocked(u *User) {
+	s := r.shardFor(u.ID)
+	old, ok := s.users[u.ID]
+	if !ok || emailKey(old.TenantID, old.Email) != emailKey(u.TenantID, u.Email) {
+		if ok {
+			r.unindexLocked(old)
+		}
+		r.emails[emailKey(u.TenantID, u.Email)] = u.ID
+	}
+	s.users[u.ID] = u
+}
+
+// removeLocked deletes a user and its index entry. Callers must hold the
+// user's shard lock and emailMu for writing.
+func (r *Repository) removeLocked(id string) {
+	s := r.shardFor(id)
+	if old, ok := s.users[id]; ok {
+		r.unindexLocked(old)
+		delete(s.users, id)
+	}
+}
+
+// unindexLocked drops u's email entry unless another user has since claimed it.
+func (r *Repository) unindexLocked(u *User) {
+	key := emailKey(u.TenantID, u.Email)
+	if r.emails[key] == u.ID {
+		delete(r.emails, key)
+	}
+}
+
+// emailTakenLocked reports whether email belongs to a user of the tenant
+// other than selfID. Callers must hold emailMu.
+func (r *Repository) emailTakenLocked(tenantID, email, selfID string) bool {
+	owner, ok := r.emails[emailKey(tenantID, email)]
+	return ok && owner != selfID
+}
+
+// emailKey is the email index key: emails are unique per tenant.
+func emailKey(tenantID, email string) string {
+	return tenantID + "\x00" + NormalizeEmail(email)
+}
+
+// NormalizeEmail returns the canonical form used for uniqueness checks and
+// lookups: surrounding whitespace trimmed and case folded.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// persist hands a transaction's ops to the journal, if any. Callers must
+// hold the transaction's locks and roll it back if persist fails.
+func (r *Repository) persist(ops ...walOp) error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.append(ops...)
+}
+
+// afterWrite gives the journal a chance to do housekeeping that needs the
+// whole store. It is called once a write has released all of its locks.
+func (r *Repository) afterWrite() {
+	if r.journal != nil {
+		r.journal.compactIfDue()
+	}
+}
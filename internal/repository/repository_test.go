@@ -0,0 +1,74 @@
+package repository_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/repository/repotest"
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.RunUserStore(t, func(t *testing.T) repository.Store {
+		return repository.New()
+	})
+}
+
+func TestPasswordHasher(t *testing.T) {
+	type hashingStore interface {
+		repository.Store
+		SetPasswordHasher(h *password.Hasher)
+	}
+	stores := map[string]func(t *testing.T) hashingStore{
+		"memory": func(t *testing.T) hashingStore { return repository.New() },
+		"sql": func(t *testing.T) hashingStore {
+			db := openSQLite(t)
+			if _, err := repository.MigrateUp(context.Background(), db); err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+			return repository.NewSQLStore(db)
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			s.SetPasswordHasher(password.New(password.Bcrypt{Cost: bcrypt.MinCost}))
+
+			ann, err := s.CreateUserWithPassword(ctx, "Ann", "ann@example.com", "secret123")
+			if err != nil {
+				t.Fatalf("CreateUserWithPassword: %v", err)
+			}
+			var bob *repository.User
+			err = s.WithTx(ctx, func(tx repository.Tx) error {
+				bob, err = tx.CreateUserWithPassword(ctx, "Bob", "bob@example.com", "secret123")
+				return err
+			})
+			if err != nil {
+				t.Fatalf("CreateUserWithPassword in a transaction: %v", err)
+			}
+
+			for _, u := range []*repository.User{ann, bob} {
+				if !strings.HasPrefix(u.Password, "$2a$") {
+					t.Errorf("%s: expected a bcrypt hash from the configured hasher, got %q", u.Name, u.Password)
+				}
+				if !s.CheckPassword(u.Password, "secret123") {
+					t.Errorf("%s: expected the password to match", u.Name)
+				}
+			}
+			// The configured hasher only knows bcrypt.
+			argon, err := repository.HashPassword("secret123")
+			if err != nil {
+				t.Fatalf("HashPassword: %v", err)
+			}
+			if s.CheckPassword(argon, "secret123") {
+				t.Error("expected CheckPassword to verify with the configured hasher")
+			}
+		})
+	}
+}
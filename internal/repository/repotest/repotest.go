@@ -0,0 +1,1567 @@
+// Package repotest provides a conformance suite for repository.Store
+// implementations. Every backend should run it from its own tests:
+//
+//	func TestMyStore(t *testing.T) {
+//	    repotest.RunUserStore(t, func(t *testing.T) repository.Store {
+//	        return mystore.New(...)
+//	    })
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// Factory returns a fresh, empty store for a single subtest.
+type Factory func(t *testing.T) repository.Store
+
+// RunUserStore runs the full conformance suite against the store returned by newStore.
+func RunUserStore(t *testing.T, newStore Factory) {
+	t.Run("CreateAndGet", func(t *testing.T) { testCreateAndGet(t, newStore(t)) })
+	t.Run("GetNotFound", func(t *testing.T) { testGetNotFound(t, newStore(t)) })
+	t.Run("GetByEmail", func(t *testing.T) { testGetByEmail(t, newStore(t)) })
+	t.Run("CreateWithPassword", func(t *testing.T) { testCreateWithPassword(t, newStore(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, newStore(t)) })
+	t.Run("ListPagination", func(t *testing.T) { testListPagination(t, newStore(t)) })
+	t.Run("ListFilters", func(t *testing.T) { testListFilters(t, newStore(t)) })
+	t.Run("Metadata", func(t *testing.T) { testMetadata(t, newStore(t)) })
+	t.Run("Roles", func(t *testing.T) { testRoles(t, newStore(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newStore(t)) })
+	t.Run("UpdateNotFound", func(t *testing.T) { testUpdateNotFound(t, newStore(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStore(t)) })
+	t.Run("EmailUniqueness", func(t *testing.T) { testEmailUniqueness(t, newStore(t)) })
+	t.Run("SoftDelete", func(t *testing.T) { testSoftDelete(t, newStore(t)) })
+	t.Run("Purge", func(t *testing.T) { testPurge(t, newStore(t)) })
+	t.Run("Erasure", func(t *testing.T) { testErasure(t, newStore(t)) })
+	t.Run("ErasureAudit", func(t *testing.T) { testErasureAudit(t, newStore(t)) })
+	t.Run("Versioning", func(t *testing.T) { testVersioning(t, newStore(t)) })
+	t.Run("TxCommit", func(t *testing.T) { testTxCommit(t, newStore(t)) })
+	t.Run("TxRollback", func(t *testing.T) { testTxRollback(t, newStore(t)) })
+	t.Run("TxPanic", func(t *testing.T) { testTxPanic(t, newStore(t)) })
+	t.Run("ReturnsCopies", func(t *testing.T) { testReturnsCopies(t, newStore(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, newStore(t)) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, newStore(t)) })
+	t.Run("ConsumerOffsets", func(t *testing.T) { testConsumerOffsets(t, newStore(t)) })
+	t.Run("Audit", func(t *testing.T) { testAudit(t, newStore(t)) })
+	t.Run("Organizations", func(t *testing.T) { testOrganizations(t, newStore(t)) })
+	t.Run("RefreshTokens", func(t *testing.T) { testRefreshTokens(t, newStore(t)) })
+	t.Run("AccessTokenRevocation", func(t *testing.T) { testAccessTokenRevocation(t, newStore(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, newStore(t)) })
+}
+
+func testCreateAndGet(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	created, err := s.CreateUser(ctx, "Alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if created.ID == "" {
+		t.Fatal("expected generated ID")
+	}
+	if created.CreatedAt.IsZero() || created.UpdatedAt.IsZero() {
+		t.Error("expected timestamps to be set")
+	}
+
+	got, err := s.GetUser(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Name != "Alice" || got.Email != "alice@example.com" {
+		t.Errorf("unexpected user: %+v", got)
+	}
+}
+
+func testGetNotFound(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	if _, err := s.GetUser(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUser: expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "missing@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserByEmail: expected ErrNotFound, got %v", err)
+	}
+	if err := s.DeleteUser(ctx, "missing", 0); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteUser: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testGetByEmail(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	created := mustCreate(t, s, "Bob", "bob@example.com")
+	mustCreate(t, s, "Carol", "carol@example.com")
+
+	got, err := s.GetUserByEmail(ctx, "bob@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if got.ID != created.ID {
+		t.Errorf("expected ID %q, got %q", created.ID, got.ID)
+	}
+}
+
+func testCreateWithPassword(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	user, err := s.CreateUserWithPassword(ctx, "Dave", "dave@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("CreateUserWithPassword: %v", err)
+	}
+	if user.Password == "" || user.Password == "secret123" {
+		t.Fatal("expected password to be stored hashed")
+	}
+
+	got, err := s.GetUserByEmail(ctx, "dave@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if !s.CheckPassword(got.Password, "secret123") {
+		t.Error("expected stored hash to match password")
+	}
+	if s.CheckPassword(got.Password, "wrong") {
+		t.Error("expected stored hash to reject wrong password")
+	}
+
+	_, err = s.CreateUserWithPassword(ctx, "Dave Again", "dave@example.com", "secret456")
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict for duplicate email, got %v", err)
+	}
+
+	hash, err := repository.HashPassword("secret789")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if _, err := s.CreateUserWithHash(ctx, "Dave Again", "DAVE@example.com", hash); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("CreateUserWithHash: expected ErrConflict for duplicate email, got %v", err)
+	}
+	erin, err := s.CreateUserWithHash(ctx, "Erin", "erin@example.com", hash)
+	if err != nil {
+		t.Fatalf("CreateUserWithHash: %v", err)
+	}
+	if erin.Password != hash {
+		t.Error("expected CreateUserWithHash to store the hash as given")
+	}
+
+	rehashed, err := repository.HashPassword("secret789")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	updated, err := s.UpdateUser(ctx, erin.ID, repository.UserUpdate{PasswordHash: rehashed})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if got, _ := s.GetUser(ctx, erin.ID); got.Password != rehashed || updated.Name != "Erin" {
+		t.Errorf("expected only the password hash to change, got %+v", got)
+	}
+}
+
+func testList(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	page, err := s.ListUsers(ctx, repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(page.Users) != 0 || page.Total != 0 {
+		t.Fatalf("expected empty store, got %d users", len(page.Users))
+	}
+
+	a := mustCreate(t, s, "Erin", "erin@example.com")
+	b := mustCreate(t, s, "Frank", "frank@example.com")
+
+	page, err = s.ListUsers(ctx, repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if len(page.Users) != 2 || page.Total != 2 {
+		t.Fatalf("expected 2 users, got %d (total %d)", len(page.Users), page.Total)
+	}
+	seen := map[string]bool{page.Users[0].ID: true, page.Users[1].ID: true}
+	if !seen[a.ID] || !seen[b.ID] {
+		t.Errorf("expected both users in listing, got %+v", page.Users)
+	}
+	if page.Users[1].CreatedAt.Before(page.Users[0].CreatedAt) {
+		t.Errorf("expected default order by created_at, got %+v", page.Users)
+	}
+	if page.Page != 1 || page.PerPage != repository.DefaultPerPage {
+		t.Errorf("expected page 1 of size %d, got page %d of size %d", repository.DefaultPerPage, page.Page, page.PerPage)
+	}
+}
+
+func testListPagination(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	for _, name := range []string{"Uma", "Quinn", "Sam", "Rita", "Tom"} {
+		mustCreate(t, s, name, strings.ToLower(name)+"@example.com")
+	}
+	want := []string{"Quinn", "Rita", "Sam", "Tom", "Uma"}
+
+	opts := repository.ListOptions{Sort: repository.SortName, PerPage: 2}
+
+	// Numbered pages.
+	var got []string
+	for p := 1; p <= 3; p++ {
+		opts.Page = p
+		page, err := s.ListUsers(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListUsers page %d: %v", p, err)
+		}
+		if page.Total != 5 {
+			t.Errorf("page %d: expected total 5, got %d", p, page.Total)
+		}
+		got = append(got, names(page.Users)...)
+	}
+	assertNames(t, "numbered pages", got, want)
+
+	// Forward through cursors.
+	opts.Page = 0
+	got = nil
+	var last *repository.UserPage
+	for cursor := ""; ; {
+		opts.Cursor = cursor
+		page, err := s.ListUsers(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListUsers cursor %q: %v", cursor, err)
+		}
+		got = append(got, names(page.Users)...)
+		last = page
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assertNames(t, "forward cursors", got, want)
+
+	// And back again from the last page.
+	got = names(last.Users)
+	for cursor := last.PrevCursor; cursor != ""; {
+		opts.Cursor = cursor
+		page, err := s.ListUsers(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListUsers cursor %q: %v", cursor, err)
+		}
+		got = append(names(page.Users), got...)
+		cursor = page.PrevCursor
+	}
+	assertNames(t, "backward cursors", got, want)
+
+	// Descending order.
+	page, err := s.ListUsers(ctx, repository.ListOptions{Sort: repository.SortName, Desc: true, PerPage: 2})
+	if err != nil {
+		t.Fatalf("ListUsers desc: %v", err)
+	}
+	assertNames(t, "descending", names(page.Users), []string{"Uma", "Tom"})
+
+	// A cursor only makes sense for the ordering it was issued for.
+	_, err = s.ListUsers(ctx, repository.ListOptions{Sort: repository.SortEmail, Cursor: page.NextCursor})
+	if !errors.Is(err, repository.ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for mismatched sort, got %v", err)
+	}
+	_, err = s.ListUsers(ctx, repository.ListOptions{Cursor: "not-a-cursor"})
+	if !errors.Is(err, repository.ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for garbage, got %v", err)
+	}
+	_, err = s.ListUsers(ctx, repository.ListOptions{Sort: "password"})
+	if !errors.Is(err, repository.ErrInvalidSort) {
+		t.Errorf("expected ErrInvalidSort, got %v", err)
+	}
+
+	// A page far past the end is empty, however far: its offset must not
+	// overflow back to the start.
+	page, err = s.ListUsers(ctx, repository.ListOptions{Page: 100000000000000000, PerPage: repository.MaxPerPage})
+	if err != nil {
+		t.Fatalf("ListUsers huge page: %v", err)
+	}
+	if len(page.Users) != 0 || page.Total != 5 || page.Page != repository.MaxPage {
+		t.Errorf("expected page %d to be empty, got page %d with %v", repository.MaxPage, page.Page, names(page.Users))
+	}
+}
+
+func testListFilters(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	mustCreate(t, s, "Alice Smith", "alice@acme.test")
+	bob := mustCreate(t, s, "Bob Jones", "bob@ACME.test")
+	mustCreate(t, s, "Carol Smithers", "carol@other.test")
+	mustCreate(t, s, "Dan Smith", "dan@sub.acme.test")
+	mustCreate(t, s, "Élodie Durand", "elodie@other.test")
+
+	list := func(opts repository.ListOptions) []string {
+		t.Helper()
+		opts.Sort = repository.SortName
+		page, err := s.ListUsers(ctx, opts)
+		if err != nil {
+			t.Fatalf("ListUsers(%+v): %v", opts, err)
+		}
+		if page.Total != int64(len(page.Users)) {
+			t.Errorf("expected total %d to match filtered count, got %d", len(page.Users), page.Total)
+		}
+		return names(page.Users)
+	}
+
+	assertNames(t, "email domain", list(repository.ListOptions{EmailDomain: "Acme.Test"}),
+		[]string{"Alice Smith", "Bob Jones"})
+	assertNames(t, "name substring", list(repository.ListOptions{NameContains: "SMITH"}),
+		[]string{"Alice Smith", "Carol Smithers", "Dan Smith"})
+	// Case is folded beyond ASCII too.
+	assertNames(t, "non-ASCII name substring", list(repository.ListOptions{NameContains: "éLODIE"}),
+		[]string{"Élodie Durand"})
+	assertNames(t, "combined", list(repository.ListOptions{NameContains: "smith", EmailDomain: "acme.test"}),
+		[]string{"Alice Smith"})
+	assertNames(t, "created after", list(repository.ListOptions{CreatedAfter: bob.CreatedAt}),
+		[]string{"Bob Jones", "Carol Smithers", "Dan Smith", "Élodie Durand"})
+	assertNames(t, "created before", list(repository.ListOptions{CreatedBefore: bob.CreatedAt}),
+		[]string{"Alice Smith"})
+}
+
+func testMetadata(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	ann, err := s.InsertUser(ctx, repository.NewUser{
+		Name:     "Ann",
+		Email:    "ann@example.com",
+		Metadata: map[string]any{"plan": "pro", "seats": 5, "beta": true, "tags": []string{"a"}},
+	})
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if ann.Version != 1 || ann.Metadata["plan"] != "pro" || ann.Metadata["seats"] != float64(5) {
+		t.Errorf("expected version 1 with JSON-typed metadata, got %+v", ann)
+	}
+	if _, err := s.InsertUser(ctx, repository.NewUser{
+		Name:     "Bob",
+		Email:    "bob@example.com",
+		Metadata: map[string]any{"plan": "free", "seats": 5.0, "beta": false},
+	}); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	mustCreate(t, s, "Carol", "carol@example.com")
+
+	got, err := s.GetUser(ctx, ann.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if tags, _ := got.Metadata["tags"].([]any); len(tags) != 1 || tags[0] != "a" || got.Metadata["beta"] != true {
+		t.Errorf("expected metadata to round-trip, got %#v", got.Metadata)
+	}
+
+	list := func(filter map[string]string) []string {
+		t.Helper()
+		page, err := s.ListUsers(ctx, repository.ListOptions{Metadata: filter, Sort: repository.SortName})
+		if err != nil {
+			t.Fatalf("ListUsers(%v): %v", filter, err)
+		}
+		if page.Total != int64(len(page.Users)) {
+			t.Errorf("expected total %d to match filtered count, got %d", len(page.Users), page.Total)
+		}
+		return names(page.Users)
+	}
+	assertNames(t, "string", list(map[string]string{"plan": "pro"}), []string{"Ann"})
+	assertNames(t, "number", list(map[string]string{"seats": "5.0"}), []string{"Ann", "Bob"})
+	assertNames(t, "bool", list(map[string]string{"beta": "false"}), []string{"Bob"})
+	assertNames(t, "combined", list(map[string]string{"seats": "5", "beta": "true"}), []string{"Ann"})
+	assertNames(t, "missing key", list(map[string]string{"region": "eu"}), nil)
+	assertNames(t, "array", list(map[string]string{"tags": "a"}), nil)
+
+	if _, err := s.ListUsers(ctx, repository.ListOptions{Metadata: map[string]string{"a.b": "x"}}); !errors.Is(err, repository.ErrInvalidMetadataKey) {
+		t.Errorf("expected ErrInvalidMetadataKey, got %v", err)
+	}
+
+	// Updates replace metadata wholesale; an empty map clears it and nil
+	// leaves it alone.
+	updated, err := s.UpdateUser(ctx, ann.ID, repository.UserUpdate{Metadata: map[string]any{"plan": "free"}})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if len(updated.Metadata) != 1 || updated.Metadata["plan"] != "free" {
+		t.Errorf("expected metadata to be replaced, got %#v", updated.Metadata)
+	}
+	if updated, _ = s.UpdateUser(ctx, ann.ID, repository.UserUpdate{Name: "Anne"}); updated.Metadata["plan"] != "free" {
+		t.Errorf("expected a name change to keep metadata, got %#v", updated.Metadata)
+	}
+	if _, err := s.UpdateUser(ctx, ann.ID, repository.UserUpdate{Metadata: map[string]any{}}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if got, _ := s.GetUser(ctx, ann.ID); got.Metadata != nil {
+		t.Errorf("expected metadata to be cleared, got %#v", got.Metadata)
+	}
+
+	if _, err := s.InsertUser(ctx, repository.NewUser{
+		Name:     "Dan",
+		Email:    "dan@example.com",
+		Metadata: map[string]any{"bad": func() {}},
+	}); !errors.Is(err, repository.ErrInvalidMetadata) {
+		t.Errorf("expected ErrInvalidMetadata, got %v", err)
+	}
+}
+
+func testRoles(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+	u, err := s.InsertUser(ctx, repository.NewUser{Name: "Alice", Email: "alice@example.com", Roles: []string{"admin"}})
+	if err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	if !slices.Equal(u.Roles, []string{"admin"}) {
+		t.Errorf("expected roles [admin], got %v", u.Roles)
+	}
+	if plain := mustCreate(t, s, "Bob", "bob@example.com"); plain.Roles != nil {
+		t.Errorf("expected no roles, got %v", plain.Roles)
+	}
+
+	updated, err := s.UpdateUser(ctx, u.ID, repository.UserUpdate{Roles: []string{"member", "admin"}})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if !slices.Equal(updated.Roles, []string{"member", "admin"}) {
+		t.Errorf("expected roles [member admin], got %v", updated.Roles)
+	}
+	if got, _ := s.UpdateUser(ctx, u.ID, repository.UserUpdate{Name: "Alicia"}); !slices.Equal(got.Roles, []string{"member", "admin"}) {
+		t.Errorf("expected other updates to keep the roles, got %v", got.Roles)
+	}
+	cleared, err := s.UpdateUser(ctx, u.ID, repository.UserUpdate{Roles: []string{}})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if got, _ := s.GetUser(ctx, u.ID); cleared.Roles != nil || got.Roles != nil {
+		t.Errorf("expected an empty slice to remove the roles, got %v and %v", cleared.Roles, got.Roles)
+	}
+}
+
+func testUpdate(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	created := mustCreate(t, s, "Grace", "grace@example.com")
+
+	updated, err := s.UpdateUser(ctx, created.ID, repository.UserUpdate{Name: "Grace Hopper"})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if updated.Name != "Grace Hopper" {
+		t.Errorf("expected name to change, got %q", updated.Name)
+	}
+	if updated.Email != "grace@example.com" {
+		t.Errorf("expected empty email to leave field unchanged, got %q", updated.Email)
+	}
+
+	got, err := s.GetUser(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Name != "Grace Hopper" {
+		t.Errorf("expected update to be persisted, got %q", got.Name)
+	}
+	if got.UpdatedAt.Before(created.UpdatedAt) {
+		t.Error("expected UpdatedAt to move forward")
+	}
+}
+
+func testUpdateNotFound(t *testing.T, s repository.UserStore) {
+	_, err := s.UpdateUser(context.Background(), "missing", repository.UserUpdate{Name: "Name"})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func testDelete(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	created := mustCreate(t, s, "Heidi", "heidi@example.com")
+
+	if err := s.DeleteUser(ctx, created.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.GetUser(ctx, created.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := s.DeleteUser(ctx, created.ID, 0); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound on second delete, got %v", err)
+	}
+}
+
+func testEmailUniqueness(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	ivan := mustCreate(t, s, "Ivan", "Ivan@Example.com")
+	judy := mustCreate(t, s, "Judy", "judy@example.com")
+
+	got, err := s.GetUserByEmail(ctx, "  IVAN@example.COM ")
+	if err != nil {
+		t.Fatalf("GetUserByEmail should be case-insensitive: %v", err)
+	}
+	if got.ID != ivan.ID {
+		t.Errorf("expected ID %q, got %q", ivan.ID, got.ID)
+	}
+
+	if _, err := s.CreateUser(ctx, "Ivan 2", "ivan@example.com"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("CreateUser: expected ErrConflict, got %v", err)
+	}
+	if _, err := s.CreateUserWithPassword(ctx, "Ivan 3", "IVAN@EXAMPLE.COM", "secret123"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("CreateUserWithPassword: expected ErrConflict, got %v", err)
+	}
+	if _, err := s.UpdateUser(ctx, judy.ID, repository.UserUpdate{Email: "ivan@example.com"}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("UpdateUser: expected ErrConflict, got %v", err)
+	}
+
+	// Re-casing your own address is not a conflict.
+	if _, err := s.UpdateUser(ctx, ivan.ID, repository.UserUpdate{Email: "ivan@example.com"}); err != nil {
+		t.Errorf("UpdateUser to own email: %v", err)
+	}
+
+	// Moving away from an address frees it for others.
+	if _, err := s.UpdateUser(ctx, ivan.ID, repository.UserUpdate{Email: "ivan@new.example.com"}); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "ivan@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected old email to be released, got %v", err)
+	}
+	if _, err := s.UpdateUser(ctx, judy.ID, repository.UserUpdate{Email: "ivan@example.com"}); err != nil {
+		t.Errorf("expected released email to be reusable, got %v", err)
+	}
+
+	// A soft-deleted user keeps its address until purged.
+	if err := s.DeleteUser(ctx, ivan.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.CreateUser(ctx, "Ivan 4", "ivan@new.example.com"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected deleted user's email to stay reserved, got %v", err)
+	}
+	if _, err := s.PurgeDeletedUsers(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if _, err := s.CreateUser(ctx, "Ivan 4", "ivan@new.example.com"); err != nil {
+		t.Errorf("expected purged user's email to be reusable, got %v", err)
+	}
+}
+
+func testSoftDelete(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	kim := mustCreate(t, s, "Kim", "kim@example.com")
+	mustCreate(t, s, "Lee", "lee@example.com")
+
+	if err := s.DeleteUser(ctx, kim.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "kim@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUserByEmail: expected deleted user to be hidden, got %v", err)
+	}
+	if _, err := s.UpdateUser(ctx, kim.ID, repository.UserUpdate{Name: "Kim 2"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateUser: expected ErrNotFound for deleted user, got %v", err)
+	}
+
+	page, err := s.ListUsers(ctx, repository.ListOptions{Sort: repository.SortName})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	assertNames(t, "default listing", names(page.Users), []string{"Lee"})
+
+	page, err = s.ListUsers(ctx, repository.ListOptions{Sort: repository.SortName, IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	assertNames(t, "listing with deleted", names(page.Users), []string{"Kim", "Lee"})
+	if page.Users[0].DeletedAt == nil || page.Users[1].DeletedAt != nil {
+		t.Errorf("expected only Kim to carry DeletedAt, got %+v", page.Users)
+	}
+
+	restored, err := s.RestoreUser(ctx, kim.ID)
+	if err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if restored.IsDeleted() {
+		t.Error("expected restored user to be active")
+	}
+	if _, err := s.GetUser(ctx, kim.ID); err != nil {
+		t.Errorf("GetUser after restore: %v", err)
+	}
+	if _, err := s.RestoreUser(ctx, kim.ID); err != nil {
+		t.Errorf("expected restoring an active user to be a no-op, got %v", err)
+	}
+	if _, err := s.RestoreUser(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound restoring unknown user, got %v", err)
+	}
+}
+
+func testPurge(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	old := mustCreate(t, s, "Old", "old@example.com")
+	active := mustCreate(t, s, "Active", "active@example.com")
+	if err := s.DeleteUser(ctx, old.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	// Nothing was deleted before an hour ago.
+	n, err := s.PurgeDeletedUsers(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected nothing purged inside the retention window, got %d", n)
+	}
+
+	n, err = s.PurgeDeletedUsers(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("PurgeDeletedUsers: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 user purged, got %d", n)
+	}
+	if _, err := s.RestoreUser(ctx, old.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected purged user to be gone for good, got %v", err)
+	}
+	if _, err := s.GetUser(ctx, active.ID); err != nil {
+		t.Errorf("expected active user to survive purge, got %v", err)
+	}
+}
+
+func testErasure(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	ann := mustCreate(t, s, "Ann", "ann@example.com")
+	bob := mustCreate(t, s, "Bob", "bob@example.com")
+	soon := time.Now().Add(time.Hour)
+
+	scheduled, err := s.ScheduleErasure(ctx, ann.ID, &soon)
+	if err != nil {
+		t.Fatalf("ScheduleErasure: %v", err)
+	}
+	if scheduled.EraseAt == nil || !scheduled.EraseAt.Equal(soon) || scheduled.Version != ann.Version+1 {
+		t.Errorf("expected the erasure time to be set and the version bumped, got %+v", scheduled)
+	}
+	if _, err := s.ScheduleErasure(ctx, bob.ID, &soon); err != nil {
+		t.Fatalf("ScheduleErasure: %v", err)
+	}
+	cancelled, err := s.ScheduleErasure(ctx, bob.ID, nil)
+	if err != nil {
+		t.Fatalf("ScheduleErasure(nil): %v", err)
+	}
+	if cancelled.EraseAt != nil {
+		t.Errorf("expected the erasure to be cancelled, got %v", cancelled.EraseAt)
+	}
+	if _, err := s.ScheduleErasure(ctx, "missing", &soon); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound scheduling an unknown user, got %v", err)
+	}
+
+	ids, err := s.EraseDueUsers(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("EraseDueUsers: %v", err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("expected nothing erased before its time, got %v", ids)
+	}
+	if ids, err = s.EraseDueUsers(ctx, soon.Add(time.Minute)); err != nil {
+		t.Fatalf("EraseDueUsers: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != ann.ID {
+		t.Fatalf("expected only Ann to be erased, got %v", ids)
+	}
+
+	if _, err := s.GetUser(ctx, ann.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected an erased user to be hidden, got %v", err)
+	}
+	page, err := s.ListUsers(ctx, repository.ListOptions{IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	for _, u := range page.Users {
+		if u.ID != ann.ID {
+			continue
+		}
+		if u.Name != repository.ErasedName || u.Email == ann.Email || u.EraseAt != nil || !u.IsDeleted() {
+			t.Errorf("expected Ann to be anonymized, got %+v", u)
+		}
+	}
+	if _, err := s.GetUserByEmail(ctx, ann.Email); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the erased email to be gone, got %v", err)
+	}
+	if _, err := s.CreateUser(ctx, "New Ann", ann.Email); err != nil {
+		t.Errorf("expected the erased email to be free again, got %v", err)
+	}
+	if ids, err = s.EraseDueUsers(ctx, soon.Add(time.Minute)); err != nil || len(ids) != 0 {
+		t.Errorf("expected erasure to happen once, got %v, %v", ids, err)
+	}
+	if _, err := s.GetUser(ctx, bob.ID); err != nil {
+		t.Errorf("expected Bob to survive, got %v", err)
+	}
+}
+
+func testErasureAudit(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+
+	ann := mustCreate(t, s, "Ann", "ann@example.com")
+	bob := mustCreate(t, s, "Bob", "bob@example.com")
+	// Each of them updated the other, from their own address.
+	actors := map[string]*repository.User{ann.ID: bob, bob.ID: ann}
+	ips := map[string]string{ann.ID: "192.0.2.1", bob.ID: "192.0.2.2"}
+	err := s.WithTx(ctx, func(tx repository.Tx) error {
+		for _, u := range []*repository.User{ann, bob} {
+			actor := actors[u.ID]
+			err := tx.AppendAudit(ctx, repository.AuditEntry{
+				ActorID:   actor.ID,
+				RequestID: "req-" + actor.Name,
+				IP:        ips[actor.ID],
+				Action:    "update",
+				TargetID:  u.ID,
+				Changes: []repository.FieldChange{
+					{Field: "name", To: u.Name},
+					{Field: "email", From: "old@example.com", To: u.Email},
+					{Field: "metadata.phone", To: `"555-0100"`},
+					{Field: "version", From: "1", To: "2"},
+				},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AppendAudit: %v", err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	for _, u := range []*repository.User{ann, bob} {
+		_, err := s.CreateRefreshToken(ctx, repository.RefreshToken{UserID: u.ID, TokenHash: "hash-" + u.Name, ExpiresAt: expires})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+	}
+
+	soon := time.Now().Add(time.Hour)
+	if _, err := s.ScheduleErasure(ctx, ann.ID, &soon); err != nil {
+		t.Fatalf("ScheduleErasure: %v", err)
+	}
+	if _, err := s.EraseDueUsers(ctx, soon.Add(time.Minute)); err != nil {
+		t.Fatalf("EraseDueUsers: %v", err)
+	}
+
+	entry := func(target string) repository.AuditEntry {
+		t.Helper()
+		entries, _, err := s.ListAudit(ctx, repository.AuditFilter{TargetID: target})
+		if err != nil {
+			t.Fatalf("ListAudit: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 entry about %s, got %+v", target, entries)
+		}
+		return entries[0]
+	}
+	changes := func(target string) []repository.FieldChange {
+		t.Helper()
+		return entry(target).Changes
+	}
+	erased := repository.ErasedValue
+	want := []repository.FieldChange{
+		{Field: "name", To: erased},
+		{Field: "email", From: erased, To: erased},
+		{Field: "metadata.phone", To: erased},
+		{Field: "version", From: "1", To: "2"},
+	}
+	if got := changes(ann.ID); !slices.Equal(got, want) {
+		t.Errorf("expected Ann's personal data to be erased from the audit log, got %+v", got)
+	}
+	if got := changes(bob.ID); got[0].To != "Bob" || got[1].To != bob.Email {
+		t.Errorf("expected Bob's audit entry to be kept, got %+v", got)
+	}
+
+	// Where Ann was the actor, only her ID remains; Bob's address is his.
+	if got := entry(bob.ID); got.ActorID != ann.ID || got.IP != "" || got.RequestID != "" {
+		t.Errorf("expected Ann's address and request to be erased, got %+v", got)
+	}
+	if got := entry(ann.ID); got.ActorID != bob.ID || got.IP != ips[bob.ID] || got.RequestID != "req-Bob" {
+		t.Errorf("expected Bob's address and request to be kept, got %+v", got)
+	}
+
+	if tokens, err := s.ListRefreshTokens(ctx, ann.ID); err != nil || len(tokens) != 0 {
+		t.Errorf("expected Ann's refresh tokens to be deleted, got %+v, %v", tokens, err)
+	}
+	if tokens, err := s.ListRefreshTokens(ctx, bob.ID); err != nil || len(tokens) != 1 {
+		t.Errorf("expected Bob's refresh token to be kept, got %+v, %v", tokens, err)
+	}
+}
+
+func testVersioning(t *testing.T, s repository.UserStore) {
+	ctx := context.Background()
+
+	mia := mustCreate(t, s, "Mia", "mia@example.com")
+	if mia.Version != 1 {
+		t.Fatalf("expected new user at version 1, got %d", mia.Version)
+	}
+
+	updated, err := s.UpdateUser(ctx, mia.ID, repository.UserUpdate{Name: "Mia 2", IfVersion: 1})
+	if err != nil {
+		t.Fatalf("UpdateUser with current version: %v", err)
+	}
+	if updated.Version != 2 {
+		t.Errorf("expected version 2 after update, got %d", updated.Version)
+	}
+
+	// A writer still holding version 1 must not clobber the change.
+	_, err = s.UpdateUser(ctx, mia.ID, repository.UserUpdate{Name: "Stale", IfVersion: 1})
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("UpdateUser with stale version: expected ErrVersionMismatch, got %v", err)
+	}
+	if err := s.DeleteUser(ctx, mia.ID, 1); !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("DeleteUser with stale version: expected ErrVersionMismatch, got %v", err)
+	}
+	got, err := s.GetUser(ctx, mia.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Name != "Mia 2" || got.Version != 2 {
+		t.Errorf("expected stale writes to be rejected, got %+v", got)
+	}
+
+	// Without a precondition the write always applies.
+	updated, err = s.UpdateUser(ctx, mia.ID, repository.UserUpdate{Name: "Mia 3"})
+	if err != nil {
+		t.Fatalf("UpdateUser without precondition: %v", err)
+	}
+	if updated.Version != 3 {
+		t.Errorf("expected version 3, got %d", updated.Version)
+	}
+
+	if err := s.DeleteUser(ctx, mia.ID, 3); err != nil {
+		t.Fatalf("DeleteUser with current version: %v", err)
+	}
+	restored, err := s.RestoreUser(ctx, mia.ID)
+	if err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if restored.Version != 5 {
+		t.Errorf("expected delete and restore to bump the version to 5, got %d", restored.Version)
+	}
+
+	if _, err := s.UpdateUser(ctx, "missing", repository.UserUpdate{Name: "X", IfVersion: 1}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected ErrNotFound to win over version checks, got %v", err)
+	}
+}
+
+func testTxCommit(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+	nick := mustCreate(t, s, "Nick", "nick@example.com")
+
+	var olive *repository.User
+	err := s.WithTx(ctx, func(tx repository.Tx) error {
+		var err error
+		if olive, err = tx.CreateUser(ctx, "Olive", "olive@example.com"); err != nil {
+			return err
+		}
+		// Reads inside the transaction see its own writes.
+		if _, err := tx.GetUserByEmail(ctx, "OLIVE@example.com"); err != nil {
+			t.Errorf("GetUserByEmail inside tx: %v", err)
+		}
+		if _, err := tx.UpdateUser(ctx, nick.ID, repository.UserUpdate{Name: "Nicholas"}); err != nil {
+			return err
+		}
+		return tx.DeleteUser(ctx, olive.ID, 0)
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	got, err := s.GetUser(ctx, nick.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Name != "Nicholas" || got.Version != 2 {
+		t.Errorf("expected committed update, got %+v", got)
+	}
+	if _, err := s.GetUser(ctx, olive.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected user deleted in tx to stay deleted, got %v", err)
+	}
+	if _, err := s.RestoreUser(ctx, olive.ID); err != nil {
+		t.Errorf("expected user created in tx to exist after commit, got %v", err)
+	}
+}
+
+func testTxRollback(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+	paul := mustCreate(t, s, "Paul", "paul@example.com")
+	quinn := mustCreate(t, s, "Quinn", "quinn@example.com")
+
+	errAbort := errors.New("abort")
+	err := s.WithTx(ctx, func(tx repository.Tx) error {
+		if _, err := tx.CreateUser(ctx, "Rita", "rita@example.com"); err != nil {
+			return err
+		}
+		if _, err := tx.UpdateUser(ctx, paul.ID, repository.UserUpdate{Name: "Pablo", Email: "pablo@example.com"}); err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(ctx, quinn.ID, 0); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected WithTx to return fn's error, got %v", err)
+	}
+
+	if _, err := s.GetUserByEmail(ctx, "rita@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected create to be rolled back, got %v", err)
+	}
+	got, err := s.GetUser(ctx, paul.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Name != "Paul" || got.Email != "paul@example.com" || got.Version != 1 {
+		t.Errorf("expected update to be rolled back, got %+v", got)
+	}
+	if _, err := s.GetUser(ctx, quinn.ID); err != nil {
+		t.Errorf("expected delete to be rolled back, got %v", err)
+	}
+
+	// The email index must be rolled back too.
+	if _, err := s.CreateUser(ctx, "Other Paul", "paul@example.com"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected original email to stay reserved, got %v", err)
+	}
+	mustCreate(t, s, "Pablo", "pablo@example.com")
+	mustCreate(t, s, "Rita", "rita@example.com")
+}
+
+func testTxPanic(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic to propagate out of WithTx")
+			}
+		}()
+		_ = s.WithTx(ctx, func(tx repository.Tx) error {
+			if _, err := tx.CreateUser(ctx, "Sam", "sam@example.com"); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	if _, err := s.GetUserByEmail(ctx, "sam@example.com"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected write to be rolled back after panic, got %v", err)
+	}
+	// The store must still be usable, i.e. no lock or transaction leaked.
+	mustCreate(t, s, "Sam", "sam@example.com")
+}
+
+func testReturnsCopies(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+	tina := mustCreate(t, s, "Tina", "tina@example.com")
+
+	// Scribble over everything the store hands out.
+	tina.Name = "changed"
+	if got, err := s.GetUser(ctx, tina.ID); err == nil {
+		got.Email = "changed@example.com"
+	}
+	if got, err := s.GetUserByEmail(ctx, "tina@example.com"); err == nil {
+		got.Version = 99
+	}
+	if page, err := s.ListUsers(ctx, repository.ListOptions{}); err == nil && len(page.Users) == 1 {
+		page.Users[0].Name = "changed"
+	}
+	if err := s.DeleteUser(ctx, tina.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if page, err := s.ListUsers(ctx, repository.ListOptions{IncludeDeleted: true}); err == nil && len(page.Users) == 1 {
+		*page.Users[0].DeletedAt = time.Time{}
+	}
+
+	got, err := s.RestoreUser(ctx, tina.ID)
+	if err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if got.Name != "Tina" || got.Email != "tina@example.com" || got.Version != 3 {
+		t.Errorf("expected store to be unaffected by caller mutations, got %+v", got)
+	}
+}
+
+// testConcurrent hammers every method from many goroutines. It is most
+// useful under go test -race, which flags any shared state the store leaks.
+func testConcurrent(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+	const workers, rounds = 8, 10
+
+	// Users deleted before the cutoff give the concurrent purges something
+	// to remove; the workers' own users are only ever deleted after it.
+	for w := 0; w < workers; w++ {
+		tmp := mustCreate(t, s, "Temp", fmt.Sprintf("temp%d@example.com", w))
+		if err := s.DeleteUser(ctx, tmp.ID, 0); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	cutoff := time.Now()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs <- concurrentWorker(ctx, s, w, rounds, cutoff)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	page, err := s.ListUsers(ctx, repository.ListOptions{PerPage: repository.MaxPerPage, IncludeDeleted: true})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if page.Total != workers {
+		t.Errorf("expected %d surviving users, got %d", workers, page.Total)
+	}
+	for _, u := range page.Users {
+		if want := int64(1 + 3*rounds); u.Version != want {
+			t.Errorf("%s: expected version %d, got %d", u.Email, want, u.Version)
+		}
+	}
+}
+
+// concurrentWorker owns one user and churns it while reading everyone else's.
+// Each round bumps the version three times: update, delete, restore.
+func concurrentWorker(ctx context.Context, s repository.Store, w, rounds int, cutoff time.Time) error {
+	email := fmt.Sprintf("worker%d@example.com", w)
+	u, err := s.CreateUser(ctx, fmt.Sprintf("Worker %d", w), email)
+	if err != nil {
+		return fmt.Errorf("worker %d: CreateUser: %w", w, err)
+	}
+
+	for i := 0; i < rounds; i++ {
+		if _, err := s.GetUser(ctx, u.ID); err != nil {
+			return fmt.Errorf("worker %d: GetUser: %w", w, err)
+		}
+		if _, err := s.GetUserByEmail(ctx, email); err != nil {
+			return fmt.Errorf("worker %d: GetUserByEmail: %w", w, err)
+		}
+		if _, err := s.ListUsers(ctx, repository.ListOptions{Sort: repository.SortName}); err != nil {
+			return fmt.Errorf("worker %d: ListUsers: %w", w, err)
+		}
+
+		err := s.WithTx(ctx, func(tx repository.Tx) error {
+			cur, err := tx.GetUser(ctx, u.ID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.UpdateUser(ctx, u.ID, repository.UserUpdate{
+				Name:      fmt.Sprintf("Worker %d round %d", w, i),
+				IfVersion: cur.Version,
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("worker %d: WithTx: %w", w, err)
+		}
+		if err := s.DeleteUser(ctx, u.ID, 0); err != nil {
+			return fmt.Errorf("worker %d: DeleteUser: %w", w, err)
+		}
+		if _, err := s.RestoreUser(ctx, u.ID); err != nil {
+			return fmt.Errorf("worker %d: RestoreUser: %w", w, err)
+		}
+		if _, err := s.PurgeDeletedUsers(ctx, cutoff); err != nil {
+			return fmt.Errorf("worker %d: PurgeDeletedUsers: %w", w, err)
+		}
+	}
+	return nil
+}
+
+func testOutbox(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+
+	if events, err := s.ReadEvents(ctx, 0, 0); err != nil || len(events) != 0 {
+		t.Fatalf("expected empty outbox, got %v, %v", events, err)
+	}
+
+	for i, name := range []string{"Uma", "Vic", "Wes"} {
+		err := s.WithTx(ctx, func(tx repository.Tx) error {
+			u, err := tx.CreateUser(ctx, name, strings.ToLower(name)+"@example.com")
+			if err != nil {
+				return err
+			}
+			return tx.AppendEvent(ctx, repository.Event{
+				Type:        "test.created",
+				AggregateID: u.ID,
+				Payload:     []byte(fmt.Sprintf(`{"n":%d}`, i)),
+			})
+		})
+		if err != nil {
+			t.Fatalf("WithTx: %v", err)
+		}
+	}
+
+	// A rolled back transaction takes its events with it.
+	errAbort := errors.New("abort")
+	err := s.WithTx(ctx, func(tx repository.Tx) error {
+		if err := tx.AppendEvent(ctx, repository.Event{Type: "test.aborted", AggregateID: "x", Payload: []byte(`{}`)}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected abort, got %v", err)
+	}
+
+	events, err := s.ReadEvents(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadEvents: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 committed events, got %d", len(events))
+	}
+	for i, e := range events {
+		if e.Offset != int64(i+1) {
+			t.Errorf("event %d: expected offset %d, got %d", i, i+1, e.Offset)
+		}
+		if e.Type != "test.created" || e.AggregateID == "" || e.CreatedAt.IsZero() {
+			t.Errorf("event %d: unexpected %+v", i, e)
+		}
+		if want := fmt.Sprintf(`{"n":%d}`, i); string(e.Payload) != want {
+			t.Errorf("event %d: expected payload %s, got %s", i, want, e.Payload)
+		}
+	}
+
+	tail, err := s.ReadEvents(ctx, 1, 1)
+	if err != nil {
+		t.Fatalf("ReadEvents: %v", err)
+	}
+	if len(tail) != 1 || tail[0].Offset != 2 {
+		t.Errorf("expected only offset 2 after 1 with limit 1, got %+v", tail)
+	}
+	if rest, _ := s.ReadEvents(ctx, 3, 0); len(rest) != 0 {
+		t.Errorf("expected nothing after the last offset, got %+v", rest)
+	}
+
+	// The next committed event continues the sequence without a gap.
+	err = s.WithTx(ctx, func(tx repository.Tx) error {
+		return tx.AppendEvent(ctx, repository.Event{Type: "test.more", AggregateID: "y", Payload: []byte(`{}`)})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if more, _ := s.ReadEvents(ctx, 3, 0); len(more) != 1 || more[0].Offset != 4 {
+		t.Errorf("expected offset 4 after a rolled back append, got %+v", more)
+	}
+}
+
+func testConsumerOffsets(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+
+	if off, err := s.ConsumerOffset(ctx, "mailer"); err != nil || off != 0 {
+		t.Fatalf("expected unknown consumer at 0, got %d, %v", off, err)
+	}
+	if err := s.SetConsumerOffset(ctx, "mailer", 7); err != nil {
+		t.Fatalf("SetConsumerOffset: %v", err)
+	}
+	if err := s.SetConsumerOffset(ctx, "search", 2); err != nil {
+		t.Fatalf("SetConsumerOffset: %v", err)
+	}
+	// Offsets may move backwards, which is how a replay starts.
+	if err := s.SetConsumerOffset(ctx, "mailer", 3); err != nil {
+		t.Fatalf("SetConsumerOffset: %v", err)
+	}
+	if off, _ := s.ConsumerOffset(ctx, "mailer"); off != 3 {
+		t.Errorf("expected mailer at 3, got %d", off)
+	}
+	if off, _ := s.ConsumerOffset(ctx, "search"); off != 2 {
+		t.Errorf("expected search at 2, got %d", off)
+	}
+}
+
+func testAudit(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+
+	appendAudit := func(actor, action, target string) {
+		t.Helper()
+		err := s.WithTx(ctx, func(tx repository.Tx) error {
+			return tx.AppendAudit(ctx, repository.AuditEntry{
+				ActorID:   actor,
+				RequestID: "req-" + action,
+				IP:        "192.0.2.1",
+				Action:    action,
+				TargetID:  target,
+				Changes:   []repository.FieldChange{{Field: "name", From: "a", To: "b"}},
+			})
+		})
+		if err != nil {
+			t.Fatalf("AppendAudit: %v", err)
+		}
+	}
+
+	appendAudit("admin", "create", "u1")
+	appendAudit("admin", "update", "u2")
+	time.Sleep(2 * time.Millisecond)
+	mid := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	appendAudit("u1", "update", "u1")
+	appendAudit("admin", "delete", "u1")
+
+	errAbort := errors.New("abort")
+	err := s.WithTx(ctx, func(tx repository.Tx) error {
+		if err := tx.AppendAudit(ctx, repository.AuditEntry{Action: "aborted"}); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected abort, got %v", err)
+	}
+
+	actions := func(entries []repository.AuditEntry) []string {
+		out := make([]string, len(entries))
+		for i, e := range entries {
+			out[i] = e.Action + ":" + e.TargetID
+		}
+		return out
+	}
+
+	all, next, err := s.ListAudit(ctx, repository.AuditFilter{})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	assertNames(t, "all entries, newest first", actions(all), []string{"delete:u1", "update:u1", "update:u2", "create:u1"})
+	if next != 0 {
+		t.Errorf("expected no continuation, got %d", next)
+	}
+	first := all[len(all)-1]
+	if first.ActorID != "admin" || first.RequestID != "req-create" || first.IP != "192.0.2.1" || first.CreatedAt.IsZero() {
+		t.Errorf("expected entry fields to round-trip, got %+v", first)
+	}
+	if len(first.Changes) != 1 || first.Changes[0] != (repository.FieldChange{Field: "name", From: "a", To: "b"}) {
+		t.Errorf("expected changes to round-trip, got %+v", first.Changes)
+	}
+
+	byActor, _, _ := s.ListAudit(ctx, repository.AuditFilter{ActorID: "admin"})
+	assertNames(t, "by actor", actions(byActor), []string{"delete:u1", "update:u2", "create:u1"})
+	byTarget, _, _ := s.ListAudit(ctx, repository.AuditFilter{TargetID: "u1"})
+	assertNames(t, "by target", actions(byTarget), []string{"delete:u1", "update:u1", "create:u1"})
+	since, _, _ := s.ListAudit(ctx, repository.AuditFilter{Since: mid})
+	assertNames(t, "since", actions(since), []string{"delete:u1", "update:u1"})
+	until, _, _ := s.ListAudit(ctx, repository.AuditFilter{Until: mid, ActorID: "admin"})
+	assertNames(t, "until", actions(until), []string{"update:u2", "create:u1"})
+
+	page1, next, err := s.ListAudit(ctx, repository.AuditFilter{TargetID: "u1", Limit: 2})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	assertNames(t, "page 1", actions(page1), []string{"delete:u1", "update:u1"})
+	if next == 0 {
+		t.Fatal("expected a continuation after a full page")
+	}
+	page2, next, _ := s.ListAudit(ctx, repository.AuditFilter{TargetID: "u1", Limit: 2, BeforeID: next})
+	assertNames(t, "page 2", actions(page2), []string{"create:u1"})
+	if next != 0 {
+		t.Errorf("expected last page to have no continuation, got %d", next)
+	}
+}
+
+func mustCreate(t *testing.T, s repository.UserStore, name, email string) *repository.User {
+	t.Helper()
+	u, err := s.CreateUser(context.Background(), name, email)
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return u
+}
+
+func names(users []repository.User) []string {
+	out := make([]string, len(users))
+	for i, u := range users {
+		out[i] = u.Name
+	}
+	return out
+}
+
+func assertNames(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if !slices.Equal(got, want) {
+		t.Errorf("%s: expected %v, got %v", what, want, got)
+	}
+}
+
+func testOrganizations(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+
+	acme, err := s.CreateOrganization(ctx, "Acme")
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	if acme.ID == "" || acme.CreatedAt.IsZero() {
+		t.Errorf("expected generated ID and timestamp, got %+v", acme)
+	}
+	got, err := s.GetOrganization(ctx, acme.ID)
+	if err != nil {
+		t.Fatalf("GetOrganization: %v", err)
+	}
+	if got.Name != "Acme" {
+		t.Errorf("expected name to round-trip, got %+v", got)
+	}
+	if _, err := s.GetOrganization(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetOrganization: expected ErrNotFound, got %v", err)
+	}
+
+	errAbort := errors.New("abort")
+	err = s.WithTx(ctx, func(tx repository.Tx) error {
+		if _, err := tx.CreateOrganization(ctx, "Rolled back"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected abort, got %v", err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, err := s.CreateOrganization(ctx, "Globex"); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+
+	orgs, err := s.ListOrganizations(ctx)
+	if err != nil {
+		t.Fatalf("ListOrganizations: %v", err)
+	}
+	names := make([]string, len(orgs))
+	for i, org := range orgs {
+		names[i] = org.Name
+	}
+	assertNames(t, "organizations, oldest first", names, []string{"Acme", "Globex"})
+}
+
+func testRefreshTokens(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	first, err := s.CreateRefreshToken(ctx, repository.RefreshToken{UserID: "u1", TenantID: "tenant-a", TokenHash: "h1", ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if first.ID == "" || first.FamilyID != first.ID || first.CreatedAt.IsZero() {
+		t.Errorf("expected a generated ID that also names the family, got %+v", first)
+	}
+	if _, err := s.CreateRefreshToken(ctx, repository.RefreshToken{UserID: "u1", TokenHash: "h1", ExpiresAt: expires}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("duplicate hash: expected ErrConflict, got %v", err)
+	}
+
+	got, err := s.GetRefreshToken(ctx, "h1")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if got.ID != first.ID || got.UserID != "u1" || got.TenantID != "tenant-a" || !got.ExpiresAt.Equal(expires) || got.UsedAt != nil {
+		t.Errorf("expected the token to round-trip, got %+v", got)
+	}
+	if _, err := s.GetRefreshToken(ctx, "missing"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetRefreshToken: expected ErrNotFound, got %v", err)
+	}
+
+	// Rotation: the first token is used once and replaced within its family.
+	err = s.WithTx(ctx, func(tx repository.Tx) error {
+		if err := tx.UseRefreshToken(ctx, first.ID, time.Now()); err != nil {
+			return err
+		}
+		_, err := tx.CreateRefreshToken(ctx, repository.RefreshToken{FamilyID: first.FamilyID, UserID: "u1", TokenHash: "h2", ExpiresAt: expires})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if err := s.UseRefreshToken(ctx, first.ID, time.Now()); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("UseRefreshToken twice: expected ErrConflict, got %v", err)
+	}
+	if err := s.UseRefreshToken(ctx, "missing", time.Now()); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UseRefreshToken: expected ErrNotFound, got %v", err)
+	}
+	second, err := s.GetRefreshToken(ctx, "h2")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if second.FamilyID != first.FamilyID {
+		t.Errorf("expected the successor to join the family, got %+v", second)
+	}
+	if got, _ := s.GetRefreshToken(ctx, "h1"); got.UsedAt == nil {
+		t.Errorf("expected the first token to be marked used, got %+v", got)
+	}
+
+	other, err := s.CreateRefreshToken(ctx, repository.RefreshToken{UserID: "u1", TokenHash: "h3", ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	if n, err := s.RevokeTokenFamily(ctx, first.FamilyID, time.Now()); err != nil || n != 2 {
+		t.Errorf("RevokeTokenFamily: expected 2, got %d, %v", n, err)
+	}
+	if n, err := s.RevokeTokenFamily(ctx, first.FamilyID, time.Now()); err != nil || n != 0 {
+		t.Errorf("RevokeTokenFamily again: expected 0, got %d, %v", n, err)
+	}
+	if err := s.UseRefreshToken(ctx, second.ID, time.Now()); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("UseRefreshToken after revocation: expected ErrConflict, got %v", err)
+	}
+	if got, _ := s.GetRefreshToken(ctx, "h3"); got.RevokedAt != nil {
+		t.Errorf("expected another family to be left alone, got %+v", got)
+	}
+
+	tokens, err := s.ListRefreshTokens(ctx, "u1")
+	if err != nil {
+		t.Fatalf("ListRefreshTokens: %v", err)
+	}
+	if len(tokens) != 3 || tokens[0].ID != first.ID || tokens[2].ID != other.ID {
+		t.Errorf("expected the user's three tokens, oldest first, got %+v", tokens)
+	}
+	if tokens, _ := s.ListRefreshTokens(ctx, "u2"); len(tokens) != 0 {
+		t.Errorf("expected no tokens for another user, got %+v", tokens)
+	}
+
+	if n, err := s.PurgeExpiredTokens(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("PurgeExpiredTokens: expected 1, got %d, %v", n, err)
+	}
+	if _, err := s.GetRefreshToken(ctx, "h3"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the expired token to be purged, got %v", err)
+	}
+}
+
+func testAccessTokenRevocation(t *testing.T, s repository.Store) {
+	ctx := context.Background()
+
+	if revoked, err := s.IsAccessTokenRevoked(ctx, "jti-1"); err != nil || revoked {
+		t.Errorf("IsAccessTokenRevoked before revocation: got %v, %v", revoked, err)
+	}
+	if err := s.RevokeAccessToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeAccessToken: %v", err)
+	}
+	if err := s.RevokeAccessToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("RevokeAccessToken twice: %v", err)
+	}
+	if revoked, err := s.IsAccessTokenRevoked(ctx, "jti-1"); err != nil || !revoked {
+		t.Errorf("IsAccessTokenRevoked: expected true, got %v, %v", revoked, err)
+	}
+
+	// A revocation is forgotten once the token has expired anyway.
+	if err := s.RevokeAccessToken(ctx, "jti-2", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("RevokeAccessToken: %v", err)
+	}
+	if revoked, err := s.IsAccessTokenRevoked(ctx, "jti-2"); err != nil || revoked {
+		t.Errorf("IsAccessTokenRevoked after expiry: expected false, got %v, %v", revoked, err)
+	}
+	if n, err := s.PurgeExpiredTokens(ctx, time.Now()); err != nil || n != 1 {
+		t.Errorf("PurgeExpiredTokens: expected 1, got %d, %v", n, err)
+	}
+	if revoked, _ := s.IsAccessTokenRevoked(ctx, "jti-1"); !revoked {
+		t.Error("expected an unexpired revocation to survive the purge")
+	}
+
+	u := mustCreate(t, s, "Alice", "alice@example.com")
+	if u.TokensValidAfter != nil {
+		t.Errorf("expected no TokensValidAfter on a new user, got %v", u.TokensValidAfter)
+	}
+	at := time.Now().UTC().Truncate(time.Millisecond)
+	updated, err := s.UpdateUser(ctx, u.ID, repository.UserUpdate{TokensValidAfter: &at})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if updated.TokensValidAfter == nil || !updated.TokensValidAfter.Equal(at) || updated.Version != u.Version+1 {
+		t.Errorf("expected TokensValidAfter %v at a new version, got %+v", at, updated)
+	}
+	got, err := s.UpdateUser(ctx, u.ID, repository.UserUpdate{Name: "Alicia"})
+	if err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if got.TokensValidAfter == nil || !got.TokensValidAfter.Equal(at) {
+		t.Errorf("expected other updates to keep TokensValidAfter, got %v", got.TokensValidAfter)
+	}
+}
+
+func testTenantIsolation(t *testing.T, s repository.Store) {
+	base := context.Background()
+	a := tenant.WithID(base, "tenant-a")
+	b := tenant.WithID(base, "tenant-b")
+
+	alice, err := s.CreateUser(a, "Alice", "shared@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if alice.TenantID != "tenant-a" {
+		t.Errorf("expected the user to join the context's tenant, got %q", alice.TenantID)
+	}
+	// Emails are unique per tenant, not globally.
+	bob, err := s.CreateUser(b, "Bob", "SHARED@example.com")
+	if err != nil {
+		t.Fatalf("CreateUser in another tenant: %v", err)
+	}
+	if _, err := s.CreateUser(b, "Bobby", "shared@example.com"); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expected ErrConflict within a tenant, got %v", err)
+	}
+	if _, err := s.CreateUser(base, "Dee", "shared@example.com"); err != nil {
+		t.Errorf("CreateUser in the default tenant: %v", err)
+	}
+
+	if _, err := s.GetUser(b, alice.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetUser across tenants: expected ErrNotFound, got %v", err)
+	}
+	if got, err := s.GetUserByEmail(b, "shared@example.com"); err != nil || got.ID != bob.ID {
+		t.Errorf("GetUserByEmail: expected the tenant's own user, got %+v, %v", got, err)
+	}
+	if _, err := s.UpdateUser(b, alice.ID, repository.UserUpdate{Name: "Mallory"}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("UpdateUser across tenants: expected ErrNotFound, got %v", err)
+	}
+	if err := s.DeleteUser(b, alice.ID, 0); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("DeleteUser across tenants: expected ErrNotFound, got %v", err)
+	}
+
+	page, err := s.ListUsers(a, repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	if page.Total != 1 || page.Users[0].ID != alice.ID {
+		t.Errorf("expected only the tenant's own users, got %+v", page.Users)
+	}
+
+	// A deleted user stays invisible to, and unrecoverable by, other tenants.
+	if err := s.DeleteUser(a, alice.ID, 0); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.RestoreUser(b, alice.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("RestoreUser across tenants: expected ErrNotFound, got %v", err)
+	}
+	if n, err := s.PurgeDeletedUsers(b, time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeDeletedUsers across tenants: expected 0, got %d, %v", n, err)
+	}
+	if n, err := s.PurgeDeletedUsers(a, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("PurgeDeletedUsers: expected 1, got %d, %v", n, err)
+	}
+
+	err = s.WithTx(b, func(tx repository.Tx) error {
+		if err := tx.AppendEvent(b, repository.Event{Type: "test", AggregateID: bob.ID, Payload: []byte(`{}`)}); err != nil {
+			return err
+		}
+		return tx.AppendAudit(b, repository.AuditEntry{Action: "test", TargetID: bob.ID})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if entries, _, _ := s.ListAudit(a, repository.AuditFilter{}); len(entries) != 0 {
+		t.Errorf("ListAudit across tenants: expected nothing, got %+v", entries)
+	}
+	if entries, _, _ := s.ListAudit(b, repository.AuditFilter{}); len(entries) != 1 || entries[0].TenantID != "tenant-b" {
+		t.Errorf("ListAudit: expected the tenant's entry, got %+v", entries)
+	}
+	events, err := s.ReadEvents(base, 0, 0)
+	if err != nil {
+		t.Fatalf("ReadEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].TenantID != "tenant-b" {
+		t.Errorf("expected the event to carry its tenant, got %+v", events)
+	}
+}
@@ -0,0 +1,1086 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
+)
+
+// SQLStore is a UserStore backed by database/sql.
+// Queries are written for SQLite; run MigrateUp before first use.
+type SQLStore struct {
+	db        *sql.DB
+	q         querier // db, or the *sql.Tx inside WithTx
+	ids       IDGenerator
+	fields    *FieldCipher
+	passwords *password.Hasher
+}
+
+var (
+	_ Store       = (*SQLStore)(nil)
+	_ Reencrypter = (*SQLStore)(nil)
+)
+
+// querier is the subset of *sql.DB and *sql.Tx used by SQLStore.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// NewSQLStore wraps an open database handle.
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, q: db, ids: defaultIDs, passwords: defaultPasswords}
+}
+
+// SetIDGenerator makes s use g for new IDs instead of UUIDv7. Call it
+// before s is used.
+func (s *SQLStore) SetIDGenerator(g IDGenerator) {
+	s.ids = g
+}
+
+// SetPasswordHasher makes CreateUserWithPassword hash, and CheckPassword
+// verify, with h instead of password.Default. Call it before s is used.
+func (s *SQLStore) SetPasswordHasher(h *password.Hasher) {
+	s.passwords = h
+}
+
+// SetFieldCipher makes s encrypt user fields as c is configured to. Call it
+// before s is used. With the email encrypted, list filters and sorts on it
+// fail with ErrEncryptedField, and likewise for the name.
+func (s *SQLStore) SetFieldCipher(c *FieldCipher) {
+	s.fields = c
+}
+
+// WithTx runs fn inside a database transaction at the driver's default
+// isolation level (serializable for SQLite).
+func (s *SQLStore) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err := fn(&SQLStore{db: s.db, q: tx, ids: s.ids, fields: s.fields, passwords: s.passwords}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// Close closes the underlying database handle.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+const userColumns = `id, tenant_id, name, email, password, version, created_at, updated_at, deleted_at, erase_at, metadata, tokens_valid_after, roles`
+
+func (s *SQLStore) ListUsers(ctx context.Context, opts ListOptions) (*UserPage, error) {
+	opts, cur, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fields.checkList(opts); err != nil {
+		return nil, err
+	}
+
+	where, args := sqlFilter(tenant.ID(ctx), opts)
+	page := &UserPage{Page: opts.Page, PerPage: opts.PerPage, Users: make([]User, 0)}
+	if err := s.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	col := sortColumn(opts.Sort)
+	query := `SELECT ` + userColumns + ` FROM users` + where
+	backward := cur != nil && cur.Before
+	switch {
+	case cur == nil:
+		query += orderBy(col, opts.Desc) + ` LIMIT ? OFFSET ?`
+		args = append(args, opts.PerPage, (opts.Page-1)*opts.PerPage)
+	case backward:
+		query += ` AND ` + keyset(col, !opts.Desc) + orderBy(col, !opts.Desc) + ` LIMIT ?`
+		args = append(args, cur.Key, cur.ID, opts.PerPage)
+	default:
+		query += ` AND ` + keyset(col, opts.Desc) + orderBy(col, opts.Desc) + ` LIMIT ?`
+		args = append(args, cur.Key, cur.ID, opts.PerPage)
+	}
+
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := scanUserRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.fields.openUser(u); err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if backward {
+		slices.Reverse(page.Users)
+	}
+
+	if n := len(page.Users); n > 0 {
+		first, last := &page.Users[0], &page.Users[n-1]
+		hasNext, err := s.rowsBeyond(ctx, opts, last, opts.Desc)
+		if err != nil {
+			return nil, err
+		}
+		hasPrev, err := s.rowsBeyond(ctx, opts, first, !opts.Desc)
+		if err != nil {
+			return nil, err
+		}
+		if hasNext {
+			page.NextCursor = cursorAt(last, opts, false)
+		}
+		if hasPrev {
+			page.PrevCursor = cursorAt(first, opts, true)
+		}
+	}
+	return page, nil
+}
+
+// rowsBeyond reports whether any matching row sorts after u (or before it,
+// when reverse is set relative to ascending order).
+func (s *SQLStore) rowsBeyond(ctx context.Context, opts ListOptions, u *User, reverse bool) (bool, error) {
+	where, args := sqlFilter(tenant.ID(ctx), opts)
+	query := `SELECT EXISTS (SELECT 1 FROM users` + where + ` AND ` + keyset(sortColumn(opts.Sort), reverse) + `)`
+	args = append(args, sortKey(u, opts.Sort), u.ID)
+
+	var exists bool
+	err := s.q.QueryRowContext(ctx, query, args...).Scan(&exists)
+	return exists, err
+}
+
+func (s *SQLStore) GetUser(ctx context.Context, id string) (*User, error) {
+	row := s.q.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL`, id, tenant.ID(ctx))
+	return s.scanUser(row)
+}
+
+func (s *SQLStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	row := s.q.QueryRowContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE tenant_id = ? AND email_key = ? AND deleted_at IS NULL`, tenant.ID(ctx), s.fields.emailKey(email))
+	return s.scanUser(row)
+}
+
+func (s *SQLStore) CreateUser(ctx context.Context, name, email string) (*User, error) {
+	return s.InsertUser(ctx, NewUser{Name: name, Email: email})
+}
+
+func (s *SQLStore) CreateUserWithPassword(ctx context.Context, name, email, password string) (*User, error) {
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	return s.InsertUser(ctx, NewUser{Name: name, Email: email, PasswordHash: hash})
+}
+
+func (s *SQLStore) CheckPassword(hashedPassword, password string) bool {
+	ok, _ := s.passwords.Verify(hashedPassword, password)
+	return ok
+}
+
+func (s *SQLStore) CreateUserWithHash(ctx context.Context, name, email, passwordHash string) (*User, error) {
+	return s.InsertUser(ctx, NewUser{Name: name, Email: email, PasswordHash: passwordHash})
+}
+
+func (s *SQLStore) UpdateUser(ctx context.Context, id string, upd UserUpdate) (*User, error) {
+	// Empty values leave the column alone, so only non-empty ones are sealed.
+	var name, nameKey, email, emailKey string
+	var err error
+	if upd.Name != "" {
+		if name, err = s.fields.seal(FieldName, id, upd.Name); err != nil {
+			return nil, err
+		}
+		nameKey = s.fields.nameKey(upd.Name)
+	}
+	if upd.Email != "" {
+		if email, err = s.fields.seal(FieldEmail, id, upd.Email); err != nil {
+			return nil, err
+		}
+		emailKey = s.fields.emailKey(upd.Email)
+	}
+	var metadata any
+	if upd.Metadata != nil {
+		if metadata, err = metadataColumn(upd.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	var validAfter any
+	if upd.TokensValidAfter != nil {
+		validAfter = sqlTime(*upd.TokensValidAfter)
+	}
+	roles, err := rolesColumn(upd.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.q.ExecContext(ctx, `
+		UPDATE users
+		SET name = COALESCE(NULLIF(?, ''), name),
+		    name_key = CASE WHEN ? THEN ? ELSE name_key END,
+		    email = COALESCE(NULLIF(?, ''), email),
+		    email_key = COALESCE(NULLIF(?, ''), email_key),
+		    metadata = CASE WHEN ? THEN ? ELSE metadata END,
+		    password = COALESCE(NULLIF(?, ''), password),
+		    tokens_valid_after = COALESCE(?, tokens_valid_after),
+		    roles = CASE WHEN ? THEN ? ELSE roles END,
+		    version = version + 1,
+		    updated_at = ?
+		WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`,
+		name, upd.Name != "", nameKey, email, emailKey, upd.Metadata != nil, metadata, upd.PasswordHash, validAfter, upd.Roles != nil, roles, sqlTime(time.Now()), id, tenant.ID(ctx), upd.IfVersion, upd.IfVersion)
+	if err != nil {
+		return nil, mapConstraintError(err)
+	}
+	if err := s.expectVersioned(ctx, res, id); err != nil {
+		return nil, err
+	}
+	return s.GetUser(ctx, id)
+}
+
+func (s *SQLStore) DeleteUser(ctx context.Context, id string, ifVersion int64) error {
+	now := sqlTime(time.Now())
+	res, err := s.q.ExecContext(ctx, `
+		UPDATE users
+		SET deleted_at = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL AND (? = 0 OR version = ?)`,
+		now, now, id, tenant.ID(ctx), ifVersion, ifVersion)
+	if err != nil {
+		return err
+	}
+	return s.expectVersioned(ctx, res, id)
+}
+
+// expectVersioned explains a conditional write that matched no rows: either
+// the user does not exist or its version moved on.
+func (s *SQLStore) expectVersioned(ctx context.Context, res sql.Result, id string) error {
+	if err := expectAffected(res); err != ErrNotFound {
+		return err
+	}
+	if _, err := s.GetUser(ctx, id); err != nil {
+		return err
+	}
+	return ErrVersionMismatch
+}
+
+func (s *SQLStore) RestoreUser(ctx context.Context, id string) (*User, error) {
+	_, err := s.q.ExecContext(ctx,
+		`UPDATE users SET deleted_at = NULL, version = version + 1, updated_at = ?
+		 WHERE id = ? AND tenant_id = ? AND deleted_at IS NOT NULL`,
+		sqlTime(time.Now()), id, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(ctx, id)
+}
+
+func (s *SQLStore) PurgeDeletedUsers(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.q.ExecContext(ctx,
+		`DELETE FROM users WHERE tenant_id = ? AND deleted_at IS NOT NULL AND deleted_at < ?`,
+		tenant.ID(ctx), sqlTime(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLStore) ScheduleErasure(ctx context.Context, id string, at *time.Time) (*User, error) {
+	var eraseAt any
+	if at != nil {
+		eraseAt = sqlTime(*at)
+	}
+	res, err := s.q.ExecContext(ctx,
+		`UPDATE users SET erase_at = ?, version = version + 1, updated_at = ?
+		 WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL`,
+		eraseAt, sqlTime(time.Now()), id, tenant.ID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := expectAffected(res); err != nil {
+		return nil, err
+	}
+	return s.GetUser(ctx, id)
+}
+
+// EraseDueUsers writes the placeholders in plaintext even when fields are
+// encrypted: they are not personal data, and a re-encryption seals them
+// like any other value. Outside WithTx it runs in a transaction of its own,
+// so users and audit entries are erased together.
+func (s *SQLStore) EraseDueUsers(ctx context.Context, before time.Time) (ids []string, err error) {
+	if _, inTx := s.q.(*sql.Tx); !inTx {
+		err = s.WithTx(ctx, func(tx Tx) error {
+			ids, err = tx.EraseDueUsers(ctx, before)
+			return err
+		})
+		return ids, err
+	}
+
+	now := sqlTime(time.Now())
+	rows, err := s.q.QueryContext(ctx, `
+		UPDATE users
+		SET name = ?, name_key = ?, email = id || ?, email_key = id || ?, password = '', metadata = NULL,
+		    erase_at = NULL, deleted_at = COALESCE(deleted_at, ?), version = version + 1, updated_at = ?
+		WHERE tenant_id = ? AND erase_at IS NOT NULL AND erase_at < ?
+		RETURNING id`,
+		ErasedName, s.fields.nameKey(ErasedName), erasedEmailSuffix, erasedEmailSuffix, now, now, tenant.ID(ctx), sqlTime(before))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids = make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := s.eraseAudit(ctx, id); err != nil {
+			return nil, err
+		}
+		if _, err := s.q.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id = ?`, id); err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (s *SQLStore) InsertUser(ctx context.Context, nu NewUser) (*User, error) {
+	metadata, err := normalizeMetadata(nu.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	column, err := metadataColumn(metadata)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	user := &User{
+		ID:        id,
+		TenantID:  tenant.ID(ctx),
+		Name:      nu.Name,
+		Email:     nu.Email,
+		Password:  nu.PasswordHash,
+		Version:   1,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Metadata:  metadata,
+		Roles:     cloneRoles(nu.Roles),
+	}
+	sealedName, sealedEmail, err := s.fields.sealUser(user)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := rolesColumn(user.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.q.ExecContext(ctx,
+		`INSERT INTO users (`+userColumns+`, name_key, email_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, NULL, NULL, ?, NULL, ?, ?, ?)`,
+		user.ID, user.TenantID, sealedName, sealedEmail, user.Password, user.Version, sqlTime(user.CreatedAt), sqlTime(user.UpdatedAt), column, roles,
+		s.fields.nameKey(user.Name), s.fields.emailKey(user.Email))
+	if err != nil {
+		return nil, mapConstraintError(err)
+	}
+	return user, nil
+}
+
+// reencryptBatch is how many users ReencryptUsers reads at a time.
+const reencryptBatch = 500
+
+// ReencryptUsers implements Reencrypter. Each user is rewritten only if it
+// still holds the values that were read, so a concurrent write (which seals
+// with the current key anyway) is never overwritten. The audit log is
+// resealed afterwards; see reencryptAudit.
+func (s *SQLStore) ReencryptUsers(ctx context.Context) (int, error) {
+	type stored struct{ id, name, email string }
+	rewritten := 0
+	after := ""
+	for {
+		rows, err := s.q.QueryContext(ctx,
+			`SELECT id, name, email FROM users WHERE id > ? ORDER BY id LIMIT ?`, after, reencryptBatch)
+		if err != nil {
+			return rewritten, err
+		}
+		var batch []stored
+		for rows.Next() {
+			var r stored
+			if err := rows.Scan(&r.id, &r.name, &r.email); err != nil {
+				rows.Close()
+				return rewritten, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return rewritten, err
+		}
+		if len(batch) == 0 {
+			return rewritten, s.reencryptAudit(ctx)
+		}
+		after = batch[len(batch)-1].id
+
+		for _, r := range batch {
+			if !s.fields.stale(FieldName, r.name) && !s.fields.stale(FieldEmail, r.email) {
+				continue
+			}
+			u := &User{ID: r.id, Name: r.name, Email: r.email}
+			if err := s.fields.openUser(u); err != nil {
+				return rewritten, err
+			}
+			name, email, err := s.fields.sealUser(u)
+			if err != nil {
+				return rewritten, err
+			}
+			res, err := s.q.ExecContext(ctx,
+				`UPDATE users SET name = ?, name_key = ?, email = ?, email_key = ? WHERE id = ? AND name = ? AND email = ?`,
+				name, s.fields.nameKey(u.Name), email, s.fields.emailKey(u.Email), r.id, r.name, r.email)
+			if err != nil {
+				return rewritten, mapConstraintError(err)
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return rewritten, err
+			} else if n > 0 {
+				rewritten++
+			}
+		}
+	}
+}
+
+// reencryptAudit reseals the audit entries whose changes are stale. Like
+// users, an entry is only rewritten if it still holds what was read, since
+// an erasure may have replaced its values in the meantime.
+func (s *SQLStore) reencryptAudit(ctx context.Context) error {
+	type stored struct {
+		entry   AuditEntry
+		changes string
+	}
+	var after int64
+	for {
+		rows, err := s.q.QueryContext(ctx,
+			`SELECT id, target_id, changes FROM audit_log WHERE id > ? ORDER BY id LIMIT ?`, after, reencryptBatch)
+		if err != nil {
+			return err
+		}
+		var batch []stored
+		for rows.Next() {
+			var r stored
+			if err := rows.Scan(&r.entry.ID, &r.entry.TargetID, &r.changes); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		after = batch[len(batch)-1].entry.ID
+
+		for _, r := range batch {
+			e := r.entry
+			if err := json.Unmarshal([]byte(r.changes), &e.Changes); err != nil {
+				return err
+			}
+			if !s.fields.staleAudit(&e) {
+				continue
+			}
+			if err := s.fields.openAudit(&e); err != nil {
+				return err
+			}
+			sealed, err := s.fields.sealAudit(e)
+			if err != nil {
+				return err
+			}
+			changes, err := json.Marshal(sealed.Changes)
+			if err != nil {
+				return err
+			}
+			if _, err := s.q.ExecContext(ctx,
+				`UPDATE audit_log SET changes = ? WHERE id = ? AND changes = ?`,
+				string(changes), e.ID, r.changes); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *SQLStore) CreateOrganization(ctx context.Context, name string) (*Organization, error) {
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	org := &Organization{ID: id, Name: name, CreatedAt: time.Now().UTC()}
+	_, err = s.q.ExecContext(ctx,
+		`INSERT INTO organizations (id, name, created_at) VALUES (?, ?, ?)`,
+		org.ID, org.Name, sqlTime(org.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (s *SQLStore) GetOrganization(ctx context.Context, id string) (*Organization, error) {
+	var org Organization
+	err := s.q.QueryRowContext(ctx, `SELECT id, name, created_at FROM organizations WHERE id = ?`, id).
+		Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (s *SQLStore) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	rows, err := s.q.QueryContext(ctx, `SELECT id, name, created_at FROM organizations ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := make([]Organization, 0)
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+const tokenColumns = `id, family_id, user_id, tenant_id, token_hash, created_at, expires_at, used_at, revoked_at`
+
+func (s *SQLStore) CreateRefreshToken(ctx context.Context, t RefreshToken) (*RefreshToken, error) {
+	id, err := s.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	t.ID = id
+	if t.FamilyID == "" {
+		t.FamilyID = id
+	}
+	t.CreatedAt = time.Now().UTC()
+	t.UsedAt, t.RevokedAt = nil, nil
+	_, err = s.q.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (id, family_id, user_id, tenant_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.FamilyID, t.UserID, t.TenantID, t.TokenHash, sqlTime(t.CreatedAt), sqlTime(t.ExpiresAt))
+	if err != nil {
+		return nil, mapConstraintError(err)
+	}
+	return &t, nil
+}
+
+func (s *SQLStore) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	row := s.q.QueryRowContext(ctx, `SELECT `+tokenColumns+` FROM refresh_tokens WHERE token_hash = ?`, tokenHash)
+	t, err := scanToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *SQLStore) UseRefreshToken(ctx context.Context, id string, at time.Time) error {
+	res, err := s.q.ExecContext(ctx,
+		`UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL`,
+		sqlTime(at), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		return nil
+	}
+
+	// Tell a missing token from one that can no longer be used.
+	var exists bool
+	if err := s.q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrConflict
+}
+
+func (s *SQLStore) RevokeTokenFamily(ctx context.Context, familyID string, at time.Time) (int, error) {
+	res, err := s.q.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL`,
+		sqlTime(at), familyID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLStore) ListRefreshTokens(ctx context.Context, userID string) ([]RefreshToken, error) {
+	rows, err := s.q.QueryContext(ctx,
+		`SELECT `+tokenColumns+` FROM refresh_tokens WHERE user_id = ? ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]RefreshToken, 0)
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *SQLStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)
+		 ON CONFLICT (jti) DO UPDATE SET expires_at = excluded.expires_at`,
+		jti, sqlTime(expiresAt))
+	return err
+}
+
+func (s *SQLStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := s.q.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ? AND expires_at > ?)`,
+		jti, sqlTime(time.Now())).Scan(&revoked)
+	return revoked, err
+}
+
+func (s *SQLStore) PurgeExpiredTokens(ctx context.Context, before time.Time) (int, error) {
+	var total int64
+	for _, query := range []string{
+		`DELETE FROM refresh_tokens WHERE expires_at < ?`,
+		`DELETE FROM revoked_tokens WHERE expires_at < ?`,
+	} {
+		res, err := s.q.ExecContext(ctx, query, sqlTime(before))
+		if err != nil {
+			return int(total), err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return int(total), err
+		}
+		total += n
+	}
+	return int(total), nil
+}
+
+func scanToken(row rowScanner) (*RefreshToken, error) {
+	var t RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.FamilyID, &t.UserID, &t.TenantID, &t.TokenHash, &t.CreatedAt, &t.ExpiresAt, &usedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+// AppendEvent implements Tx. Outside WithTx the event is written on its own.
+func (s *SQLStore) AppendEvent(ctx context.Context, e Event) error {
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO outbox_events (tenant_id, type, aggregate_id, payload, created_at) VALUES (?, ?, ?, ?, ?)`,
+		tenant.ID(ctx), e.Type, e.AggregateID, string(e.Payload), sqlTime(time.Now()))
+	return err
+}
+
+func (s *SQLStore) ReadEvents(ctx context.Context, after int64, limit int) ([]Event, error) {
+	if limit <= 0 {
+		limit = DefaultEventBatch
+	}
+	rows, err := s.q.QueryContext(ctx,
+		`SELECT id, tenant_id, type, aggregate_id, payload, created_at FROM outbox_events WHERE id > ? ORDER BY id LIMIT ?`,
+		after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		var e Event
+		var payload string
+		if err := rows.Scan(&e.Offset, &e.TenantID, &e.Type, &e.AggregateID, &payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = json.RawMessage(payload)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLStore) ConsumerOffset(ctx context.Context, consumer string) (int64, error) {
+	var offset int64
+	err := s.q.QueryRowContext(ctx, `SELECT position FROM outbox_consumers WHERE name = ?`, consumer).Scan(&offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return offset, err
+}
+
+func (s *SQLStore) SetConsumerOffset(ctx context.Context, consumer string, offset int64) error {
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO outbox_consumers (name, position) VALUES (?, ?)
+		 ON CONFLICT (name) DO UPDATE SET position = excluded.position`,
+		consumer, offset)
+	return err
+}
+
+// AppendAudit implements Tx. Outside WithTx the entry is written on its own.
+// Values of encrypted fields are sealed like the user's own.
+func (s *SQLStore) AppendAudit(ctx context.Context, e AuditEntry) error {
+	e, err := s.fields.sealAudit(e)
+	if err != nil {
+		return err
+	}
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = s.q.ExecContext(ctx, `
+		INSERT INTO audit_log (tenant_id, created_at, actor_id, request_id, ip, action, target_id, changes)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		tenant.ID(ctx), sqlTime(time.Now()), e.ActorID, e.RequestID, e.IP, e.Action, e.TargetID, string(changes))
+	return err
+}
+
+// eraseAudit removes the personal data from the audit entries about user
+// id. The placeholders are written in plaintext, like EraseDueUsers's.
+func (s *SQLStore) eraseAudit(ctx context.Context, id string) error {
+	rows, err := s.q.QueryContext(ctx,
+		`SELECT id, actor_id, request_id, ip, target_id, changes FROM audit_log WHERE target_id = ? OR actor_id = ?`, id, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var erased []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var changes string
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.RequestID, &e.IP, &e.TargetID, &changes); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return err
+		}
+		if e.erasePersonalData(id) {
+			erased = append(erased, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, e := range erased {
+		changes, err := json.Marshal(e.Changes)
+		if err != nil {
+			return err
+		}
+		_, err = s.q.ExecContext(ctx,
+			`UPDATE audit_log SET request_id = ?, ip = ?, changes = ? WHERE id = ?`, e.RequestID, e.IP, string(changes), e.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, int64, error) {
+	f = f.normalize()
+
+	conds := []string{`tenant_id = ?`}
+	args := []any{tenant.ID(ctx)}
+	if f.ActorID != "" {
+		conds = append(conds, `actor_id = ?`)
+		args = append(args, f.ActorID)
+	}
+	if f.TargetID != "" {
+		conds = append(conds, `target_id = ?`)
+		args = append(args, f.TargetID)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, sqlTime(f.Since))
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, sqlTime(f.Until))
+	}
+	if f.BeforeID > 0 {
+		conds = append(conds, `id < ?`)
+		args = append(args, f.BeforeID)
+	}
+	query := `SELECT id, tenant_id, created_at, actor_id, request_id, ip, action, target_id, changes FROM audit_log` +
+		` WHERE ` + strings.Join(conds, ` AND `)
+	// Fetch one extra row to learn whether another page follows.
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, f.Limit+1)
+
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		var changes string
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.CreatedAt, &e.ActorID, &e.RequestID, &e.IP, &e.Action, &e.TargetID, &changes); err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return nil, 0, err
+		}
+		if err := s.fields.openAudit(&e); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+		return entries, entries[f.Limit-1].ID, nil
+	}
+	return entries, 0, nil
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (s *SQLStore) scanUser(row *sql.Row) (*User, error) {
+	u, err := scanUserRow(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fields.openUser(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func scanUserRow(row rowScanner) (*User, error) {
+	var u User
+	var deletedAt, eraseAt, validAfter sql.NullTime
+	var metadata, roles sql.NullString
+	if err := row.Scan(&u.ID, &u.TenantID, &u.Name, &u.Email, &u.Password, &u.Version, &u.CreatedAt, &u.UpdatedAt, &deletedAt, &eraseAt, &metadata, &validAfter, &roles); err != nil {
+		return nil, err
+	}
+	if roles.Valid {
+		if err := json.Unmarshal([]byte(roles.String), &u.Roles); err != nil {
+			return nil, fmt.Errorf("decode roles of user %s: %w", u.ID, err)
+		}
+	}
+	if metadata.Valid {
+		if err := json.Unmarshal([]byte(metadata.String), &u.Metadata); err != nil {
+			return nil, fmt.Errorf("decode metadata of user %s: %w", u.ID, err)
+		}
+	}
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	if eraseAt.Valid {
+		u.EraseAt = &eraseAt.Time
+	}
+	if validAfter.Valid {
+		u.TokensValidAfter = &validAfter.Time
+	}
+	return &u, nil
+}
+
+// metadataColumn encodes metadata for the metadata column, which is NULL
+// when there is none.
+func metadataColumn(m map[string]any) (any, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	return string(b), nil
+}
+
+// rolesColumn encodes roles as a JSON array for the roles column, which is
+// NULL when there are none.
+func rolesColumn(roles []string) (any, error) {
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(roles)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sqlFilter renders the tenant scope and ListOptions filters as a WHERE
+// clause.
+func sqlFilter(tenantID string, o ListOptions) (string, []any) {
+	conds := []string{`tenant_id = ?`}
+	args := []any{tenantID}
+	if !o.IncludeDeleted {
+		conds = append(conds, `deleted_at IS NULL`)
+	}
+	if o.EmailDomain != "" {
+		suffix := "@" + o.EmailDomain
+		conds = append(conds, `substr(email_key, -?) = ?`)
+		args = append(args, utf8.RuneCountInString(suffix), suffix)
+	}
+	if o.NameContains != "" {
+		conds = append(conds, `instr(name_key, ?) > 0`)
+		args = append(args, strings.ToLower(o.NameContains))
+	}
+	if !o.CreatedAfter.IsZero() {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, sqlTime(o.CreatedAfter))
+	}
+	if !o.CreatedBefore.IsZero() {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, sqlTime(o.CreatedBefore))
+	}
+	// Keys were checked by ListOptions.normalize, so they are safe to quote
+	// into a JSON path. Sorted for a stable query text.
+	keys := make([]string, 0, len(o.Metadata))
+	for key := range o.Metadata {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		path, want := `$."`+key+`"`, o.Metadata[key]
+		var number, boolean any
+		if f, err := strconv.ParseFloat(want, 64); err == nil {
+			number = f
+		}
+		if want == "true" || want == "false" {
+			boolean = want
+		}
+		conds = append(conds, `(CASE json_type(metadata, ?)
+			WHEN 'text' THEN json_extract(metadata, ?) = ?
+			WHEN 'integer' THEN json_extract(metadata, ?) = ?
+			WHEN 'real' THEN json_extract(metadata, ?) = ?
+			WHEN 'true' THEN ? = 'true'
+			WHEN 'false' THEN ? = 'false'
+			ELSE 0 END)`)
+		args = append(args, path, path, want, path, number, path, number, boolean, boolean)
+	}
+	return ` WHERE ` + strings.Join(conds, ` AND `), args
+}
+
+// keyset compares (col, id) with a cursor position: rows after it in
+// ascending order, or before it when desc is set.
+func keyset(col string, desc bool) string {
+	if desc {
+		return `(` + col + `, id) < (?, ?)`
+	}
+	return `(` + col + `, id) > (?, ?)`
+}
+
+func orderBy(col string, desc bool) string {
+	if desc {
+		return ` ORDER BY ` + col + ` DESC, id DESC`
+	}
+	return ` ORDER BY ` + col + ` ASC, id ASC`
+}
+
+func sortColumn(field string) string {
+	switch field {
+	case SortName:
+		return "name"
+	case SortEmail:
+		return "email_key"
+	default:
+		return "created_at"
+	}
+}
+
+// sqlTime stores timestamps in the same fixed-width form used for sort keys
+// so that text comparison in SQL matches chronological order.
+func sqlTime(t time.Time) string {
+	return t.UTC().Format(sortKeyLayout)
+}
+
+// mapConstraintError turns a unique-index violation into ErrConflict.
+func mapConstraintError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrConflict
+	}
+	return err
+}
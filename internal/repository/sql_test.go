@@ -0,0 +1,110 @@
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/repository/repotest"
+)
+
+func TestSQLStore(t *testing.T) {
+	repotest.RunUserStore(t, func(t *testing.T) repository.Store {
+		db := openSQLite(t)
+		if _, err := repository.MigrateUp(context.Background(), db); err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		return repository.NewSQLStore(db)
+	})
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	migrations, err := repository.Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	latest := migrations[len(migrations)-1].Version
+
+	n, err := repository.MigrateUp(ctx, db)
+	if err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if n != len(migrations) {
+		t.Errorf("expected %d migrations applied, got %d", len(migrations), n)
+	}
+	if v, _ := repository.SchemaVersion(ctx, db); v != latest {
+		t.Errorf("expected schema version %d, got %d", latest, v)
+	}
+
+	// Re-running is a no-op.
+	if n, err := repository.MigrateUp(ctx, db); err != nil || n != 0 {
+		t.Errorf("expected idempotent MigrateUp, got n=%d err=%v", n, err)
+	}
+
+	n, err = repository.MigrateDown(ctx, db, len(migrations))
+	if err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if n != len(migrations) {
+		t.Errorf("expected %d migrations reverted, got %d", len(migrations), n)
+	}
+	if v, _ := repository.SchemaVersion(ctx, db); v != 0 {
+		t.Errorf("expected schema version 0 after full rollback, got %d", v)
+	}
+
+	if _, err := repository.MigrateUp(ctx, db); err != nil {
+		t.Fatalf("MigrateUp after rollback: %v", err)
+	}
+}
+
+func TestMigrateNormalizesKeys(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if _, err := repository.MigrateUp(ctx, db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	s := repository.NewSQLStore(db)
+	elodie, err := s.CreateUser(ctx, "Élodie", "ÉLODIE@Example.com")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	// Go back to the keys migration 2 left behind, made with SQLite's
+	// ASCII-only lower(), and migrate forward again.
+	if _, err := repository.MigrateDown(ctx, db, 1); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE users SET email_key = lower(trim(email))`); err != nil {
+		t.Fatalf("reset email_key: %v", err)
+	}
+	if _, err := repository.MigrateUp(ctx, db); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	if got, err := s.GetUserByEmail(ctx, "élodie@example.com"); err != nil || got.ID != elodie.ID {
+		t.Errorf("expected the email key to be normalized in Go, got %+v, %v", got, err)
+	}
+	page, err := s.ListUsers(ctx, repository.ListOptions{NameContains: "éLO"})
+	if err != nil || page.Total != 1 {
+		t.Errorf("expected the name key to be filled in, got %+v, %v", page, err)
+	}
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	// Match the app: SQLite has a single writer.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
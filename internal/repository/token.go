@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RefreshToken is a stored refresh token. Only a hash of the token itself
+// is kept, so a copy of the store cannot be used to sign in.
+//
+// Each refresh replaces the presented token with a new one in the same
+// family, which starts with the token issued at login. A token that has
+// been used or revoked is never accepted again.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	FamilyID  string     `json:"family_id"`
+	UserID    string     `json:"user_id"`
+	TenantID  string     `json:"tenant_id,omitempty"`
+	TokenHash string     `json:"token_hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// TokenStore keeps refresh tokens and the access tokens revoked before
+// their expiry. Like organizations they are not scoped to the tenant in
+// ctx: a token is looked up before its tenant is known, and
+// RefreshToken.TenantID tells which tenant it belongs to.
+type TokenStore interface {
+	// CreateRefreshToken stores t under a new ID. If t.FamilyID is empty the
+	// token starts a family of its own, whose ID is the token's. A token
+	// hash that is already stored fails with ErrConflict.
+	CreateRefreshToken(ctx context.Context, t RefreshToken) (*RefreshToken, error)
+	GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error)
+	// UseRefreshToken marks a token as used. It fails with ErrConflict if
+	// the token was already used or revoked, so of two concurrent refreshes
+	// with the same token only one succeeds.
+	UseRefreshToken(ctx context.Context, id string, at time.Time) error
+	// RevokeTokenFamily revokes every token of a family that is not revoked
+	// yet and returns how many there were.
+	RevokeTokenFamily(ctx context.Context, familyID string, at time.Time) (int, error)
+	// ListRefreshTokens returns a user's tokens, oldest first.
+	ListRefreshTokens(ctx context.Context, userID string) ([]RefreshToken, error)
+
+	// RevokeAccessToken denies the access token with the given ID (its jti)
+	// until expiresAt, when the token expires anyway and is forgotten.
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsAccessTokenRevoked reports whether jti was revoked and has not
+	// expired yet.
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+
+	// PurgeExpiredTokens removes the refresh tokens and revoked access
+	// tokens that expired before the cutoff and returns how many.
+	PurgeExpiredTokens(ctx context.Context, before time.Time) (int, error)
+}
+
+func (t *RefreshToken) clone() *RefreshToken {
+	c := *t
+	if t.UsedAt != nil {
+		at := *t.UsedAt
+		c.UsedAt = &at
+	}
+	if t.RevokedAt != nil {
+		at := *t.RevokedAt
+		c.RevokedAt = &at
+	}
+	return &c
+}
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, t RefreshToken) (created *RefreshToken, err error) {
+	defer r.afterWrite()
+	r.tokenMu.Lock()
+	defer r.tokenMu.Unlock()
+
+	err = r.commit(ctx, func(tx *memTx) error {
+		created, err = tx.CreateRefreshToken(ctx, t)
+		return err
+	})
+	return created, err
+}
+
+func (r *Repository) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	r.tokenMu.RLock()
+	defer r.tokenMu.RUnlock()
+	return r.view().GetRefreshToken(ctx, tokenHash)
+}
+
+func (r *Repository) UseRefreshToken(ctx context.Context, id string, at time.Time) error {
+	defer r.afterWrite()
+	r.tokenMu.Lock()
+	defer r.tokenMu.Unlock()
+
+	return r.commit(ctx, func(tx *memTx) error {
+		return tx.UseRefreshToken(ctx, id, at)
+	})
+}
+
+func (r *Repository) RevokeTokenFamily(ctx context.Context, familyID string, at time.Time) (n int, err error) {
+	defer r.afterWrite()
+	r.tokenMu.Lock()
+	defer r.tokenMu.Unlock()
+
+	err = r.commit(ctx, func(tx *memTx) error {
+		n, err = tx.RevokeTokenFamily(ctx, familyID, at)
+		return err
+	})
+	return n, err
+}
+
+func (r *Repository) ListRefreshTokens(ctx context.Context, userID string) ([]RefreshToken, error) {
+	r.tokenMu.RLock()
+	defer r.tokenMu.RUnlock()
+	return r.view().ListRefreshTokens(ctx, userID)
+}
+
+func (r *Repository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	defer r.afterWrite()
+	r.tokenMu.Lock()
+	defer r.tokenMu.Unlock()
+
+	return r.commit(ctx, func(tx *memTx) error {
+		return tx.RevokeAccessToken(ctx, jti, expiresAt)
+	})
+}
+
+func (r *Repository) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	r.tokenMu.RLock()
+	defer r.tokenMu.RUnlock()
+	return r.view().IsAccessTokenRevoked(ctx, jti)
+}
+
+func (r *Repository) PurgeExpiredTokens(ctx context.Context, before time.Time) (n int, err error) {
+	defer r.afterWrite()
+	r.tokenMu.Lock()
+	defer r.tokenMu.Unlock()
+
+	err = r.commit(ctx, func(tx *memTx) error {
+		n, err = tx.PurgeExpiredTokens(ctx, before)
+		return err
+	})
+	return n, err
+}
+
+func (t *memTx) CreateRefreshToken(ctx context.Context, rt RefreshToken) (*RefreshToken, error) {
+	r := t.r
+	if _, ok := r.tokenHashes[rt.TokenHash]; ok {
+		return nil, ErrConflict
+	}
+	id, err := r.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	rt.ID = id
+	if rt.FamilyID == "" {
+		rt.FamilyID = id
+	}
+	rt.CreatedAt = time.Now()
+	stored := rt.clone()
+
+	t.putToken(stored)
+	return stored.clone(), nil
+}
+
+func (t *memTx) GetRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	id, ok := t.r.tokenHashes[tokenHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t.r.tokens[id].clone(), nil
+}
+
+func (t *memTx) UseRefreshToken(ctx context.Context, id string, at time.Time) error {
+	cur, ok := t.r.tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if cur.UsedAt != nil || cur.RevokedAt != nil {
+		return ErrConflict
+	}
+	used := cur.clone()
+	used.UsedAt = &at
+	t.putToken(used)
+	return nil
+}
+
+func (t *memTx) RevokeTokenFamily(ctx context.Context, familyID string, at time.Time) (int, error) {
+	n := 0
+	for _, cur := range t.r.tokens {
+		if cur.FamilyID != familyID || cur.RevokedAt != nil {
+			continue
+		}
+		revoked := cur.clone()
+		revoked.RevokedAt = &at
+		t.putToken(revoked)
+		n++
+	}
+	return n, nil
+}
+
+func (t *memTx) ListRefreshTokens(ctx context.Context, userID string) ([]RefreshToken, error) {
+	out := make([]RefreshToken, 0)
+	for _, rt := range t.r.tokens {
+		if rt.UserID == userID {
+			out = append(out, *rt.clone())
+		}
+	}
+	slices.SortFunc(out, func(a, b RefreshToken) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return out, nil
+}
+
+func (t *memTx) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	r := t.r
+	if prev, ok := r.revoked[jti]; ok {
+		t.undo = append(t.undo, func() { r.revoked[jti] = prev })
+	} else {
+		t.undo = append(t.undo, func() { delete(r.revoked, jti) })
+	}
+	r.revoked[jti] = expiresAt
+	t.ops = append(t.ops, revokeOp(jti, expiresAt))
+	return nil
+}
+
+func (t *memTx) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	expiresAt, ok := t.r.revoked[jti]
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+func (t *memTx) PurgeExpiredTokens(ctx context.Context, before time.Time) (int, error) {
+	r := t.r
+	n := 0
+	for id, rt := range r.tokens {
+		if !rt.ExpiresAt.Before(before) {
+			continue
+		}
+		t.undo = append(t.undo, func() { r.putTokenLocked(rt) })
+		r.removeTokenLocked(id)
+		t.ops = append(t.ops, deleteTokenOp(id))
+		n++
+	}
+	for jti, expiresAt := range r.revoked {
+		if !expiresAt.Before(before) {
+			continue
+		}
+		t.undo = append(t.undo, func() { r.revoked[jti] = expiresAt })
+		delete(r.revoked, jti)
+		t.ops = append(t.ops, unrevokeOp(jti))
+		n++
+	}
+	return n, nil
+}
+
+// deleteUserTokens removes the refresh tokens of user id and records how to
+// undo it.
+func (t *memTx) deleteUserTokens(id string) {
+	r := t.r
+	for tokenID, rt := range r.tokens {
+		if rt.UserID != id {
+			continue
+		}
+		t.undo = append(t.undo, func() { r.putTokenLocked(rt) })
+		r.removeTokenLocked(tokenID)
+		t.ops = append(t.ops, deleteTokenOp(tokenID))
+	}
+}
+
+// putToken stores rt and records how to undo it.
+func (t *memTx) putToken(rt *RefreshToken) {
+	r := t.r
+	if prev, ok := r.tokens[rt.ID]; ok {
+		t.undo = append(t.undo, func() { r.putTokenLocked(prev) })
+	} else {
+		t.undo = append(t.undo, func() { r.removeTokenLocked(rt.ID) })
+	}
+	r.putTokenLocked(rt)
+	t.ops = append(t.ops, tokenOp(rt))
+}
+
+// putTokenLocked stores rt and indexes its hash. Callers hold tokenMu.
+func (r *Repository) putTokenLocked(rt *RefreshToken) {
+	r.tokens[rt.ID] = rt
+	r.tokenHashes[rt.TokenHash] = rt.ID
+}
+
+func (r *Repository) removeTokenLocked(id string) {
+	if rt, ok := r.tokens[id]; ok {
+		delete(r.tokenHashes, rt.TokenHash)
+		delete(r.tokens, id)
+	}
+}
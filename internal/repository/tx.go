@@ -0,0 +1,109 @@
+package repository
+
+import "context"
+
+var _ Store = (*Repository)(nil)
+
+// WithTx runs fn with every lock in the store held, so the transaction is
+// serializable with respect to every other operation on r. Writes are
+// applied to the maps as they happen and undone if fn fails; on success
+// they are handed to the journal as a single record.
+func (r *Repository) WithTx(ctx context.Context, fn func(tx Tx) error) error {
+	defer r.afterWrite()
+	r.lockAll()
+	defer r.unlockAll()
+
+	return r.commit(ctx, func(tx *memTx) error { return fn(tx) })
+}
+
+// write runs fn as a transaction that only touches the user with the given
+// ID, holding that user's shard lock and, if withEmail is set, the email
+// index lock. fn must not touch anything else.
+func (r *Repository) write(ctx context.Context, id string, withEmail bool, fn func(tx *memTx) error) error {
+	defer r.afterWrite()
+	if withEmail {
+		r.emailMu.Lock()
+		defer r.emailMu.Unlock()
+	}
+	s := r.shardFor(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return r.commit(ctx, fn)
+}
+
+// commit runs fn and journals its writes, rolling them back if fn fails or
+// panics or the journal rejects them. Callers hold the locks fn needs.
+func (r *Repository) commit(ctx context.Context, fn func(tx *memTx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tx := &memTx{r: r}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(tx.ops) > 0 {
+		if err := r.persist(tx.ops...); err != nil {
+			return err
+		}
+	}
+	committed = true
+	return nil
+}
+
+// memTx implements Tx on top of a Repository whose locks are held by the
+// caller. It also serves read-only calls made under read locks, see view.
+type memTx struct {
+	r    *Repository
+	ops  []walOp
+	undo []func()
+}
+
+// view returns a memTx for reads. Callers must hold the locks covering
+// whatever they read.
+func (r *Repository) view() *memTx {
+	return &memTx{r: r}
+}
+
+// put stores u and records how to undo it.
+func (t *memTx) put(u *User) {
+	r := t.r
+	if prev, ok := r.lookupLocked(u.ID); ok {
+		t.undo = append(t.undo, func() { r.putLocked(prev) })
+	} else {
+		t.undo = append(t.undo, func() { r.removeLocked(u.ID) })
+	}
+	r.putLocked(u)
+	t.ops = append(t.ops, putOp(u))
+}
+
+// remove deletes a user and records how to undo it.
+func (t *memTx) remove(id string) {
+	r := t.r
+	prev, ok := r.lookupLocked(id)
+	if !ok {
+		return
+	}
+	t.undo = append(t.undo, func() { r.putLocked(prev) })
+	r.removeLocked(id)
+	t.ops = append(t.ops, deleteOp(id))
+}
+
+// rollback reverts every write made through t, newest first.
+func (t *memTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.undo, t.ops = nil, nil
+}
@@ -2,149 +2,528 @@ package repository
 
 import (
 	"context"
+	"sort"
 	"time"
 
-	"crypto/rand"
-	"encoding/hex"
-
-	"golang.org/x/crypto/bcrypt"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
 )
 
 type User struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` // Never expose password in JSON
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        string     `json:"id"`
+	TenantID  string     `json:"organization_id,omitempty"` // empty for the default tenant
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Password  string     `json:"-"`       // Never expose password in JSON
+	Version   int64      `json:"version"` // Starts at 1, bumped on every change
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// EraseAt is when the user's personal data will be erased, if they
+	// asked for it; see ScheduleErasure.
+	EraseAt *time.Time `json:"erase_at,omitempty"`
+	// TokensValidAfter, when set, invalidates every access token issued
+	// before it.
+	TokensValidAfter *time.Time `json:"tokens_valid_after,omitempty"`
+	// Roles are the names of the roles the user holds (see package rbac),
+	// nil when there are none.
+	Roles []string `json:"roles,omitempty"`
+	// Metadata holds product-specific attributes as a JSON object. It is
+	// nil when there are none.
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// NewUser is a user to create with InsertUser.
+type NewUser struct {
+	Name         string
+	Email        string
+	PasswordHash string // from HashPassword, or empty for no password
+	Metadata     map[string]any
+	Roles        []string
+}
+
+// UserUpdate is a partial update: empty fields are left unchanged.
+type UserUpdate struct {
+	Name  string
+	Email string
+	// Metadata, when non-nil, replaces the user's metadata as a whole; an
+	// empty map clears it.
+	Metadata map[string]any
+	// PasswordHash replaces the password with one already hashed.
+	PasswordHash string
+	// TokensValidAfter, when non-nil, replaces User.TokensValidAfter.
+	TokensValidAfter *time.Time
+	// Roles, when non-nil, replaces the user's roles; an empty slice
+	// removes them all.
+	Roles []string
+	// IfVersion, when non-zero, makes the update fail with
+	// ErrVersionMismatch unless the stored version equals it.
+	IfVersion int64
+}
+
+// IsDeleted reports whether the user has been soft-deleted.
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// clone returns a deep copy of u. Stored users are only ever replaced, never
+// modified, but callers get their own copy so that they may modify it.
+func (u *User) clone() *User {
+	c := *u
+	if u.DeletedAt != nil {
+		t := *u.DeletedAt
+		c.DeletedAt = &t
+	}
+	if u.EraseAt != nil {
+		t := *u.EraseAt
+		c.EraseAt = &t
+	}
+	if u.TokensValidAfter != nil {
+		t := *u.TokensValidAfter
+		c.TokensValidAfter = &t
+	}
+	c.Roles = cloneRoles(u.Roles)
+	c.Metadata = cloneMetadata(u.Metadata)
+	return &c
 }
 
-func (r *Repository) ListUsers(ctx context.Context) ([]User, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+// cloneRoles copies roles, turning an empty slice into nil.
+func cloneRoles(roles []string) []string {
+	if len(roles) == 0 {
+		return nil
+	}
+	return append([]string(nil), roles...)
+}
+
+// ErasedName replaces the name of an erased user.
+const ErasedName = "Erased user"
+
+// erasedEmailSuffix follows the ID in an erased user's email, which is
+// thus unique per user and, being in the .invalid TLD, never deliverable.
+const erasedEmailSuffix = "@erased.invalid"
+
+func erasedEmail(id string) string {
+	return id + erasedEmailSuffix
+}
 
-	users := make([]User, 0, len(r.users))
-	for _, u := range r.users {
-		users = append(users, *u)
+// anonymize overwrites u's personal data in place and soft-deletes it, if
+// it was not already.
+func anonymize(u *User, now time.Time) {
+	u.Name = ErasedName
+	u.Email = erasedEmail(u.ID)
+	u.Password = ""
+	u.Metadata = nil
+	u.EraseAt = nil
+	if u.DeletedAt == nil {
+		u.DeletedAt = &now
 	}
-	return users, nil
+	u.Version++
+	u.UpdatedAt = now
+}
+
+// The Repository methods below are thin wrappers that take the locks an
+// operation needs; writes run as transactions scoped to those locks. The
+// logic lives on memTx so that it is shared with WithTx.
+
+func (r *Repository) ListUsers(ctx context.Context, opts ListOptions) (*UserPage, error) {
+	opts, cur, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	r.rlockShards()
+	users := r.view().collect(ctx, opts)
+	r.runlockShards()
+
+	// Sorting happens outside the locks; users holds private copies.
+	return paginate(users, opts, cur), nil
 }
 
 func (r *Repository) GetUser(ctx context.Context, id string) (*User, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	s := r.shardFor(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return r.view().GetUser(ctx, id)
+}
 
-	user, ok := r.users[id]
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	r.emailMu.RLock()
+	defer r.emailMu.RUnlock()
+
+	id, ok := r.emails[emailKey(tenant.ID(ctx), email)]
 	if !ok {
 		return nil, ErrNotFound
 	}
-	return user, nil
+	s := r.shardFor(id)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return r.view().GetUserByEmail(ctx, email)
 }
 
-func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+func (r *Repository) CreateUser(ctx context.Context, name, email string) (user *User, err error) {
+	id, err := r.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	err = r.write(ctx, id, true, func(tx *memTx) error {
+		user, err = tx.insert(ctx, id, NewUser{Name: name, Email: email})
+		return err
+	})
+	return user, err
+}
 
-	for _, u := range r.users {
-		if u.Email == email {
-			return u, nil
+// CreateUserWithPassword hashes the password before taking any lock, so a
+// registration never blocks other callers for the duration of the hash.
+func (r *Repository) CreateUserWithPassword(ctx context.Context, name, email, password string) (*User, error) {
+	// Fail fast so a conflict does not pay for the hash.
+	r.emailMu.RLock()
+	taken := r.emailTakenLocked(tenant.ID(ctx), email, "")
+	r.emailMu.RUnlock()
+	if taken {
+		return nil, ErrConflict
+	}
+
+	hash, err := r.passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	return r.CreateUserWithHash(ctx, name, email, hash)
+}
+
+func (r *Repository) CreateUserWithHash(ctx context.Context, name, email, passwordHash string) (user *User, err error) {
+	id, err := r.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	err = r.write(ctx, id, true, func(tx *memTx) error {
+		user, err = tx.insert(ctx, id, NewUser{Name: name, Email: email, PasswordHash: passwordHash})
+		return err
+	})
+	return user, err
+}
+
+func (r *Repository) InsertUser(ctx context.Context, nu NewUser) (user *User, err error) {
+	id, err := r.ids.NewID()
+	if err != nil {
+		return nil, err
+	}
+	err = r.write(ctx, id, true, func(tx *memTx) error {
+		user, err = tx.insert(ctx, id, nu)
+		return err
+	})
+	return user, err
+}
+
+func (r *Repository) UpdateUser(ctx context.Context, id string, upd UserUpdate) (user *User, err error) {
+	err = r.write(ctx, id, upd.Email != "", func(tx *memTx) error {
+		user, err = tx.UpdateUser(ctx, id, upd)
+		return err
+	})
+	return user, err
+}
+
+// DeleteUser soft-deletes a user. The record, including its email
+// reservation, is kept until PurgeDeletedUsers removes it. A non-zero
+// ifVersion must match the stored version, as for UserUpdate.IfVersion.
+func (r *Repository) DeleteUser(ctx context.Context, id string, ifVersion int64) error {
+	return r.write(ctx, id, false, func(tx *memTx) error {
+		return tx.DeleteUser(ctx, id, ifVersion)
+	})
+}
+
+// RestoreUser undoes a soft delete. Restoring an active user is a no-op.
+func (r *Repository) RestoreUser(ctx context.Context, id string) (user *User, err error) {
+	err = r.write(ctx, id, false, func(tx *memTx) error {
+		user, err = tx.RestoreUser(ctx, id)
+		return err
+	})
+	return user, err
+}
+
+// PurgeDeletedUsers permanently removes users soft-deleted before the
+// cutoff and returns how many were removed.
+func (r *Repository) PurgeDeletedUsers(ctx context.Context, before time.Time) (n int, err error) {
+	err = r.WithTx(ctx, func(tx Tx) error {
+		n, err = tx.PurgeDeletedUsers(ctx, before)
+		return err
+	})
+	return n, err
+}
+
+func (t *memTx) ListUsers(ctx context.Context, opts ListOptions) (*UserPage, error) {
+	opts, cur, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	return paginate(t.collect(ctx, opts), opts, cur), nil
+}
+
+// collect copies out every user of ctx's tenant matching opts' filters.
+func (t *memTx) collect(ctx context.Context, opts ListOptions) []User {
+	tenantID := tenant.ID(ctx)
+	var users []User
+	for i := range t.r.shards {
+		for _, u := range t.r.shards[i].users {
+			if u.TenantID == tenantID && opts.matches(u) {
+				users = append(users, *u.clone())
+			}
 		}
 	}
-	return nil, ErrNotFound
+	return users
 }
 
-func (r *Repository) CreateUser(ctx context.Context, name, email string) (*User, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (t *memTx) GetUser(ctx context.Context, id string) (*User, error) {
+	user, ok := t.r.lookupInLocked(tenant.ID(ctx), id)
+	if !ok || user.IsDeleted() {
+		return nil, ErrNotFound
+	}
+	return user.clone(), nil
+}
 
-	id := generateID()
-	now := time.Now()
+func (t *memTx) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	id, ok := t.r.emails[emailKey(tenant.ID(ctx), email)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t.GetUser(ctx, id)
+}
 
-	user := &User{
-		ID:        id,
-		Name:      name,
-		Email:     email,
-		CreatedAt: now,
-		UpdatedAt: now,
+func (t *memTx) CreateUser(ctx context.Context, name, email string) (*User, error) {
+	return t.InsertUser(ctx, NewUser{Name: name, Email: email})
+}
+
+func (t *memTx) CreateUserWithPassword(ctx context.Context, name, email, password string) (*User, error) {
+	// Fail fast so a conflict does not pay for the hash.
+	if t.r.emailTakenLocked(tenant.ID(ctx), email, "") {
+		return nil, ErrConflict
 	}
 
-	r.users[id] = user
-	return user, nil
+	hash, err := t.r.passwords.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	return t.InsertUser(ctx, NewUser{Name: name, Email: email, PasswordHash: hash})
 }
 
-func (r *Repository) CreateUserWithPassword(ctx context.Context, name, email, password string) (*User, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (t *memTx) CreateUserWithHash(ctx context.Context, name, email, passwordHash string) (*User, error) {
+	return t.InsertUser(ctx, NewUser{Name: name, Email: email, PasswordHash: passwordHash})
+}
 
-	// Check if email already exists
-	for _, u := range r.users {
-		if u.Email == email {
-			return nil, ErrConflict
-		}
+// InsertUser is insert with a freshly generated ID.
+func (t *memTx) InsertUser(ctx context.Context, nu NewUser) (*User, error) {
+	id, err := t.r.ids.NewID()
+	if err != nil {
+		return nil, err
 	}
+	return t.insert(ctx, id, nu)
+}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (t *memTx) insert(ctx context.Context, id string, nu NewUser) (*User, error) {
+	tenantID := tenant.ID(ctx)
+	if t.r.emailTakenLocked(tenantID, nu.Email, "") {
+		return nil, ErrConflict
+	}
+	metadata, err := normalizeMetadata(nu.Metadata)
 	if err != nil {
 		return nil, err
 	}
 
-	id := generateID()
 	now := time.Now()
-
 	user := &User{
 		ID:        id,
-		Name:      name,
-		Email:     email,
-		Password:  string(hashedPassword),
+		TenantID:  tenantID,
+		Name:      nu.Name,
+		Email:     nu.Email,
+		Password:  nu.PasswordHash,
+		Version:   1,
 		CreatedAt: now,
 		UpdatedAt: now,
+		Metadata:  metadata,
+		Roles:     cloneRoles(nu.Roles),
+	}
+	t.put(user)
+	return user.clone(), nil
+}
+
+func (t *memTx) UpdateUser(ctx context.Context, id string, upd UserUpdate) (*User, error) {
+	existing, ok := t.r.lookupInLocked(tenant.ID(ctx), id)
+	if !ok || existing.IsDeleted() {
+		return nil, ErrNotFound
+	}
+	if upd.IfVersion != 0 && upd.IfVersion != existing.Version {
+		return nil, ErrVersionMismatch
 	}
 
-	r.users[id] = user
-	return user, nil
+	// Stored users are never modified in place; build the new version on a copy.
+	user := *existing
+	if upd.Name != "" {
+		user.Name = upd.Name
+	}
+	if upd.Email != "" {
+		if t.r.emailTakenLocked(existing.TenantID, upd.Email, id) {
+			return nil, ErrConflict
+		}
+		user.Email = upd.Email
+	}
+	if upd.Metadata != nil {
+		metadata, err := normalizeMetadata(upd.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		user.Metadata = metadata
+	}
+	if upd.PasswordHash != "" {
+		user.Password = upd.PasswordHash
+	}
+	if upd.TokensValidAfter != nil {
+		at := *upd.TokensValidAfter
+		user.TokensValidAfter = &at
+	}
+	if upd.Roles != nil {
+		user.Roles = cloneRoles(upd.Roles)
+	}
+	user.Version++
+	user.UpdatedAt = time.Now()
+
+	t.put(&user)
+	return user.clone(), nil
 }
 
-func (r *Repository) UpdateUser(ctx context.Context, id, name, email string) (*User, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (t *memTx) DeleteUser(ctx context.Context, id string, ifVersion int64) error {
+	existing, ok := t.r.lookupInLocked(tenant.ID(ctx), id)
+	if !ok || existing.IsDeleted() {
+		return ErrNotFound
+	}
+	if ifVersion != 0 && ifVersion != existing.Version {
+		return ErrVersionMismatch
+	}
+
+	user := *existing
+	now := time.Now()
+	user.DeletedAt = &now
+	user.Version++
+	user.UpdatedAt = now
 
-	user, ok := r.users[id]
+	t.put(&user)
+	return nil
+}
+
+func (t *memTx) RestoreUser(ctx context.Context, id string) (*User, error) {
+	existing, ok := t.r.lookupInLocked(tenant.ID(ctx), id)
 	if !ok {
 		return nil, ErrNotFound
 	}
+	if !existing.IsDeleted() {
+		return existing.clone(), nil
+	}
+
+	user := *existing
+	user.DeletedAt = nil
+	user.Version++
+	user.UpdatedAt = time.Now()
+
+	t.put(&user)
+	return user.clone(), nil
+}
+
+func (t *memTx) PurgeDeletedUsers(ctx context.Context, before time.Time) (int, error) {
+	tenantID := tenant.ID(ctx)
+	var ids []string
+	for i := range t.r.shards {
+		for id, u := range t.r.shards[i].users {
+			if u.TenantID == tenantID && u.IsDeleted() && u.DeletedAt.Before(before) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	for _, id := range ids {
+		t.remove(id)
+	}
+	return len(ids), nil
+}
 
-	if name != "" {
-		user.Name = name
+// ScheduleErasure sets the time after which EraseDueUsers anonymizes a user,
+// or clears it when at is nil. Deleted users cannot be scheduled.
+func (r *Repository) ScheduleErasure(ctx context.Context, id string, at *time.Time) (user *User, err error) {
+	err = r.write(ctx, id, false, func(tx *memTx) error {
+		user, err = tx.ScheduleErasure(ctx, id, at)
+		return err
+	})
+	return user, err
+}
+
+// EraseDueUsers anonymizes every user whose erasure time is before the
+// cutoff, along with the audit entries about them, and returns their IDs.
+func (r *Repository) EraseDueUsers(ctx context.Context, before time.Time) (ids []string, err error) {
+	err = r.WithTx(ctx, func(tx Tx) error {
+		ids, err = tx.EraseDueUsers(ctx, before)
+		return err
+	})
+	return ids, err
+}
+
+func (t *memTx) ScheduleErasure(ctx context.Context, id string, at *time.Time) (*User, error) {
+	existing, ok := t.r.lookupInLocked(tenant.ID(ctx), id)
+	if !ok || existing.IsDeleted() {
+		return nil, ErrNotFound
 	}
-	if email != "" {
-		user.Email = email
+
+	user := *existing
+	if at != nil {
+		when := *at
+		user.EraseAt = &when
+	} else {
+		user.EraseAt = nil
 	}
+	user.Version++
 	user.UpdatedAt = time.Now()
 
-	return user, nil
+	t.put(&user)
+	return user.clone(), nil
 }
 
-func (r *Repository) DeleteUser(ctx context.Context, id string) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (t *memTx) EraseDueUsers(ctx context.Context, before time.Time) ([]string, error) {
+	tenantID := tenant.ID(ctx)
+	var due []*User
+	for i := range t.r.shards {
+		for _, u := range t.r.shards[i].users {
+			if u.TenantID == tenantID && u.EraseAt != nil && u.EraseAt.Before(before) {
+				due = append(due, u)
+			}
+		}
+	}
 
-	if _, ok := r.users[id]; !ok {
-		return ErrNotFound
+	now := time.Now()
+	ids := make([]string, 0, len(due))
+	for _, existing := range due {
+		user := *existing
+		anonymize(&user, now)
+		t.put(&user)
+		t.eraseAudit(user.ID)
+		t.deleteUserTokens(user.ID)
+		ids = append(ids, user.ID)
 	}
+	sort.Strings(ids)
+	return ids, nil
+}
 
-	delete(r.users, id)
-	return nil
+// defaultPasswords is the hasher of HashPassword, and of stores until
+// SetPasswordHasher is called.
+var defaultPasswords = password.Default()
+
+// HashPassword hashes a password for CreateUserWithHash with
+// password.Default. Services hash with the Hasher they are configured with.
+func HashPassword(pw string) (string, error) {
+	return defaultPasswords.Hash(pw)
 }
 
-// CheckPassword compares a hashed password with a plain text password.
-func (r *Repository) CheckPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// CheckPassword compares a hashed password with a plain text password,
+// using the hasher CreateUserWithPassword hashes with.
+func (r *Repository) CheckPassword(hashedPassword, pw string) bool {
+	ok, _ := r.passwords.Verify(hashedPassword, pw)
+	return ok
 }
 
-func generateID() string {
-	b := make([]byte, 16)
-	_, _ = rand.Read(b)
-	return hex.EncodeToString(b)
+func (t *memTx) CheckPassword(hashedPassword, pw string) bool {
+	return t.r.CheckPassword(hashedPassword, pw)
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
@@ -17,14 +18,15 @@ func SetupMiddleware(r interface{ Use(middlewares ...func(http.Handler) http.Han
 	r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(cors)
 	r.Use(secureHeaders)
-	r.Use(limitBody(1 << 20)) // 1MB max body
+	r.Use(limitBody(1<<20, importUsersPath)) // 1MB max body; imports set their own
 }
 
 func cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Link")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		if r.Method == http.MethodOptions {
@@ -44,10 +46,14 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
-func limitBody(maxBytes int64) func(http.Handler) http.Handler {
+// limitBody caps request bodies at maxBytes, except on the exempt paths,
+// whose handlers apply a limit of their own.
+func limitBody(maxBytes int64, exempt ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			if !slices.Contains(exempt, r.URL.Path) {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
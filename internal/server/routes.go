@@ -6,11 +6,26 @@ import (
 
 	"github.com/muflihunaf/boilerplate-go/internal/handler"
 	"github.com/muflihunaf/boilerplate-go/internal/middleware"
+	"github.com/muflihunaf/boilerplate-go/internal/rbac"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
 	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
 )
 
-// RegisterRoutes sets up all application routes.
-func RegisterRoutes(r *chi.Mux, h *handler.Handler, jwtSvc *jwt.Service) {
+// importUsersPath is exempt from the global body limit: imports of many
+// users are larger, and ImportUsers sets its own limit.
+const importUsersPath = "/api/v1/users/import"
+
+// Resource is an authenticated collection served under /api/v1/<Path>,
+// such as a crud.Handler with its Routes method.
+type Resource struct {
+	Path   string
+	Routes func(r chi.Router)
+}
+
+// RegisterRoutes sets up all application routes. Protected routes reject
+// tokens that revocations reports as revoked, and most of them require a
+// permission granted by the user's roles (see package rbac).
+func RegisterRoutes(r *chi.Mux, h *handler.Handler, jwtSvc *jwt.Service, revocations middleware.Revocations, resources ...Resource) {
 	// Health & docs (public)
 	r.Get("/health", h.Health)
 	r.Get("/ready", h.Health)
@@ -18,25 +33,64 @@ func RegisterRoutes(r *chi.Mux, h *handler.Handler, jwtSvc *jwt.Service) {
 		httpSwagger.URL("/swagger/doc.json"),
 	))
 
+	// Public keys for services that verify our tokens (public)
+	r.Get("/.well-known/jwks.json", handler.JWKS(jwtSvc.JWKS()))
+
+	// Malformed IDs are rejected before they reach a handler.
+	validID := middleware.ValidParam("id", repository.ValidID)
+
 	// API v1
 	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(middleware.Actor)
+
 		// Auth (public)
 		r.Post("/auth/login", h.Login)
 		r.Post("/auth/register", h.Register)
+		r.Post("/auth/refresh", h.Refresh)
+
+		// Organizations (public)
+		r.Post("/orgs", h.CreateOrganization)
+		r.Post("/invitations/accept", h.AcceptInvite)
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.Auth(jwtSvc))
+			r.Use(middleware.Auth(jwtSvc, revocations))
+			r.Post("/auth/logout", h.Logout)
 			r.Get("/me", h.Me)
+			r.Delete("/me", h.RequestErasure)
+			r.Get("/me/export", h.ExportMe)
+			r.Delete("/me/erasure", h.CancelErasure)
 
-			// Users CRUD
+			// Users CRUD. Users without UsersWrite may still update and
+			// delete themselves.
+			read := middleware.Require(rbac.UsersRead)
+			write := middleware.Require(rbac.UsersWrite)
 			r.Route("/users", func(r chi.Router) {
-				r.Get("/", h.ListUsers)
-				r.Post("/", h.CreateUser)
-				r.Get("/{id}", h.GetUser)
-				r.Put("/{id}", h.UpdateUser)
-				r.Delete("/{id}", h.DeleteUser)
+				r.With(read).Get("/", h.ListUsers)
+				r.With(write).Post("/", h.CreateUser)
+				r.With(write).Post("/import", h.ImportUsers)
+				r.With(read).Get("/export", h.ExportUsers)
+				r.Group(func(r chi.Router) {
+					r.Use(validID)
+					r.With(read).Get("/{id}", h.GetUser)
+					r.With(middleware.RequireSelfOr("id", rbac.UsersWrite)).Put("/{id}", h.UpdateUser)
+					r.With(middleware.RequireSelfOr("id", rbac.UsersWrite)).Delete("/{id}", h.DeleteUser)
+					r.With(write).Post("/{id}/restore", h.RestoreUser)
+				})
 			})
+
+			// Organizations
+			r.With(validID).Get("/orgs/{id}", h.GetOrganization)
+			r.With(validID, middleware.Require(rbac.OrgsInvite)).Post("/orgs/{id}/invitations", h.InviteMember)
+
+			// Resources built with package crud
+			for _, res := range resources {
+				r.Route("/"+res.Path, res.Routes)
+			}
+
+			// Admin
+			r.With(middleware.Require(rbac.AuditRead)).Get("/audit", h.ListAudit)
+			r.With(middleware.Require(rbac.CacheRead)).Get("/admin/cache", h.CacheStats)
 		})
 	})
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/muflihunaf/boilerplate-go/internal/config"
 	"github.com/muflihunaf/boilerplate-go/internal/handler"
+	"github.com/muflihunaf/boilerplate-go/internal/middleware"
 	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
 )
 
@@ -19,11 +20,12 @@ type Server struct {
 	log    *slog.Logger
 }
 
-// New creates a configured HTTP server.
-func New(cfg *config.Config, h *handler.Handler, jwtSvc *jwt.Service, log *slog.Logger) *Server {
+// New creates a configured HTTP server. Resources are served alongside the
+// built-in routes; see RegisterRoutes.
+func New(cfg *config.Config, h *handler.Handler, jwtSvc *jwt.Service, revocations middleware.Revocations, log *slog.Logger, resources ...Resource) *Server {
 	r := chi.NewRouter()
 	SetupMiddleware(r)
-	RegisterRoutes(r, h, jwtSvc)
+	RegisterRoutes(r, h, jwtSvc, revocations, resources...)
 
 	return &Server{
 		http: &http.Server{
@@ -2,32 +2,71 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/event"
+	"github.com/muflihunaf/boilerplate-go/internal/rbac"
 	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
 	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
 )
 
-// AuthResult contains the authentication result.
+// AuthResult contains the authentication result: a short-lived access
+// token and the refresh token that obtains the next one.
 type AuthResult struct {
-	Token     string
-	ExpiresAt time.Time
-	User      *repository.User
+	Token            string
+	ExpiresAt        time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	User             *repository.User
 }
 
 // AuthService handles authentication logic.
 type AuthService struct {
-	repo       *repository.Repository
+	repo       repository.Store
 	jwt        *jwt.Service
 	expiration time.Duration
+	refreshTTL time.Duration
+	passwords  *password.Hasher
+	admins     map[string]bool // lower-cased emails
 }
 
-// NewAuthService creates a new auth service.
-func NewAuthService(repo *repository.Repository, jwt *jwt.Service, exp time.Duration) *AuthService {
-	return &AuthService{repo: repo, jwt: jwt, expiration: exp}
+// NewAuthService creates a new auth service. Access tokens expire after exp
+// and refresh tokens after refreshTTL. Passwords are hashed with
+// password.Default until SetPasswordHasher is called.
+func NewAuthService(repo repository.Store, jwt *jwt.Service, exp, refreshTTL time.Duration) *AuthService {
+	return &AuthService{repo: repo, jwt: jwt, expiration: exp, refreshTTL: refreshTTL, passwords: password.Default()}
 }
 
-// Login authenticates a user and returns a token.
+// SetPasswordHasher makes s hash new passwords with h, which must still
+// verify the hashes already stored. Call it before s is used.
+func (s *AuthService) SetPasswordHasher(h *password.Hasher) {
+	s.passwords = h
+}
+
+// SetAdminEmails makes the users with these emails admins when they next
+// log in, in whichever tenant. It bootstraps admins for users who
+// registered before roles existed. Call it before s is used.
+func (s *AuthService) SetAdminEmails(emails []string) {
+	s.admins = make(map[string]bool, len(emails))
+	for _, email := range emails {
+		s.admins[strings.ToLower(strings.TrimSpace(email))] = true
+	}
+}
+
+// Login authenticates a user of the tenant in ctx and returns a token. A
+// password hash made with another algorithm or weaker parameters than the
+// current ones is replaced along the way, and users listed in
+// SetAdminEmails are made admins.
 func (s *AuthService) Login(ctx context.Context, email, password string) (*AuthResult, error) {
 	user, err := s.repo.GetUserByEmail(ctx, email)
 	if err != nil {
@@ -37,25 +76,238 @@ func (s *AuthService) Login(ctx context.Context, email, password string) (*AuthR
 		return nil, err
 	}
 
-	if !s.repo.CheckPassword(user.Password, password) {
+	ok, rehash := s.passwords.Verify(user.Password, password)
+	if !ok {
 		return nil, ErrInvalidCredentials
 	}
+	if rehash {
+		user = s.rehash(ctx, user, password)
+	}
+	if s.admins[strings.ToLower(user.Email)] && !slices.Contains(user.Roles, rbac.RoleAdmin) {
+		user = s.grantAdmin(ctx, user)
+	}
+
+	return s.createAuthResult(ctx, user)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token, which replaces it: every refresh token works once.
+// Presenting one that was already used means it was copied, so the whole
+// family descended from the same login is revoked, cutting off whoever
+// holds the current token too, and Refresh fails with
+// ErrInvalidRefreshToken.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*AuthResult, error) {
+	var result *AuthResult
+	var reused *repository.RefreshToken
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		rt, err := tx.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if rt.UsedAt != nil {
+			// Commit the revocation rather than roll it back with an error.
+			reused = rt
+			_, err := tx.RevokeTokenFamily(ctx, rt.FamilyID, now)
+			return err
+		}
+		if rt.RevokedAt != nil || !now.Before(rt.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+		if err := tx.UseRefreshToken(ctx, rt.ID, now); err != nil {
+			return err
+		}
+
+		// The user may have been deleted since the token was issued.
+		uctx := tenant.WithID(ctx, rt.TenantID)
+		user, err := tx.GetUser(uctx, rt.UserID)
+		if err != nil {
+			return err
+		}
+		result, err = s.issue(uctx, tx, user, rt.FamilyID)
+		return err
+	})
+	if err != nil {
+		if err == repository.ErrNotFound || err == repository.ErrConflict {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if reused != nil {
+		slog.Warn("refresh token reused, token family revoked",
+			"user_id", reused.UserID, "family_id", reused.FamilyID, "token_id", reused.ID)
+		return nil, ErrInvalidRefreshToken
+	}
+	return result, nil
+}
+
+// rehash stores a fresh hash of the user's password and returns the updated
+// user. A failure is logged rather than failing the login: the old hash
+// keeps working and the next login tries again.
+func (s *AuthService) rehash(ctx context.Context, user *repository.User, password string) *repository.User {
+	// Hash before the transaction so its locks are not held during the hash.
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		slog.Error("password rehash failed", "error", err, "user_id", user.ID)
+		return user
+	}
+
+	var updated *repository.User
+	err = s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		// IfVersion keeps a password changed since the read from being
+		// overwritten with the old one.
+		var err error
+		updated, err = tx.UpdateUser(ctx, user.ID, repository.UserUpdate{PasswordHash: hash, IfVersion: user.Version})
+		if err != nil {
+			return err
+		}
+		return recordAudit(selfActor(ctx, user.ID), tx, audit.ActionPasswordRehash, user, updated)
+	})
+	if err != nil {
+		slog.Warn("password rehash skipped", "error", err, "user_id", user.ID)
+		return user
+	}
+	return updated
+}
+
+// grantAdmin adds the admin role to user and returns the updated user. Like
+// rehash it only logs a failure; the next login tries again.
+func (s *AuthService) grantAdmin(ctx context.Context, user *repository.User) *repository.User {
+	var updated *repository.User
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		roles := append(slices.Clip(user.Roles), rbac.RoleAdmin)
+		var err error
+		updated, err = tx.UpdateUser(ctx, user.ID, repository.UserUpdate{Roles: roles, IfVersion: user.Version})
+		if err != nil {
+			return err
+		}
+		return recordAudit(selfActor(ctx, user.ID), tx, audit.ActionAdminGrant, user, updated)
+	})
+	if err != nil {
+		slog.Warn("admin grant skipped", "error", err, "user_id", user.ID)
+		return user
+	}
+	slog.Info("admin role granted", "user_id", user.ID)
+	return updated
+}
+
+// Logout revokes the access token described by claims until it expires.
+// If refreshToken is not empty and belongs to the same user, the refresh
+// token family it descends from is revoked too; any other refresh token is
+// ignored, so that logging out never fails for the client.
+func (s *AuthService) Logout(ctx context.Context, claims *jwt.Claims, refreshToken string) error {
+	return s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		if claims.ID != "" && claims.ExpiresAt != nil {
+			if err := tx.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+				return err
+			}
+		}
+		if refreshToken == "" {
+			return nil
+		}
+		rt, err := tx.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+		if err == repository.ErrNotFound || (err == nil && rt.UserID != claims.UserID) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.RevokeTokenFamily(ctx, rt.FamilyID, time.Now())
+		return err
+	})
+}
+
+// RevokeAllTokens signs a user of the tenant in ctx out everywhere: every
+// access token issued so far stops working and every refresh token is
+// revoked. Access tokens carry their issue time in whole seconds, so one
+// issued in the same second as the revocation is rejected too.
+func (s *AuthService) RevokeAllTokens(ctx context.Context, userID string) error {
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		before, err := tx.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		after, err := tx.UpdateUser(ctx, userID, repository.UserUpdate{TokensValidAfter: &now})
+		if err != nil {
+			return err
+		}
 
-	return s.createAuthResult(user)
+		tokens, err := tx.ListRefreshTokens(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for _, rt := range tokens {
+			if rt.RevokedAt != nil || rt.FamilyID != rt.ID {
+				continue // revoke each family once, by its first token
+			}
+			if _, err := tx.RevokeTokenFamily(ctx, rt.FamilyID, now); err != nil {
+				return err
+			}
+		}
+		return recordAudit(ctx, tx, audit.ActionTokensRevoke, before, after)
+	})
+	if err == repository.ErrNotFound {
+		return ErrUserNotFound
+	}
+	return err
 }
 
-// Register creates a new user and returns a token.
+// IsRevoked reports whether a valid access token has been revoked by Logout
+// or RevokeAllTokens. A token issued with other roles than the user holds
+// now counts as revoked too, so that role changes take effect at once and
+// clients refresh their token to pick them up. Tokens of users who have
+// been deleted or erased count as revoked as well.
+func (s *AuthService) IsRevoked(ctx context.Context, claims *jwt.Claims) (bool, error) {
+	if claims.ID != "" {
+		revoked, err := s.repo.IsAccessTokenRevoked(ctx, claims.ID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+
+	user, err := s.repo.GetUser(tenant.WithID(ctx, claims.TenantID), claims.UserID)
+	if err == repository.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !slices.Equal(claims.Roles, user.Roles) {
+		return true, nil
+	}
+	if user.TokensValidAfter == nil {
+		return false, nil
+	}
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return !issuedAt.After(user.TokensValidAfter.Truncate(time.Second)), nil
+}
+
+// Register creates a new user in the tenant in ctx and returns a token.
 func (s *AuthService) Register(ctx context.Context, name, email, password string) (*AuthResult, error) {
-	if existing, _ := s.repo.GetUserByEmail(ctx, email); existing != nil {
-		return nil, ErrConflict
+	// Hash before the transaction so its locks are not held during the hash.
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		return nil, err
 	}
 
-	user, err := s.repo.CreateUserWithPassword(ctx, name, email, password)
+	var user *repository.User
+	err = s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		var err error
+		user, err = registerUser(ctx, tx, name, email, hash)
+		return err
+	})
 	if err != nil {
+		if err == repository.ErrConflict {
+			return nil, ErrConflict
+		}
 		return nil, err
 	}
 
-	return s.createAuthResult(user)
+	return s.createAuthResult(ctx, user)
 }
 
 // GetCurrentUser returns the user for a given user ID.
@@ -70,15 +322,98 @@ func (s *AuthService) GetCurrentUser(ctx context.Context, userID string) (*repos
 	return user, nil
 }
 
-func (s *AuthService) createAuthResult(user *repository.User) (*AuthResult, error) {
-	token, err := s.jwt.GenerateToken(user.ID, user.Email)
+// registerUser creates a user with a password in the tenant in ctx and
+// records the registration. The first user of a tenant becomes its admin,
+// everyone after a member. Deleted users count, so that deleting the admin
+// does not hand the role to whoever registers next.
+func registerUser(ctx context.Context, tx repository.Tx, name, email, passwordHash string) (*repository.User, error) {
+	if _, err := tx.GetUserByEmail(ctx, email); err == nil {
+		return nil, ErrConflict
+	} else if err != repository.ErrNotFound {
+		return nil, err
+	}
+
+	existing, err := tx.ListUsers(ctx, repository.ListOptions{PerPage: 1, IncludeDeleted: true})
+	if err != nil {
+		return nil, err
+	}
+	role := rbac.RoleMember
+	if existing.Total == 0 {
+		role = rbac.RoleAdmin
+	}
+
+	user, err := tx.InsertUser(ctx, repository.NewUser{Name: name, Email: email, PasswordHash: passwordHash, Roles: []string{role}})
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAudit(selfActor(ctx, user.ID), tx, audit.ActionUserRegister, nil, user); err != nil {
+		return nil, err
+	}
+	err = event.Record(ctx, tx, event.UserRegistered{UserID: user.ID})
+	return user, err
+}
+
+// selfActor makes userID the actor of an anonymous request, such as a
+// registration, so that the audit log attributes it to the new user.
+func selfActor(ctx context.Context, userID string) context.Context {
+	actor := audit.ActorFrom(ctx)
+	if actor.UserID != "" {
+		return ctx
+	}
+	actor.UserID = userID
+	return audit.WithActor(ctx, actor)
+}
+
+// createAuthResult signs user in, starting a new refresh token family.
+func (s *AuthService) createAuthResult(ctx context.Context, user *repository.User) (*AuthResult, error) {
+	return s.issue(ctx, s.repo, user, "")
+}
+
+// issue creates an access token for user and stores a new refresh token in
+// the given family, or in a family of its own if familyID is empty.
+func (s *AuthService) issue(ctx context.Context, tokens repository.TokenStore, user *repository.User, familyID string) (*AuthResult, error) {
+	now := time.Now()
+	token, err := s.jwt.GenerateToken(user.ID, user.Email, user.TenantID, user.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := tokens.CreateRefreshToken(ctx, repository.RefreshToken{
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		TenantID:  user.TenantID,
+		TokenHash: hashRefreshToken(refresh),
+		ExpiresAt: now.Add(s.refreshTTL),
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	return &AuthResult{
-		Token:     token,
-		ExpiresAt: time.Now().Add(s.expiration),
-		User:      user,
+		Token:            token,
+		ExpiresAt:        now.Add(s.expiration),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: rt.ExpiresAt,
+		User:             user,
 	}, nil
 }
+
+// newRefreshToken returns 256 random bits, URL-safe encoded.
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken is what the store keeps instead of the token. The token
+// is random, so a fast unsalted hash is enough.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
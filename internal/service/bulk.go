@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/pkg/validator"
+)
+
+// ImportMode decides what happens to the valid rows of an import when
+// others fail.
+type ImportMode string
+
+const (
+	// ImportAtomic imports every row or, if any fails, none.
+	ImportAtomic ImportMode = "atomic"
+	// ImportBestEffort imports every row that can be imported.
+	ImportBestEffort ImportMode = "best_effort"
+)
+
+// Row outcomes reported in ImportResult.
+const (
+	RowCreated = "created" // the user was created
+	RowValid   = "valid"   // the row would be created, but was not: dry run or aborted import
+	RowFailed  = "failed"  // the row cannot be imported; see Error
+)
+
+// ImportRow is one user to import. ParseErr marks a row whose source could
+// not be decoded; it fails without further validation.
+type ImportRow struct {
+	Name     string         `validate:"required,max=255"`
+	Email    string         `validate:"required,email"`
+	Metadata map[string]any `validate:"-"`
+	ParseErr error          `validate:"-"`
+}
+
+// ImportOptions configures ImportUsers.
+type ImportOptions struct {
+	Mode ImportMode
+	// DryRun validates every row, including conflicts with existing users
+	// and between rows, without creating anyone.
+	DryRun bool
+}
+
+// RowResult is the outcome of one ImportRow. Row is 1-based.
+type RowResult struct {
+	Row    int
+	Email  string
+	Status string
+	UserID string
+	Error  string
+}
+
+// ImportResult summarizes an import.
+type ImportResult struct {
+	Mode    ImportMode
+	DryRun  bool
+	Created int
+	Failed  int
+	Rows    []RowResult
+}
+
+// errRollback aborts an import transaction without failing the import.
+var errRollback = errors.New("rollback")
+
+// ImportUsers creates a user for each row, recording each like CreateUser.
+// In atomic mode the rows are created in one transaction that is rolled
+// back if any row fails; in best-effort mode each row commits on its own.
+func (s *Service) ImportUsers(ctx context.Context, rows []ImportRow, opts ImportOptions) (*ImportResult, error) {
+	if opts.Mode == "" {
+		opts.Mode = ImportAtomic
+	}
+	if opts.Mode != ImportAtomic && opts.Mode != ImportBestEffort {
+		return nil, ErrInvalidInput
+	}
+
+	res := &ImportResult{Mode: opts.Mode, DryRun: opts.DryRun, Rows: make([]RowResult, len(rows))}
+	for i, row := range rows {
+		res.Rows[i] = RowResult{Row: i + 1, Email: row.Email}
+		if msg := s.checkImportRow(row); msg != "" {
+			res.Rows[i].Status, res.Rows[i].Error = RowFailed, msg
+		}
+	}
+
+	var err error
+	if opts.Mode == ImportBestEffort && !opts.DryRun {
+		err = s.importEach(ctx, rows, res)
+	} else {
+		err = s.importAll(ctx, rows, res, opts.DryRun)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range res.Rows {
+		switch r.Status {
+		case RowCreated:
+			res.Created++
+		case RowFailed:
+			res.Failed++
+		}
+	}
+	return res, nil
+}
+
+// importAll creates the rows in a single transaction and commits it only
+// if this is not a dry run and every row succeeded.
+func (s *Service) importAll(ctx context.Context, rows []ImportRow, res *ImportResult, dryRun bool) error {
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		failed := false
+		for i, row := range rows {
+			r := &res.Rows[i]
+			if r.Status == RowFailed {
+				failed = true
+				continue
+			}
+			user, err := createUser(ctx, tx, repository.NewUser{Name: row.Name, Email: row.Email, Metadata: row.Metadata})
+			if err != nil {
+				if err != repository.ErrConflict {
+					return err
+				}
+				r.Status, r.Error, failed = RowFailed, "email already exists", true
+				continue
+			}
+			r.Status, r.UserID = RowCreated, user.ID
+		}
+		if failed || dryRun {
+			return errRollback
+		}
+		return nil
+	})
+	if err == errRollback {
+		// Nothing was kept: report would-be creations as merely valid.
+		for i := range res.Rows {
+			if r := &res.Rows[i]; r.Status == RowCreated {
+				r.Status, r.UserID = RowValid, ""
+			}
+		}
+		return nil
+	}
+	return err
+}
+
+// importEach creates every valid row in a transaction of its own.
+func (s *Service) importEach(ctx context.Context, rows []ImportRow, res *ImportResult) error {
+	for i, row := range rows {
+		r := &res.Rows[i]
+		if r.Status == RowFailed {
+			continue
+		}
+		user, err := s.CreateUser(ctx, row.Name, row.Email, row.Metadata)
+		if err != nil {
+			if err != ErrConflict {
+				return err
+			}
+			r.Status, r.Error = RowFailed, "email already exists"
+			continue
+		}
+		r.Status, r.UserID = RowCreated, user.ID
+	}
+	return nil
+}
+
+// checkImportRow returns why row cannot be imported, or "".
+func (s *Service) checkImportRow(row ImportRow) string {
+	if row.ParseErr != nil {
+		return row.ParseErr.Error()
+	}
+	var msgs []string
+	if err := validator.Validate(row); err != nil {
+		for field, msg := range validator.ValidationErrors(err) {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", strings.ToLower(field), msg))
+		}
+	}
+	var verr *ValidationError
+	if errors.As(s.checkMetadata(row.Metadata), &verr) {
+		for field, msg := range verr.Fields {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", strings.TrimPrefix(field, "/"), msg))
+		}
+	}
+	if len(msgs) == 0 {
+		return ""
+	}
+	sort.Strings(msgs)
+	return strings.Join(msgs, "; ")
+}
+
+// exportPageSize is how many users ExportUsers reads at a time.
+const exportPageSize = repository.MaxPerPage
+
+// ExportUsers calls fn for every user matching opts' filters, oldest
+// first. Users are read a page at a time, so the full set is never held in
+// memory. Pagination and sort fields of opts are ignored.
+func (s *Service) ExportUsers(ctx context.Context, opts repository.ListOptions, fn func(u *repository.User) error) error {
+	opts.Page, opts.PerPage, opts.Cursor = 0, exportPageSize, ""
+	opts.Sort, opts.Desc = repository.SortCreatedAt, false
+
+	for {
+		page, err := s.repo.ListUsers(ctx, opts)
+		if err == repository.ErrEncryptedField {
+			return ErrEncryptedField
+		}
+		if err != nil {
+			return err
+		}
+		for i := range page.Users {
+			if err := fn(&page.Users[i]); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+	"github.com/muflihunaf/boilerplate-go/pkg/jwt"
+)
+
+// Invitation is a signed, self-contained invitation to join an
+// organization. Nothing is stored: the token itself is the invitation.
+type Invitation struct {
+	Token          string
+	OrganizationID string
+	Email          string
+	ExpiresAt      time.Time
+}
+
+// OrgService manages organizations and their members.
+type OrgService struct {
+	repo      repository.Store
+	auth      *AuthService
+	jwt       *jwt.Service
+	inviteTTL time.Duration
+}
+
+// NewOrgService creates a new organization service. Invitations expire
+// after inviteTTL.
+func NewOrgService(repo repository.Store, auth *AuthService, jwt *jwt.Service, inviteTTL time.Duration) *OrgService {
+	return &OrgService{repo: repo, auth: auth, jwt: jwt, inviteTTL: inviteTTL}
+}
+
+// CreateOrganization creates an organization together with its first
+// member and signs that member in.
+func (s *OrgService) CreateOrganization(ctx context.Context, name, ownerName, ownerEmail, ownerPassword string) (*repository.Organization, *AuthResult, error) {
+	hash, err := s.auth.passwords.Hash(ownerPassword)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var org *repository.Organization
+	var owner *repository.User
+	err = s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		var err error
+		if org, err = tx.CreateOrganization(ctx, name); err != nil {
+			return err
+		}
+		octx := tenant.WithID(ctx, org.ID)
+		if owner, err = registerUser(octx, tx, ownerName, ownerEmail, hash); err != nil {
+			return err
+		}
+		changes := []repository.FieldChange{{Field: "name", To: org.Name}}
+		return tx.AppendAudit(octx, audit.Entry(selfActor(octx, owner.ID), audit.ActionOrgCreate, org.ID, changes))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := s.auth.createAuthResult(ctx, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	return org, result, nil
+}
+
+// GetOrganization returns the organization with the given ID, which must
+// be the tenant in ctx: other organizations are reported as not found.
+func (s *OrgService) GetOrganization(ctx context.Context, id string) (*repository.Organization, error) {
+	if id == tenant.Default || id != tenant.ID(ctx) {
+		return nil, ErrNotFound
+	}
+	org, err := s.repo.GetOrganization(ctx, id)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return org, nil
+}
+
+// Invite invites email to join the organization, which must be the tenant
+// in ctx. It fails with ErrConflict if email is already a member.
+func (s *OrgService) Invite(ctx context.Context, orgID, email string) (*Invitation, error) {
+	if _, err := s.GetOrganization(ctx, orgID); err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.GetUserByEmail(ctx, email); err == nil {
+		return nil, ErrConflict
+	} else if err != repository.ErrNotFound {
+		return nil, err
+	}
+
+	token, expiresAt, err := s.jwt.GenerateInvite(orgID, email, s.inviteTTL)
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		changes := []repository.FieldChange{{Field: "email", To: email}}
+		return tx.AppendAudit(ctx, audit.Entry(ctx, audit.ActionOrgInvite, orgID, changes))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Invitation{Token: token, OrganizationID: orgID, Email: email, ExpiresAt: expiresAt}, nil
+}
+
+// AcceptInvite registers the invited user in the inviting organization
+// and signs them in.
+func (s *OrgService) AcceptInvite(ctx context.Context, token, name, password string) (*AuthResult, error) {
+	claims, err := s.jwt.ValidateInvite(token)
+	if err != nil {
+		return nil, ErrInvalidInvite
+	}
+	ctx = tenant.WithID(ctx, claims.TenantID)
+	if _, err := s.repo.GetOrganization(ctx, claims.TenantID); err != nil {
+		if err == repository.ErrNotFound {
+			return nil, ErrInvalidInvite
+		}
+		return nil, err
+	}
+	return s.auth.Register(ctx, name, claims.Email, password)
+}
+
+// tenantIDs lists every tenant: the default one and each organization.
+func (s *Service) tenantIDs(ctx context.Context) ([]string, error) {
+	orgs, err := s.repo.ListOrganizations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{tenant.Default}
+	for _, org := range orgs {
+		ids = append(ids, org.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/event"
+	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// PersonalData is everything held about one user.
+type PersonalData struct {
+	ExportedAt   time.Time
+	Profile      *repository.User
+	Organization *repository.Organization // nil in the default tenant
+	// Audit holds the entries about the user and those of the user's own
+	// actions. Actions on other users omit the changes, which are about
+	// those users rather than this one.
+	Audit []repository.AuditEntry
+	// Sessions are the user's refresh tokens, one family per login.
+	Sessions []repository.RefreshToken
+}
+
+// ExportPersonalData gathers the data held about a user of the tenant in ctx.
+func (s *Service) ExportPersonalData(ctx context.Context, userID string) (*PersonalData, error) {
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	data := &PersonalData{ExportedAt: time.Now().UTC(), Profile: user}
+
+	if id := tenant.ID(ctx); id != tenant.Default {
+		if data.Organization, err = s.repo.GetOrganization(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	about, err := s.allAudit(ctx, repository.AuditFilter{TargetID: userID})
+	if err != nil {
+		return nil, err
+	}
+	by, err := s.allAudit(ctx, repository.AuditFilter{ActorID: userID})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int64]bool, len(about))
+	for _, e := range about {
+		seen[e.ID] = true
+	}
+	data.Audit = about
+	for _, e := range by {
+		if seen[e.ID] {
+			continue
+		}
+		e.Changes = nil
+		data.Audit = append(data.Audit, e)
+	}
+	sort.Slice(data.Audit, func(i, j int) bool { return data.Audit[i].ID < data.Audit[j].ID })
+
+	if data.Sessions, err = s.repo.ListRefreshTokens(ctx, userID); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// allAudit follows ListAudit's pages to the end.
+func (s *Service) allAudit(ctx context.Context, f repository.AuditFilter) ([]repository.AuditEntry, error) {
+	f.Limit = repository.MaxAuditLimit
+	var all []repository.AuditEntry
+	for {
+		entries, next, err := s.repo.ListAudit(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, entries...)
+		if next == 0 {
+			return all, nil
+		}
+		f.BeforeID = next
+	}
+}
+
+// RequestErasure schedules a user's personal data for erasure once grace
+// has passed, confirmed by the user's password. Until then the account
+// works as before and CancelErasure can call it off. Asking again while an
+// erasure is pending keeps the original date.
+func (s *Service) RequestErasure(ctx context.Context, userID, password string, grace time.Duration) (*repository.User, error) {
+	// Check the password before the transaction so its locks are not held
+	// during the hash.
+	user, err := s.repo.GetUser(ctx, userID)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if ok, _ := s.passwords.Verify(user.Password, password); !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	err = s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		before, err := tx.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if before.EraseAt != nil {
+			user = before
+			return nil
+		}
+		at := time.Now().Add(grace)
+		if user, err = tx.ScheduleErasure(ctx, userID, &at); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, audit.ActionEraseRequest, before, user)
+	})
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// CancelErasure calls off a pending erasure. It returns ErrNotFound if
+// none is pending.
+func (s *Service) CancelErasure(ctx context.Context, userID string) (*repository.User, error) {
+	var user *repository.User
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		before, err := tx.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if before.EraseAt == nil {
+			return repository.ErrNotFound
+		}
+		if user, err = tx.ScheduleErasure(ctx, userID, nil); err != nil {
+			return err
+		}
+		return recordAudit(ctx, tx, audit.ActionEraseCancel, before, user)
+	})
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// EraseDueUsers anonymizes the users of the tenant in ctx whose grace
+// period has passed. The audit entries and events it records name the
+// user by ID only.
+func (s *Service) EraseDueUsers(ctx context.Context) (int, error) {
+	var n int
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		ids, err := tx.EraseDueUsers(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := tx.AppendAudit(ctx, audit.Entry(ctx, audit.ActionUserErase, id, nil)); err != nil {
+				return err
+			}
+			if err := event.Record(ctx, tx, event.UserErased{UserID: id}); err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/muflihunaf/boilerplate-go/internal/tenant"
+)
+
+// Purger periodically erases users whose erasure grace period has passed,
+// removes soft-deleted users whose retention window has passed and removes
+// expired tokens.
+type Purger struct {
+	svc       *Service
+	retention time.Duration
+	interval  time.Duration
+	log       *slog.Logger
+}
+
+// NewPurger creates a purger that runs every interval.
+func NewPurger(svc *Service, retention, interval time.Duration, log *slog.Logger) *Purger {
+	return &Purger{svc: svc, retention: retention, interval: interval, log: log}
+}
+
+// Run purges once immediately and then on every tick until ctx is cancelled.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Purger) purge(ctx context.Context) {
+	p.purgeUsers(ctx)
+	p.purgeTokens(ctx)
+}
+
+// purgeUsers runs EraseDueUsers and PurgeDeletedUsers once per tenant.
+func (p *Purger) purgeUsers(ctx context.Context) {
+	tenants, err := p.svc.tenantIDs(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.log.Error("list tenants failed", "error", err)
+		}
+		return
+	}
+	for _, id := range tenants {
+		tctx := tenant.WithID(ctx, id)
+		erased, err := p.svc.EraseDueUsers(tctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				p.log.Error("erase users failed", "tenant", id, "error", err)
+			}
+		} else if erased > 0 {
+			p.log.Info("erased users", "tenant", id, "count", erased)
+		}
+
+		n, err := p.svc.PurgeDeletedUsers(tctx, p.retention)
+		if err != nil {
+			if ctx.Err() == nil {
+				p.log.Error("purge deleted users failed", "tenant", id, "error", err)
+			}
+			continue
+		}
+		if n > 0 {
+			p.log.Info("purged deleted users", "tenant", id, "count", n)
+		}
+	}
+}
+
+func (p *Purger) purgeTokens(ctx context.Context) {
+	n, err := p.svc.PurgeExpiredTokens(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.log.Error("purge expired tokens failed", "error", err)
+		}
+		return
+	}
+	if n > 0 {
+		p.log.Info("purged expired tokens", "count", n)
+	}
+}
+
+// PurgeExpiredTokens removes the refresh tokens and access token
+// revocations of every tenant that have expired. Used refresh tokens are
+// kept until then so that their reuse is still recognized.
+func (s *Service) PurgeExpiredTokens(ctx context.Context) (int, error) {
+	return s.repo.PurgeExpiredTokens(ctx, time.Now())
+}
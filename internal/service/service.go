@@ -4,23 +4,80 @@ import (
 	"errors"
 
 	"github.com/muflihunaf/boilerplate-go/internal/repository"
+	"github.com/muflihunaf/boilerplate-go/pkg/jsonschema"
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
 )
 
 // Common service errors.
 var (
-	ErrNotFound           = errors.New("resource not found")
-	ErrInvalidInput       = errors.New("invalid input")
-	ErrConflict           = errors.New("resource conflict")
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserNotFound       = errors.New("user not found")
+	ErrNotFound            = errors.New("resource not found")
+	ErrInvalidInput        = errors.New("invalid input")
+	ErrConflict            = errors.New("resource conflict")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrInvalidInvite       = errors.New("invalid or expired invitation")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrEncryptedField      = errors.New("cannot filter or sort on an encrypted field")
+	ErrForbidden           = errors.New("forbidden")
+	ErrInvalidRole         = errors.New("unknown role")
 )
 
+// ValidationError rejects input field by field. Fields maps a JSON Pointer
+// into the request body, such as "/metadata/plan", to what is wrong there.
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return "validation failed"
+}
+
 // Service handles business logic.
 type Service struct {
-	repo *repository.Repository
+	repo           repository.Store
+	metadataSchema *jsonschema.Schema
+	passwords      *password.Hasher
 }
 
 // New creates a new service.
-func New(repo *repository.Repository) *Service {
-	return &Service{repo: repo}
+func New(repo repository.Store) *Service {
+	return &Service{repo: repo, passwords: password.Default()}
+}
+
+// SetPasswordHasher makes s verify passwords with h. Call it before s is
+// used.
+func (s *Service) SetPasswordHasher(h *password.Hasher) {
+	s.passwords = h
+}
+
+// SetMetadataSchema makes CreateUser, UpdateUser and ImportUsers validate
+// user metadata against schema. Without one any JSON object is accepted.
+// Call it before s is used.
+func (s *Service) SetMetadataSchema(schema *jsonschema.Schema) {
+	s.metadataSchema = schema
+}
+
+// checkMetadata validates the metadata a user would be left with. A user
+// without metadata is checked as the empty object.
+func (s *Service) checkMetadata(metadata map[string]any) error {
+	if s.metadataSchema == nil {
+		return nil
+	}
+	var doc any = metadata
+	if metadata == nil {
+		doc = map[string]any{}
+	}
+	errs := s.metadataSchema.Validate(doc)
+	if len(errs) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(errs))
+	for _, e := range errs {
+		key := "/metadata" + e.Path
+		if _, dup := fields[key]; !dup {
+			fields[key] = e.Message
+		}
+	}
+	return &ValidationError{Fields: fields}
 }
@@ -2,12 +2,29 @@ package service
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
 
+	"github.com/muflihunaf/boilerplate-go/internal/audit"
+	"github.com/muflihunaf/boilerplate-go/internal/event"
+	"github.com/muflihunaf/boilerplate-go/internal/rbac"
 	"github.com/muflihunaf/boilerplate-go/internal/repository"
 )
 
-func (s *Service) ListUsers(ctx context.Context) ([]repository.User, error) {
-	return s.repo.ListUsers(ctx)
+func (s *Service) ListUsers(ctx context.Context, opts repository.ListOptions) (*repository.UserPage, error) {
+	page, err := s.repo.ListUsers(ctx, opts)
+	if err != nil {
+		if err == repository.ErrInvalidCursor || err == repository.ErrInvalidSort || err == repository.ErrInvalidMetadataKey {
+			return nil, ErrInvalidInput
+		}
+		if err == repository.ErrEncryptedField {
+			return nil, ErrEncryptedField
+		}
+		return nil, err
+	}
+	return page, nil
 }
 
 func (s *Service) GetUser(ctx context.Context, id string) (*repository.User, error) {
@@ -21,26 +38,204 @@ func (s *Service) GetUser(ctx context.Context, id string) (*repository.User, err
 	return user, nil
 }
 
-func (s *Service) CreateUser(ctx context.Context, name, email string) (*repository.User, error) {
-	// Add business logic here (e.g., validation, uniqueness check)
-	return s.repo.CreateUser(ctx, name, email)
+// CreateUser creates a user without a password. A schema set with
+// SetMetadataSchema is enforced, failing with a *ValidationError.
+func (s *Service) CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*repository.User, error) {
+	if err := s.checkMetadata(metadata); err != nil {
+		return nil, err
+	}
+	var user *repository.User
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		var err error
+		user, err = createUser(ctx, tx, repository.NewUser{Name: name, Email: email, Metadata: metadata})
+		return err
+	})
+	if err != nil {
+		if err == repository.ErrConflict {
+			return nil, ErrConflict
+		}
+		if errors.Is(err, repository.ErrInvalidMetadata) {
+			return nil, ErrInvalidInput
+		}
+		return nil, err
+	}
+	return user, nil
 }
 
-func (s *Service) UpdateUser(ctx context.Context, id, name, email string) (*repository.User, error) {
-	user, err := s.repo.UpdateUser(ctx, id, name, email)
+// UpdateUser applies a partial update. New metadata replaces the old as a
+// whole and is validated like CreateUser's. Roles must be known to package
+// rbac; see AuthService.IsRevoked for how a change reaches access tokens.
+func (s *Service) UpdateUser(ctx context.Context, id string, upd repository.UserUpdate) (*repository.User, error) {
+	if upd.Metadata != nil {
+		if err := s.checkMetadata(upd.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	if upd.Roles != nil {
+		upd.Roles = uniqueRoles(upd.Roles)
+		for _, role := range upd.Roles {
+			if !rbac.Valid(role) {
+				return nil, ErrInvalidRole
+			}
+		}
+	}
+	var user *repository.User
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		before, err := tx.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user, err = tx.UpdateUser(ctx, id, upd); err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, tx, audit.ActionUserUpdate, before, user); err != nil {
+			return err
+		}
+		return event.Record(ctx, tx, userUpdated(user))
+	})
 	if err != nil {
 		if err == repository.ErrNotFound {
 			return nil, ErrNotFound
 		}
+		if err == repository.ErrConflict {
+			return nil, ErrConflict
+		}
+		if err == repository.ErrVersionMismatch {
+			return nil, ErrPreconditionFailed
+		}
+		if errors.Is(err, repository.ErrInvalidMetadata) {
+			return nil, ErrInvalidInput
+		}
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *Service) DeleteUser(ctx context.Context, id string) error {
-	err := s.repo.DeleteUser(ctx, id)
+// DeleteUser soft-deletes a user. A non-zero ifVersion must match the
+// stored version or ErrPreconditionFailed is returned.
+func (s *Service) DeleteUser(ctx context.Context, id string, ifVersion int64) error {
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		before, err := tx.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteUser(ctx, id, ifVersion); err != nil {
+			return err
+		}
+		// GetUser hides deleted users, so derive the new state; inside the
+		// transaction it is exactly what DeleteUser wrote.
+		after := *before
+		now := time.Now()
+		after.DeletedAt = &now
+		after.Version++
+		if err := recordAudit(ctx, tx, audit.ActionUserDelete, before, &after); err != nil {
+			return err
+		}
+		return event.Record(ctx, tx, event.UserDeleted{UserID: id})
+	})
 	if err == repository.ErrNotFound {
 		return ErrNotFound
 	}
+	if err == repository.ErrVersionMismatch {
+		return ErrPreconditionFailed
+	}
 	return err
 }
+
+// RestoreUser undoes a soft delete. Restoring an active user is a no-op and
+// records nothing.
+func (s *Service) RestoreUser(ctx context.Context, id string) (*repository.User, error) {
+	var user *repository.User
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		active, err := tx.GetUser(ctx, id)
+		if err == nil {
+			user = active
+			return nil
+		}
+		if err != repository.ErrNotFound {
+			return err
+		}
+		if user, err = tx.RestoreUser(ctx, id); err != nil {
+			return err
+		}
+		// Only whether it was deleted matters to the diff, not since when.
+		before := *user
+		before.DeletedAt = &user.UpdatedAt
+		before.Version--
+		if err := recordAudit(ctx, tx, audit.ActionUserRestore, &before, user); err != nil {
+			return err
+		}
+		return event.Record(ctx, tx, userUpdated(user))
+	})
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// PurgeDeletedUsers permanently removes users of the tenant in ctx that
+// were soft-deleted more than retention ago.
+func (s *Service) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int, error) {
+	var n int
+	err := s.repo.WithTx(ctx, func(tx repository.Tx) error {
+		var err error
+		if n, err = tx.PurgeDeletedUsers(ctx, time.Now().Add(-retention)); err != nil || n == 0 {
+			return err
+		}
+		changes := []repository.FieldChange{{Field: "count", To: strconv.Itoa(n)}}
+		return tx.AppendAudit(ctx, audit.Entry(ctx, audit.ActionUsersPurge, "", changes))
+	})
+	return n, err
+}
+
+// createUser creates a user without a password and records the change.
+// Unless nu says otherwise the user is a member.
+func createUser(ctx context.Context, tx repository.Tx, nu repository.NewUser) (*repository.User, error) {
+	if nu.Roles == nil {
+		nu.Roles = []string{rbac.RoleMember}
+	}
+	user, err := tx.InsertUser(ctx, nu)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAudit(ctx, tx, audit.ActionUserCreate, nil, user); err != nil {
+		return nil, err
+	}
+	err = event.Record(ctx, tx, event.UserRegistered{UserID: user.ID})
+	return user, err
+}
+
+// uniqueRoles copies roles without duplicates. The copy is never nil, so
+// that an empty one still removes every role in a UserUpdate.
+func uniqueRoles(roles []string) []string {
+	out := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !slices.Contains(out, role) {
+			out = append(out, role)
+		}
+	}
+	return out
+}
+
+func userUpdated(u *repository.User) event.UserUpdated {
+	return event.UserUpdated{UserID: u.ID, Version: u.Version}
+}
+
+// recordAudit adds an audit entry for a write to user before -> after,
+// attributed to the actor in ctx.
+func recordAudit(ctx context.Context, tx repository.Tx, action string, before, after *repository.User) error {
+	target := after
+	if target == nil {
+		target = before
+	}
+	return tx.AppendAudit(ctx, audit.Entry(ctx, action, target.ID, audit.Diff(before, after)))
+}
+
+// ListAudit returns audit entries matching f, newest first, and the cursor
+// for the next page if there is one.
+func (s *Service) ListAudit(ctx context.Context, f repository.AuditFilter) ([]repository.AuditEntry, int64, error) {
+	return s.repo.ListAudit(ctx, f)
+}
@@ -0,0 +1,24 @@
+// Package tenant carries the organization a request acts for. Stores read
+// it from the context and scope every user query to it, so code above the
+// repository cannot reach another tenant's data by mistake.
+package tenant
+
+import "context"
+
+// Default is the tenant of users that belong to no organization, such as
+// those created before organizations existed or registered without an
+// invitation.
+const Default = ""
+
+type key struct{}
+
+// WithID returns a context acting for the tenant with the given ID.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// ID returns the tenant ctx acts for, or Default.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
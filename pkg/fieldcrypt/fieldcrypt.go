@@ -0,0 +1,239 @@
+// Package fieldcrypt encrypts individual values for storage with envelope
+// encryption, and derives blind indexes for looking them up by equality.
+//
+// Every value is sealed with a fresh data key under AES-256-GCM, and that
+// data key is itself sealed with a versioned key-encryption key from a
+// Keyring. The result is a self-describing string:
+//
+//	enc:v<version>:<base64url(wrapped data key | nonce | ciphertext)>
+//
+// so values written under an older key stay readable after a new one is
+// added, and ReencryptNeeded tells which ones to migrate.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeySize is the length of key-encryption, data and index keys in bytes.
+const KeySize = 32
+
+const prefix = "enc:v"
+
+var (
+	ErrMalformed  = errors.New("fieldcrypt: malformed encrypted value")
+	ErrUnknownKey = errors.New("fieldcrypt: value sealed with an unknown key version")
+	ErrDecrypt    = errors.New("fieldcrypt: message authentication failed")
+)
+
+// Keyring holds the key-encryption keys by version and the blind index key.
+// New values are always sealed with the highest version.
+type Keyring struct {
+	keks    map[uint32]cipher.AEAD
+	current uint32
+	index   []byte
+}
+
+// New builds a Keyring from key-encryption keys by version and an index
+// key. Every key must be KeySize bytes.
+func New(keys map[uint32][]byte, indexKey []byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("fieldcrypt: no encryption keys")
+	}
+	if len(indexKey) != KeySize {
+		return nil, fmt.Errorf("fieldcrypt: index key must be %d bytes", KeySize)
+	}
+	k := &Keyring{keks: make(map[uint32]cipher.AEAD, len(keys)), index: indexKey}
+	for version, key := range keys {
+		if version == 0 {
+			return nil, errors.New("fieldcrypt: key versions start at 1")
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("fieldcrypt: key version %d must be %d bytes", version, KeySize)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keks[version] = aead
+		k.current = max(k.current, version)
+	}
+	return k, nil
+}
+
+// ParseKeys parses "version:base64key" items, as configured in the
+// environment, into the form New takes.
+func ParseKeys(items []string) (map[uint32][]byte, error) {
+	keys := make(map[uint32][]byte, len(items))
+	for _, item := range items {
+		v, b64, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, errors.New("fieldcrypt: keys must be given as version:base64key")
+		}
+		version, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: invalid key version %q", v)
+		}
+		if _, dup := keys[uint32(version)]; dup {
+			return nil, fmt.Errorf("fieldcrypt: key version %d given twice", version)
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: key version %d is not valid base64", version)
+		}
+		keys[uint32(version)] = key
+	}
+	return keys, nil
+}
+
+// Current returns the key version new values are sealed with.
+func (k *Keyring) Current() uint32 {
+	return k.current
+}
+
+// Encrypt seals plaintext with a new data key under the current key.
+// The context (e.g. a field name and record ID) is authenticated but not
+// stored: Decrypt must be given the same one, so a value copied to another
+// field or record does not decrypt.
+func (k *Keyring) Encrypt(plaintext, context string) (string, error) {
+	dek := make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", fmt.Errorf("fieldcrypt: generate data key: %w", err)
+	}
+	data, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+
+	kek := k.keks[k.current]
+	out, err := seal(kek, nil, dek, []byte(context))
+	if err != nil {
+		return "", err
+	}
+	out, err = seal(data, out, []byte(plaintext), []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return prefix + strconv.FormatUint(uint64(k.current), 10) + ":" + base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// Decrypt opens a value made by Encrypt with the same context.
+func (k *Keyring) Decrypt(value, context string) (string, error) {
+	version, body, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	kek, ok := k.keks[version]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	wrapped := kek.NonceSize() + KeySize + kek.Overhead()
+	if len(raw) < wrapped {
+		return "", ErrMalformed
+	}
+	dek, err := open(kek, raw[:wrapped], []byte(context))
+	if err != nil {
+		return "", err
+	}
+	data, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(data, raw[wrapped:], []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value is in the form Encrypt produces.
+// It does not check that it decrypts, and plaintext can take that form
+// too: callers that store both must escape plaintext that looks encrypted.
+func IsEncrypted(value string) bool {
+	_, _, err := parse(value)
+	return err == nil
+}
+
+// ReencryptNeeded reports whether value is plaintext or was sealed with a
+// key older than the current one.
+func (k *Keyring) ReencryptNeeded(value string) bool {
+	version, _, err := parse(value)
+	return err != nil || version != k.current
+}
+
+// BlindIndex returns a keyed hash of value for equality lookups. Equal
+// values give equal indexes, but the index reveals nothing else about the
+// value without the index key. It does not depend on the key version, so
+// adding an encryption key does not invalidate existing indexes.
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parse splits an encrypted value into its key version and payload.
+func parse(value string) (uint32, string, error) {
+	rest, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return 0, "", ErrMalformed
+	}
+	v, body, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, "", ErrMalformed
+	}
+	version, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || version == 0 {
+		return 0, "", ErrMalformed
+	}
+	return uint32(version), body, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: %w", err)
+	}
+	return aead, nil
+}
+
+// seal appends a random nonce and the sealed plaintext to dst.
+func seal(aead cipher.AEAD, dst, plaintext, ad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: generate nonce: %w", err)
+	}
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, ad), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, sealed, ad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrMalformed
+	}
+	n := aead.NonceSize()
+	plaintext, err := aead.Open(nil, sealed[:n], sealed[n:], ad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,138 @@
+package fieldcrypt_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/fieldcrypt"
+)
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, fieldcrypt.KeySize)
+}
+
+func newKeyring(t *testing.T, versions ...uint32) *fieldcrypt.Keyring {
+	t.Helper()
+	keys := make(map[uint32][]byte)
+	for _, v := range versions {
+		keys[v] = key(byte(v))
+	}
+	k, err := fieldcrypt.New(keys, key(0xff))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return k
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := newKeyring(t, 1)
+
+	a, err := k.Encrypt("ann@example.com", "email\x00u1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	b, _ := k.Encrypt("ann@example.com", "email\x00u1")
+	if a == b {
+		t.Error("expected encryption to be randomized")
+	}
+	if !strings.HasPrefix(a, "enc:v1:") || strings.Contains(a, "ann") {
+		t.Errorf("unexpected ciphertext %q", a)
+	}
+	if !fieldcrypt.IsEncrypted(a) || fieldcrypt.IsEncrypted("ann@example.com") {
+		t.Error("IsEncrypted does not tell ciphertext from plaintext")
+	}
+
+	got, err := k.Decrypt(a, "email\x00u1")
+	if err != nil || got != "ann@example.com" {
+		t.Fatalf("Decrypt: got %q, %v", got, err)
+	}
+	if _, err := k.Decrypt(a, "email\x00u2"); !errors.Is(err, fieldcrypt.ErrDecrypt) {
+		t.Errorf("expected a value moved to another record to fail, got %v", err)
+	}
+
+	tampered := []byte(a)
+	mid := len(tampered) / 2
+	if tampered[mid] == 'A' {
+		tampered[mid] = 'B'
+	} else {
+		tampered[mid] = 'A'
+	}
+	if _, err := k.Decrypt(string(tampered), "email\x00u1"); err == nil {
+		t.Error("expected a tampered value to fail")
+	}
+	if _, err := k.Decrypt("enc:v1:!!", "email\x00u1"); !errors.Is(err, fieldcrypt.ErrMalformed) {
+		t.Errorf("expected ErrMalformed, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := newKeyring(t, 1)
+	v1, err := old.Encrypt("Ann", "name\x00u1")
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	rotated := newKeyring(t, 1, 2)
+	if rotated.Current() != 2 {
+		t.Fatalf("expected the highest version to be current, got %d", rotated.Current())
+	}
+	if got, err := rotated.Decrypt(v1, "name\x00u1"); err != nil || got != "Ann" {
+		t.Errorf("expected values under an older key to stay readable, got %q, %v", got, err)
+	}
+	if !rotated.ReencryptNeeded(v1) || !rotated.ReencryptNeeded("Ann") {
+		t.Error("expected old and plaintext values to need re-encryption")
+	}
+	v2, _ := rotated.Encrypt("Ann", "name\x00u1")
+	if rotated.ReencryptNeeded(v2) || !strings.HasPrefix(v2, "enc:v2:") {
+		t.Errorf("expected new values under the current key, got %q", v2)
+	}
+
+	retired := newKeyring(t, 2)
+	if _, err := retired.Decrypt(v1, "name\x00u1"); !errors.Is(err, fieldcrypt.ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey once a key is removed, got %v", err)
+	}
+	if old.BlindIndex("ann@example.com") != retired.BlindIndex("ann@example.com") {
+		t.Error("expected blind indexes not to depend on the encryption keys")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	k := newKeyring(t, 1)
+	a := k.BlindIndex("ann@example.com")
+	if a != k.BlindIndex("ann@example.com") {
+		t.Error("expected equal values to have equal indexes")
+	}
+	if a == k.BlindIndex("bob@example.com") {
+		t.Error("expected different values to have different indexes")
+	}
+	other, _ := fieldcrypt.New(map[uint32][]byte{1: key(1)}, key(0xfe))
+	if a == other.BlindIndex("ann@example.com") {
+		t.Error("expected the index to depend on the index key")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString(key(7))
+	keys, err := fieldcrypt.ParseKeys([]string{"1:" + b64, "3:" + b64})
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	if len(keys) != 2 || !bytes.Equal(keys[3], key(7)) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	for _, items := range [][]string{{b64}, {"x:" + b64}, {"1:not base64"}, {"1:" + b64, "1:" + b64}} {
+		if _, err := fieldcrypt.ParseKeys(items); err == nil {
+			t.Errorf("expected %q to be rejected", items)
+		}
+	}
+	if _, err := fieldcrypt.New(map[uint32][]byte{1: key(1)[:16]}, key(0xff)); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+	if _, err := fieldcrypt.New(map[uint32][]byte{0: key(1)}, key(0xff)); err == nil {
+		t.Error("expected version 0 to be rejected")
+	}
+}
@@ -0,0 +1,456 @@
+// Package jsonschema validates decoded JSON against a subset of JSON Schema
+// draft 2020-12.
+//
+// Supported keywords:
+//
+//	type, enum, const
+//	properties, required, additionalProperties, minProperties, maxProperties
+//	items, minItems, maxItems, uniqueItems
+//	minLength, maxLength, pattern
+//	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+//
+// plus boolean schemas and the annotations $schema, $id, $comment, title,
+// description, default, examples, deprecated, readOnly, writeOnly and
+// format (which 2020-12 does not assert by default). Any other keyword,
+// such as $ref or allOf, fails Compile rather than being silently ignored.
+// Patterns use Go's RE2 syntax, which covers the common ECMA-262 subset.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Draft is the only $schema value Compile accepts.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a compiled schema.
+type Schema struct {
+	// bool is set for the boolean schemas true and false.
+	bool *bool
+
+	types    []string
+	enum     []any
+	constant *any
+
+	properties    map[string]*Schema
+	required      []string
+	additional    *Schema
+	minProperties *int
+	maxProperties *int
+
+	items       *Schema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+}
+
+// Error is one way a value fails a schema.
+type Error struct {
+	// Path is a JSON Pointer (RFC 6901) to the failing value, "" for the
+	// value itself.
+	Path    string
+	Message string
+}
+
+func (e Error) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+	"format": true,
+}
+
+var jsonTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+// Compile parses a schema document.
+func Compile(data []byte) (*Schema, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("jsonschema: %w", err)
+	}
+	if obj, ok := doc.(map[string]any); ok {
+		if v, ok := obj["$schema"]; ok && v != Draft {
+			return nil, fmt.Errorf("jsonschema: unsupported $schema %v, want %s", v, Draft)
+		}
+	}
+	return compile(doc, "")
+}
+
+func compile(doc any, at string) (*Schema, error) {
+	if b, ok := doc.(bool); ok {
+		return &Schema{bool: &b}, nil
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil, compileError(at, "a schema must be an object or a boolean")
+	}
+
+	s := &Schema{}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := obj[k]
+		where := at + "/" + escape(k)
+		var err error
+		switch k {
+		case "type":
+			s.types, err = compileTypes(v, where)
+		case "enum":
+			list, ok := v.([]any)
+			if !ok {
+				return nil, compileError(where, "must be an array")
+			}
+			s.enum = list
+		case "const":
+			s.constant = &v
+		case "properties":
+			props, ok := v.(map[string]any)
+			if !ok {
+				return nil, compileError(where, "must be an object")
+			}
+			s.properties = make(map[string]*Schema, len(props))
+			for name, sub := range props {
+				if s.properties[name], err = compile(sub, where+"/"+escape(name)); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			list, ok := v.([]any)
+			if !ok {
+				return nil, compileError(where, "must be an array of strings")
+			}
+			for _, item := range list {
+				name, ok := item.(string)
+				if !ok {
+					return nil, compileError(where, "must be an array of strings")
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			s.additional, err = compile(v, where)
+		case "items":
+			s.items, err = compile(v, where)
+		case "uniqueItems":
+			b, ok := v.(bool)
+			if !ok {
+				return nil, compileError(where, "must be a boolean")
+			}
+			s.uniqueItems = b
+		case "pattern":
+			p, ok := v.(string)
+			if !ok {
+				return nil, compileError(where, "must be a string")
+			}
+			if s.pattern, err = regexp.Compile(p); err != nil {
+				return nil, compileError(where, err.Error())
+			}
+		case "minProperties":
+			s.minProperties, err = count(v, where)
+		case "maxProperties":
+			s.maxProperties, err = count(v, where)
+		case "minItems":
+			s.minItems, err = count(v, where)
+		case "maxItems":
+			s.maxItems, err = count(v, where)
+		case "minLength":
+			s.minLength, err = count(v, where)
+		case "maxLength":
+			s.maxLength, err = count(v, where)
+		case "minimum":
+			s.minimum, err = number(v, where)
+		case "maximum":
+			s.maximum, err = number(v, where)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = number(v, where)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = number(v, where)
+		case "multipleOf":
+			if s.multipleOf, err = number(v, where); err == nil && *s.multipleOf <= 0 {
+				err = compileError(where, "must be greater than 0")
+			}
+		default:
+			if !annotations[k] {
+				return nil, compileError(where, "unsupported keyword")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func compileTypes(v any, at string) ([]string, error) {
+	var names []any
+	switch v := v.(type) {
+	case string:
+		names = []any{v}
+	case []any:
+		names = v
+	default:
+		return nil, compileError(at, "must be a string or an array of strings")
+	}
+	types := make([]string, 0, len(names))
+	for _, n := range names {
+		name, ok := n.(string)
+		if !ok || !jsonTypes[name] {
+			return nil, compileError(at, fmt.Sprintf("unknown type %v", n))
+		}
+		types = append(types, name)
+	}
+	return types, nil
+}
+
+func count(v any, at string) (*int, error) {
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, compileError(at, "must be a non-negative integer")
+	}
+	n := int(f)
+	return &n, nil
+}
+
+func number(v any, at string) (*float64, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return nil, compileError(at, "must be a number")
+	}
+	return &f, nil
+}
+
+func compileError(at, msg string) error {
+	if at == "" {
+		at = "/"
+	}
+	return fmt.Errorf("jsonschema: %s: %s", at, msg)
+}
+
+// Validate checks v, a value as decoded by encoding/json into an any
+// (map[string]any, []any, string, float64, bool or nil), and returns every
+// failure ordered by path. It returns nil if v is valid.
+func (s *Schema) Validate(v any) []Error {
+	var errs []Error
+	s.validate(v, "", &errs)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+func (s *Schema) validate(v any, path string, errs *[]Error) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.bool != nil {
+		if !*s.bool {
+			fail("is not allowed")
+		}
+		return
+	}
+	if len(s.types) > 0 && !hasType(v, s.types) {
+		// Further keywords would only restate the mismatch.
+		fail("must be %s", orList(s.types))
+		return
+	}
+	if s.enum != nil && !contains(s.enum, v) {
+		fail("must be one of %s", encodeList(s.enum))
+	}
+	if s.constant != nil && !reflect.DeepEqual(*s.constant, v) {
+		fail("must be %s", encode(*s.constant))
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %s", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be at least %s", formatNumber(*s.minimum))
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be at most %s", formatNumber(*s.maximum))
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("must be greater than %s", formatNumber(*s.exclusiveMinimum))
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("must be less than %s", formatNumber(*s.exclusiveMaximum))
+		}
+		if s.multipleOf != nil {
+			if q := v / *s.multipleOf; q != math.Trunc(q) {
+				fail("must be a multiple of %s", formatNumber(*s.multipleOf))
+			}
+		}
+	case map[string]any:
+		s.validateObject(v, path, errs, fail)
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.uniqueItems && !unique(v) {
+			fail("must not contain duplicates")
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, path+"/"+strconv.Itoa(i), errs)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateObject(v map[string]any, path string, errs *[]Error, fail func(string, ...any)) {
+	if s.minProperties != nil && len(v) < *s.minProperties {
+		fail("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(v) > *s.maxProperties {
+		fail("must have at most %d properties", *s.maxProperties)
+	}
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			*errs = append(*errs, Error{Path: path + "/" + escape(name), Message: "is required"})
+		}
+	}
+	for name, value := range v {
+		if sub, ok := s.properties[name]; ok {
+			sub.validate(value, path+"/"+escape(name), errs)
+		} else if s.additional != nil {
+			s.additional.validate(value, path+"/"+escape(name), errs)
+		}
+	}
+}
+
+func hasType(v any, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "null":
+			if v == nil {
+				return true
+			}
+		case "boolean":
+			if _, ok := v.(bool); ok {
+				return true
+			}
+		case "object":
+			if _, ok := v.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := v.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := v.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []any, v any) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func unique(list []any) bool {
+	for i := range list {
+		for j := i + 1; j < len(list); j++ {
+			if reflect.DeepEqual(list[i], list[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// orList renders type names for a message: "a string", "a string or null".
+func orList(types []string) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		switch t {
+		case "null":
+			names[i] = "null"
+		case "array", "integer", "object":
+			names[i] = "an " + t
+		default:
+			names[i] = "a " + t
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+func encodeList(list []any) string {
+	parts := make([]string, len(list))
+	for i, v := range list {
+		parts[i] = encode(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func encode(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// escape encodes a property name as a JSON Pointer reference token.
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
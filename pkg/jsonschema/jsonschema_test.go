@@ -0,0 +1,111 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/jsonschema"
+)
+
+const userSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "User metadata",
+	"type": "object",
+	"required": ["plan"],
+	"additionalProperties": false,
+	"properties": {
+		"plan": {"enum": ["free", "pro"]},
+		"department": {"type": "string", "minLength": 2, "maxLength": 32},
+		"locale": {"type": "string", "pattern": "^[a-z]{2}(-[A-Z]{2})?$"},
+		"seats": {"type": "integer", "minimum": 1, "exclusiveMaximum": 1000},
+		"discount": {"type": ["number", "null"], "multipleOf": 0.5},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 3, "uniqueItems": true},
+		"beta": {"const": true}
+	}
+}`
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := jsonschema.Compile([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	valid := `{"plan": "pro", "department": "eng", "locale": "en-GB", "seats": 5, "discount": null, "tags": ["a", "b"], "beta": true}`
+	if errs := schema.Validate(decode(t, valid)); errs != nil {
+		t.Errorf("expected %s to be valid, got %v", valid, errs)
+	}
+
+	tests := []struct {
+		doc  string
+		want []jsonschema.Error
+	}{
+		{`{}`, []jsonschema.Error{{Path: "/plan", Message: "is required"}}},
+		{`[]`, []jsonschema.Error{{Path: "", Message: "must be an object"}}},
+		{`{"plan": "gold"}`, []jsonschema.Error{{Path: "/plan", Message: `must be one of "free", "pro"`}}},
+		{`{"plan": "free", "shoe_size": 42}`, []jsonschema.Error{{Path: "/shoe_size", Message: "is not allowed"}}},
+		{`{"plan": "free", "department": "x"}`, []jsonschema.Error{{Path: "/department", Message: "must be at least 2 characters"}}},
+		{`{"plan": "free", "department": 7}`, []jsonschema.Error{{Path: "/department", Message: "must be a string"}}},
+		{`{"plan": "free", "locale": "english"}`, []jsonschema.Error{{Path: "/locale", Message: "must match ^[a-z]{2}(-[A-Z]{2})?$"}}},
+		{`{"plan": "free", "seats": 1.5}`, []jsonschema.Error{{Path: "/seats", Message: "must be an integer"}}},
+		{`{"plan": "free", "seats": 1000}`, []jsonschema.Error{{Path: "/seats", Message: "must be less than 1000"}}},
+		{`{"plan": "free", "seats": 0}`, []jsonschema.Error{{Path: "/seats", Message: "must be at least 1"}}},
+		{`{"plan": "free", "discount": 0.3}`, []jsonschema.Error{{Path: "/discount", Message: "must be a multiple of 0.5"}}},
+		{`{"plan": "free", "tags": ["a", 1]}`, []jsonschema.Error{{Path: "/tags/1", Message: "must be a string"}}},
+		{`{"plan": "free", "tags": ["a", "a"]}`, []jsonschema.Error{{Path: "/tags", Message: "must not contain duplicates"}}},
+		{`{"plan": "free", "beta": false}`, []jsonschema.Error{{Path: "/beta", Message: "must be true"}}},
+		{`{"department": "", "seats": -1}`, []jsonschema.Error{
+			{Path: "/department", Message: "must be at least 2 characters"},
+			{Path: "/plan", Message: "is required"},
+			{Path: "/seats", Message: "must be at least 1"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := schema.Validate(decode(t, tt.doc)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Validate(%s):\n got %v\nwant %v", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	for _, doc := range []string{
+		`{"$ref": "#/$defs/x"}`,
+		`{"allOf": [{}]}`,
+		`{"type": "text"}`,
+		`{"minLength": -1}`,
+		`{"multipleOf": 0}`,
+		`{"pattern": "("}`,
+		`{"properties": {"a": 1}}`,
+		`{"$schema": "http://json-schema.org/draft-07/schema#"}`,
+		`not json`,
+	} {
+		_, err := jsonschema.Compile([]byte(doc))
+		if err == nil || !strings.HasPrefix(err.Error(), "jsonschema: ") {
+			t.Errorf("expected %s to be rejected, got %v", doc, err)
+		}
+	}
+}
+
+func TestBooleanSchemas(t *testing.T) {
+	schema, err := jsonschema.Compile([]byte(`{"properties": {"anything": true, "nothing": false}}`))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if errs := schema.Validate(decode(t, `{"anything": [1, {"a": null}]}`)); errs != nil {
+		t.Errorf("expected true to accept anything, got %v", errs)
+	}
+	want := []jsonschema.Error{{Path: "/nothing", Message: "is not allowed"}}
+	if got := schema.Validate(decode(t, `{"nothing": 1}`)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected false to reject any value, got %v", got)
+	}
+}
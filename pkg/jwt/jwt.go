@@ -1,6 +1,8 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -12,44 +14,90 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
-// Claims represents the JWT claims.
+// Claims represents the JWT claims. Every token gets a unique ID (the jti,
+// RegisteredClaims.ID) so that it can be revoked on its own.
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID   string   `json:"user_id"`
+	Email    string   `json:"email"`
+	TenantID string   `json:"tenant_id,omitempty"` // empty for the default tenant
+	Roles    []string `json:"roles,omitempty"`     // the user's roles when the token was issued
 	jwt.RegisteredClaims
 }
 
+// InviteClaims are the claims of an invitation to join a tenant.
+type InviteClaims struct {
+	TenantID string `json:"tenant_id"`
+	Email    string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// inviteAudience marks invitation tokens. Access tokens carry no audience,
+// so neither kind of token is accepted in place of the other.
+const inviteAudience = "invite"
+
 // Service handles JWT operations.
 type Service struct {
 	secret     []byte
+	signing    *Key
+	keys       []*Key // signing first, then the verification keys
 	expiration time.Duration
 	issuer     string
 }
 
-// Config holds JWT configuration.
+// Config holds JWT configuration. Tokens are signed with HS256 and Secret
+// unless SigningKey is set; then HS256 tokens are no longer accepted and
+// each token names its key in the kid header.
 type Config struct {
 	Secret     string
 	Expiration time.Duration
 	Issuer     string
+	// SigningKey signs new tokens and must hold a private key.
+	SigningKey *Key
+	// VerificationKeys are accepted besides SigningKey, such as the keys
+	// it replaced, so that rotating keys does not invalidate live tokens.
+	VerificationKeys []*Key
 }
 
 // NewService creates a new JWT service.
 func NewService(cfg Config) *Service {
-	return &Service{
+	s := &Service{
 		secret:     []byte(cfg.Secret),
+		signing:    cfg.SigningKey,
 		expiration: cfg.Expiration,
 		issuer:     cfg.Issuer,
 	}
+	if s.signing != nil {
+		s.keys = append([]*Key{s.signing}, cfg.VerificationKeys...)
+	}
+	return s
 }
 
-// GenerateToken creates a new JWT token for a user.
-func (s *Service) GenerateToken(userID, email string) (string, error) {
+// JWKS returns the public keys tokens are verified with. It is empty when
+// tokens are signed with a shared secret.
+func (s *Service) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(s.keys))}
+	for _, k := range s.keys {
+		set.Keys = append(set.Keys, k.JWK())
+	}
+	return set
+}
+
+// GenerateToken creates a new JWT token for a user of the given tenant who
+// holds roles.
+func (s *Service) GenerateToken(userID, email, tenantID string, roles []string) (string, error) {
 	now := time.Now()
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
 
 	claims := Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:   userID,
+		Email:    email,
+		TenantID: tenantID,
+		Roles:    roles,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
 			Issuer:    s.issuer,
 			Subject:   userID,
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -58,33 +106,113 @@ func (s *Service) GenerateToken(userID, email string) (string, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.secret)
+	return s.sign(claims)
 }
 
 // ValidateToken parses and validates a JWT token.
 func (s *Service) ValidateToken(tokenString string) (*Claims, error) {
-	parser := jwt.NewParser(
-		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+	claims := &Claims{}
+	if err := s.parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if len(claims.Audience) > 0 {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// GenerateInvite creates a token inviting email to join a tenant. It
+// expires after ttl.
+func (s *Service) GenerateInvite(tenantID, email string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	claims := InviteClaims{
+		TenantID: tenantID,
+		Email:    email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   email,
+			Audience:  jwt.ClaimStrings{inviteAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	}
+
+	token, err := s.sign(claims)
+	return token, expiresAt, err
+}
+
+// ValidateInvite parses and validates an invitation token.
+func (s *Service) ValidateInvite(tokenString string) (*InviteClaims, error) {
+	claims := &InviteClaims{}
+	if err := s.parse(tokenString, claims, jwt.WithAudience(inviteAudience)); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// newTokenID returns 128 random bits, hex encoded.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Service) sign(claims jwt.Claims) (string, error) {
+	if s.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	if !s.signing.CanSign() {
+		return "", errors.New("jwt: signing key has no private key")
+	}
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.private)
+}
+
+// verificationKey returns the key that verifies token: the secret, or the
+// key named by its kid header if that key uses the token's algorithm.
+func (s *Service) verificationKey(token *jwt.Token) (interface{}, error) {
+	if s.signing == nil {
+		return s.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	for _, k := range s.keys {
+		if k.ID == kid && k.Method.Alg() == token.Method.Alg() {
+			return k.public, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+func (s *Service) parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	var methods []string
+	for _, k := range s.keys {
+		methods = append(methods, k.Method.Alg())
+	}
+	if s.signing == nil {
+		methods = []string{jwt.SigningMethodHS256.Name}
+	}
+	parser := jwt.NewParser(append([]jwt.ParserOption{
+		jwt.WithValidMethods(methods),
 		jwt.WithIssuer(s.issuer),
 		jwt.WithExpirationRequired(),
-	)
+	}, opts...)...)
 
-	token, err := parser.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.secret, nil
-	})
+	token, err := parser.ParseWithClaims(tokenString, claims, s.verificationKey)
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
-			return nil, ErrExpiredToken
+			return ErrExpiredToken
 		}
-		return nil, ErrInvalidToken
+		return ErrInvalidToken
 	}
-
-	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
-		return nil, ErrInvalidToken
+	if !token.Valid {
+		return ErrInvalidToken
 	}
-
-	return claims, nil
+	return nil
 }
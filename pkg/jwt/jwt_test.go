@@ -1,6 +1,19 @@
 package jwt_test
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -15,7 +28,7 @@ func TestGenerateAndValidateToken(t *testing.T) {
 	})
 
 	// Generate token
-	token, err := svc.GenerateToken("user-123", "test@example.com")
+	token, err := svc.GenerateToken("user-123", "test@example.com", "org-1", []string{"admin"})
 	if err != nil {
 		t.Fatalf("failed to generate token: %v", err)
 	}
@@ -37,6 +50,23 @@ func TestGenerateAndValidateToken(t *testing.T) {
 	if claims.Email != "test@example.com" {
 		t.Errorf("expected email 'test@example.com', got '%s'", claims.Email)
 	}
+
+	if claims.TenantID != "org-1" {
+		t.Errorf("expected tenant ID 'org-1', got '%s'", claims.TenantID)
+	}
+
+	if len(claims.Roles) != 1 || claims.Roles[0] != "admin" {
+		t.Errorf("expected roles [admin], got %v", claims.Roles)
+	}
+
+	other, _ := svc.GenerateToken("user-123", "test@example.com", "org-1", nil)
+	otherClaims, err := svc.ValidateToken(other)
+	if err != nil {
+		t.Fatalf("failed to validate token: %v", err)
+	}
+	if claims.ID == "" || claims.ID == otherClaims.ID {
+		t.Errorf("expected a unique jti per token, got %q and %q", claims.ID, otherClaims.ID)
+	}
 }
 
 func TestInvalidToken(t *testing.T) {
@@ -59,7 +89,7 @@ func TestExpiredToken(t *testing.T) {
 		Issuer:     "test",
 	})
 
-	token, err := svc.GenerateToken("user-123", "test@example.com")
+	token, err := svc.GenerateToken("user-123", "test@example.com", "", nil)
 	if err != nil {
 		t.Fatalf("failed to generate token: %v", err)
 	}
@@ -70,3 +100,137 @@ func TestExpiredToken(t *testing.T) {
 	}
 }
 
+func TestInviteToken(t *testing.T) {
+	svc := jwt.NewService(jwt.Config{
+		Secret:     "test-secret",
+		Expiration: time.Hour,
+		Issuer:     "test",
+	})
+
+	invite, expiresAt, err := svc.GenerateInvite("org-1", "new@example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate invite: %v", err)
+	}
+	if time.Until(expiresAt) < 59*time.Minute {
+		t.Errorf("expected the invite to expire in an hour, got %v", expiresAt)
+	}
+
+	claims, err := svc.ValidateInvite(invite)
+	if err != nil {
+		t.Fatalf("failed to validate invite: %v", err)
+	}
+	if claims.TenantID != "org-1" || claims.Email != "new@example.com" {
+		t.Errorf("unexpected invite claims: %+v", claims)
+	}
+
+	// Neither kind of token stands in for the other.
+	if _, err := svc.ValidateToken(invite); err != jwt.ErrInvalidToken {
+		t.Errorf("expected invite to be rejected as an access token, got %v", err)
+	}
+	token, _ := svc.GenerateToken("user-123", "test@example.com", "org-1", nil)
+	if _, err := svc.ValidateInvite(token); err != jwt.ErrInvalidToken {
+		t.Errorf("expected access token to be rejected as an invite, got %v", err)
+	}
+}
+
+// writeKey writes key as a PKCS #8 PEM file and returns its path.
+func writeKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return path
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for alg, signer := range map[string]crypto.Signer{"RS256": rsaKey, "ES256": ecKey, "EdDSA": edKey} {
+		key, err := jwt.LoadKeyFile(writeKey(t, signer))
+		if err != nil {
+			t.Fatalf("%s: LoadKeyFile: %v", alg, err)
+		}
+		if key.Method.Alg() != alg || !key.CanSign() {
+			t.Fatalf("%s: got method %s, can sign %v", alg, key.Method.Alg(), key.CanSign())
+		}
+
+		svc := jwt.NewService(jwt.Config{Expiration: time.Hour, Issuer: "test", SigningKey: key})
+		token, err := svc.GenerateToken("user-123", "test@example.com", "", nil)
+		if err != nil {
+			t.Fatalf("%s: GenerateToken: %v", alg, err)
+		}
+		if claims, err := svc.ValidateToken(token); err != nil || claims.UserID != "user-123" {
+			t.Errorf("%s: ValidateToken: %+v, %v", alg, claims, err)
+		}
+
+		// Verifying only needs the public key.
+		der, _ := x509.MarshalPKIXPublicKey(signer.Public())
+		public, err := jwt.ParseKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		if err != nil || public.CanSign() || public.ID != key.ID {
+			t.Fatalf("%s: ParseKey(public): %+v, %v", alg, public, err)
+		}
+		verifier := jwt.NewService(jwt.Config{Expiration: time.Hour, Issuer: "test", SigningKey: public})
+		if _, err := verifier.ValidateToken(token); err != nil {
+			t.Errorf("%s: expected the public key to verify, got %v", alg, err)
+		}
+		if _, err := verifier.GenerateToken("user-123", "test@example.com", "", nil); err == nil {
+			t.Errorf("%s: expected a public key not to sign", alg)
+		}
+	}
+
+	// The kid is the RFC 7638 thumbprint (example from the RFC, section 3.1).
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	rfcKey, err := jwt.NewPublicKey(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	if err != nil || rfcKey.ID != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected thumbprint %q, %v", rfcKey.ID, err)
+	}
+
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if _, err := jwt.NewKey(small); !errors.Is(err, jwt.ErrUnsupportedKey) {
+		t.Errorf("expected a 1024-bit RSA key to be rejected, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldSigner, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, newSigner, _ := ed25519.GenerateKey(rand.Reader)
+	oldKey, _ := jwt.NewKey(oldSigner)
+	newKey, _ := jwt.NewKey(newSigner)
+
+	before := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test", SigningKey: oldKey})
+	live, _ := before.GenerateToken("user-123", "test@example.com", "", nil)
+	invite, _, _ := before.GenerateInvite("org-1", "new@example.com", time.Hour)
+
+	after := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test", SigningKey: newKey, VerificationKeys: []*jwt.Key{oldKey}})
+	if _, err := after.ValidateToken(live); err != nil {
+		t.Errorf("expected a token signed with the old key to stay valid, got %v", err)
+	}
+	if _, err := after.ValidateInvite(invite); err != nil {
+		t.Errorf("expected an invite signed with the old key to stay valid, got %v", err)
+	}
+	fresh, _ := after.GenerateToken("user-123", "test@example.com", "", nil)
+	if _, err := before.ValidateToken(fresh); err != jwt.ErrInvalidToken {
+		t.Errorf("expected a token with an unknown kid to be rejected, got %v", err)
+	}
+
+	set := after.JWKS()
+	if len(set.Keys) != 2 || set.Keys[0].KeyID != newKey.ID || set.Keys[0].Algorithm != "EdDSA" || set.Keys[1].KeyID != oldKey.ID || set.Keys[1].Curve != "P-256" {
+		t.Errorf("unexpected key set %+v", set)
+	}
+
+	// With a signing key, tokens signed with the secret are refused.
+	hs256, _ := jwt.NewService(jwt.Config{Secret: "test-secret", Expiration: time.Hour, Issuer: "test"}).GenerateToken("user-123", "test@example.com", "", nil)
+	if _, err := after.ValidateToken(hs256); err != jwt.ErrInvalidToken {
+		t.Errorf("expected an HS256 token to be rejected, got %v", err)
+	}
+	if len(jwt.NewService(jwt.Config{Secret: "test-secret"}).JWKS().Keys) != 0 {
+		t.Error("expected no public keys for a shared secret")
+	}
+}
@@ -0,0 +1,192 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrUnsupportedKey is returned for keys other than RSA (at least 2048
+// bits), ECDSA on P-256 and Ed25519.
+var ErrUnsupportedKey = errors.New("jwt: unsupported key")
+
+// Key is an asymmetric key that signs or verifies tokens: RSA keys use
+// RS256, P-256 keys ES256 and Ed25519 keys EdDSA. Its ID, the kid header of
+// the tokens it signs, is the RFC 7638 thumbprint of the public key, so it
+// does not have to be configured.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	private crypto.Signer // nil for keys that only verify
+	public  crypto.PublicKey
+	jwk     JWK
+}
+
+// JWK is the public part of a Key as a JSON Web Key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set, as served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKeyFile reads a PEM encoded key; see ParseKey.
+func LoadKeyFile(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ParseKey parses a PEM encoded private key (PKCS #8, PKCS #1 or SEC 1),
+// which can sign and verify, or public key (PKIX or PKCS #1), which can
+// only verify.
+func ParseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt: no PEM data found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("jwt: unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt: parse key: %w", err)
+	}
+
+	if signer, ok := parsed.(crypto.Signer); ok {
+		return NewKey(signer)
+	}
+	return NewPublicKey(parsed)
+}
+
+// NewKey returns a Key that signs with private.
+func NewKey(private crypto.Signer) (*Key, error) {
+	k, err := NewPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	k.private = private
+	return k, nil
+}
+
+// NewPublicKey returns a Key that only verifies.
+func NewPublicKey(public crypto.PublicKey) (*Key, error) {
+	k := &Key{public: public}
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RSA keys must have at least 2048 bits", ErrUnsupportedKey)
+		}
+		k.Method = jwt.SigningMethodRS256
+		k.jwk = JWK{KeyType: "RSA", N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: ECDSA keys must use P-256", ErrUnsupportedKey)
+		}
+		ecdh, err := pub.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		point := ecdh.Bytes() // 0x04 || X || Y
+		k.Method = jwt.SigningMethodES256
+		k.jwk = JWK{KeyType: "EC", Curve: "P-256", X: b64(point[1:33]), Y: b64(point[33:])}
+	case ed25519.PublicKey:
+		k.Method = jwt.SigningMethodEdDSA
+		k.jwk = JWK{KeyType: "OKP", Curve: "Ed25519", X: b64(pub)}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, public)
+	}
+
+	k.ID = thumbprint(k.jwk)
+	k.jwk.KeyID = k.ID
+	k.jwk.Algorithm = k.Method.Alg()
+	k.jwk.Use = "sig"
+	return k, nil
+}
+
+// CanSign reports whether k holds a private key.
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// JWK returns the public part of k.
+func (k *Key) JWK() JWK {
+	return k.jwk
+}
+
+// thumbprint is the RFC 7638 thumbprint of jwk: the SHA-256 of its required
+// members, in lexicographic order and without whitespace.
+func thumbprint(jwk JWK) string {
+	var members any
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+	data, _ := json.Marshal(members)
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
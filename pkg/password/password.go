@@ -0,0 +1,187 @@
+// Package password hashes and verifies passwords. A Hasher produces hashes
+// with one preferred Scheme but verifies hashes from any of the schemes it
+// knows, and tells callers when a stored hash should be replaced because
+// it was made with another scheme or weaker parameters.
+//
+// Argon2id hashes use the PHC string format,
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// with the salt and hash in unpadded standard base64. bcrypt hashes use
+// their usual $2a$/$2b$ form.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrMalformed is returned for a hash a Scheme recognizes but cannot parse.
+var ErrMalformed = errors.New("password: malformed hash")
+
+// Scheme is one password hashing algorithm with fixed parameters.
+type Scheme interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash, which the scheme
+	// recognizes.
+	Verify(hash, password string) (bool, error)
+	// Recognizes reports whether hash was made by this algorithm, whatever
+	// its parameters.
+	Recognizes(hash string) bool
+	// Current reports whether hash was made with exactly this scheme's
+	// parameters.
+	Current(hash string) bool
+}
+
+// Hasher hashes with a preferred scheme and verifies with any known one.
+type Hasher struct {
+	preferred Scheme
+	schemes   []Scheme
+}
+
+// New returns a Hasher that hashes with preferred and also verifies hashes
+// made by others.
+func New(preferred Scheme, others ...Scheme) *Hasher {
+	return &Hasher{preferred: preferred, schemes: append([]Scheme{preferred}, others...)}
+}
+
+// Default returns a Hasher that hashes with DefaultArgon2id and still
+// verifies bcrypt hashes.
+func Default() *Hasher {
+	return New(DefaultArgon2id, Bcrypt{Cost: bcrypt.DefaultCost})
+}
+
+// Hash hashes password with the preferred scheme.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+// Verify reports whether password matches hash and, if it does, whether
+// hash should be replaced by a fresh Hash of password. Unknown or malformed
+// hashes never match.
+func (h *Hasher) Verify(hash, password string) (ok, rehash bool) {
+	for _, s := range h.schemes {
+		if !s.Recognizes(hash) {
+			continue
+		}
+		ok, err := s.Verify(hash, password)
+		if err != nil || !ok {
+			return false, false
+		}
+		return true, !(h.preferred.Recognizes(hash) && h.preferred.Current(hash))
+	}
+	return false, false
+}
+
+// Argon2id is the argon2id scheme. Memory is in KiB.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2id follows the OWASP recommendation of 19 MiB of memory,
+// two iterations and one degree of parallelism.
+var DefaultArgon2id = Argon2id{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+const argon2Prefix = "$argon2id$"
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(hash, password string) (bool, error) {
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	got := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1, nil
+}
+
+func (a Argon2id) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, argon2Prefix)
+}
+
+func (a Argon2id) Current(hash string) bool {
+	p, _, _, err := parseArgon2id(hash)
+	return err == nil && p == a
+}
+
+// parseArgon2id splits a PHC argon2id hash into its parameters, salt and
+// key. The returned parameters include the salt and key lengths.
+func parseArgon2id(hash string) (Argon2id, []byte, []byte, error) {
+	var p Argon2id
+	parts := strings.Split(hash, "$")
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrMalformed
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrMalformed
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrMalformed
+	}
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, ErrMalformed
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return p, nil, nil, ErrMalformed
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrMalformed
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
+
+// Bcrypt is the bcrypt scheme.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+func (b Bcrypt) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	default:
+		return false, ErrMalformed
+	}
+}
+
+func (b Bcrypt) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b Bcrypt) Current(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost == b.Cost
+}
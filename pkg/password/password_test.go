@@ -0,0 +1,72 @@
+package password_test
+
+import (
+	"regexp"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/muflihunaf/boilerplate-go/pkg/password"
+)
+
+// weak keeps the tests fast; the format does not depend on the cost.
+var weak = password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idPHC(t *testing.T) {
+	h := password.New(weak)
+	hash, err := h.Hash("secret123")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if !regexp.MustCompile(`^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`).MatchString(hash) {
+		t.Errorf("unexpected hash format %q", hash)
+	}
+	if again, _ := h.Hash("secret123"); again == hash {
+		t.Error("expected a random salt")
+	}
+
+	if ok, rehash := h.Verify(hash, "secret123"); !ok || rehash {
+		t.Errorf("Verify(correct): got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, _ := h.Verify(hash, "wrong"); ok {
+		t.Error("expected a wrong password to fail")
+	}
+	for _, bad := range []string{"", "plain", "$argon2id$v=19$m=64,t=1,p=1$!!$!!", "$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5"} {
+		if ok, _ := h.Verify(bad, "secret123"); ok {
+			t.Errorf("expected %q not to verify", bad)
+		}
+	}
+}
+
+func TestRehash(t *testing.T) {
+	bcryptHash, err := password.Bcrypt{Cost: bcrypt.MinCost}.Hash("secret123")
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	oldHash, _ := password.New(weak).Hash("secret123")
+	stronger := weak
+	stronger.Iterations = 2
+
+	h := password.New(stronger, password.Bcrypt{Cost: bcrypt.MinCost})
+	if ok, rehash := h.Verify(bcryptHash, "secret123"); !ok || !rehash {
+		t.Errorf("bcrypt hash: got ok=%v rehash=%v, want a rehash", ok, rehash)
+	}
+	if ok, rehash := h.Verify(oldHash, "secret123"); !ok || !rehash {
+		t.Errorf("argon2id hash with old parameters: got ok=%v rehash=%v, want a rehash", ok, rehash)
+	}
+	if ok, rehash := h.Verify(oldHash, "wrong"); ok || rehash {
+		t.Errorf("wrong password: got ok=%v rehash=%v", ok, rehash)
+	}
+
+	if ok, _ := password.New(stronger).Verify(bcryptHash, "secret123"); ok {
+		t.Error("expected a hasher without bcrypt to reject bcrypt hashes")
+	}
+
+	bcryptOnly := password.New(password.Bcrypt{Cost: bcrypt.MinCost + 1}, weak)
+	if ok, rehash := bcryptOnly.Verify(oldHash, "secret123"); !ok || !rehash {
+		t.Errorf("argon2id hash under a bcrypt hasher: got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, rehash := bcryptOnly.Verify(bcryptHash, "secret123"); !ok || !rehash {
+		t.Errorf("bcrypt hash with a lower cost: got ok=%v rehash=%v", ok, rehash)
+	}
+}
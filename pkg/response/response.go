@@ -5,6 +5,7 @@ package response
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 )
 
 // Response is the standard API response envelope.
@@ -24,10 +25,30 @@ type ErrorInfo struct {
 
 // Meta contains pagination info.
 type Meta struct {
-	Page       int   `json:"page"`
-	PerPage    int   `json:"per_page"`
-	Total      int64 `json:"total"`
-	TotalPages int   `json:"total_pages"`
+	Page       int    `json:"page"`
+	PerPage    int    `json:"per_page"`
+	Total      int64  `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// NewMeta builds pagination info, deriving TotalPages from total and perPage.
+func NewMeta(page, perPage int, total int64) *Meta {
+	totalPages := 0
+	if perPage > 0 {
+		totalPages = int(total) / perPage
+		if int(total)%perPage > 0 {
+			totalPages++
+		}
+	}
+	return &Meta{Page: page, PerPage: perPage, Total: total, TotalPages: totalPages}
+}
+
+// Link is a single RFC 8288 web link.
+type Link struct {
+	URL string
+	Rel string
 }
 
 // --- Success Responses ---
@@ -49,15 +70,23 @@ func NoContent(w http.ResponseWriter) {
 }
 
 func Paginated(w http.ResponseWriter, data interface{}, page, perPage int, total int64) {
-	totalPages := int(total) / perPage
-	if int(total)%perPage > 0 {
-		totalPages++
+	WithMeta(w, data, NewMeta(page, perPage, total))
+}
+
+func WithMeta(w http.ResponseWriter, data interface{}, meta *Meta) {
+	write(w, http.StatusOK, Response{Success: true, Data: data, Meta: meta})
+}
+
+// SetLinks writes an RFC 8288 Link header. Call it before writing the body.
+func SetLinks(w http.ResponseWriter, links ...Link) {
+	if len(links) == 0 {
+		return
 	}
-	write(w, http.StatusOK, Response{
-		Success: true,
-		Data:    data,
-		Meta:    &Meta{Page: page, PerPage: perPage, Total: total, TotalPages: totalPages},
-	})
+	parts := make([]string, len(links))
+	for i, l := range links {
+		parts[i] = "<" + l.URL + `>; rel="` + l.Rel + `"`
+	}
+	w.Header().Set("Link", strings.Join(parts, ", "))
 }
 
 // --- Error Responses ---
@@ -89,6 +118,14 @@ func Conflict(w http.ResponseWriter, msg string) {
 	Error(w, http.StatusConflict, "CONFLICT", msg)
 }
 
+func PreconditionFailed(w http.ResponseWriter, msg string) {
+	Error(w, http.StatusPreconditionFailed, "PRECONDITION_FAILED", msg)
+}
+
+func PreconditionRequired(w http.ResponseWriter, msg string) {
+	Error(w, http.StatusPreconditionRequired, "PRECONDITION_REQUIRED", msg)
+}
+
 func ValidationError(w http.ResponseWriter, msg string, details map[string]string) {
 	write(w, http.StatusUnprocessableEntity, Response{
 		Success: false,